@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/notmiguelalves/anypipe/pkg/history"
+)
+
+const historyUsage = `usage: anypipe history [-dir dir] <command> [arguments]
+
+commands:
+  list    [-pipeline name] [-since 7d] [-limit 20]   list runs, most recent first
+  show    <run id>                                   show the jobs and steps of a run
+  stats   [-pipeline name] [-since 7d] [-limit n]    pass rate, p50/p95 durations and flakiness of each step
+`
+
+func historyCmd(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, historyUsage) }
+	dir := fs.String("dir", history.DefaultDir, "directory the runs are recorded in")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s := history.NewStore(*dir)
+	var err error
+	switch fs.Arg(0) {
+	case "list":
+		err = historyList(s, fs.Args()[1:], stdout, stderr)
+	case "show":
+		err = historyShow(s, fs.Args()[1:], stdout)
+	case "stats":
+		err = historyStats(s, fs.Args()[1:], stdout, stderr)
+	default:
+		err = usageError(fmt.Sprintf("unknown history command %s", fs.Arg(0)))
+	}
+
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		fmt.Fprintf(stderr, "%s\n%s", ue, historyUsage)
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// parses the flags selecting runs
+func filterFlags(name string, args []string, stderr io.Writer, limit int) (history.Filter, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pipeline := fs.String("pipeline", "", "only runs of this pipeline")
+	since := fs.String("since", "", "only runs started since, a duration (36h, 7d) or a date (2006-01-02)")
+	fs.IntVar(&limit, "limit", limit, "only the most recent runs, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return history.Filter{}, usageError(err.Error())
+	}
+
+	f := history.Filter{Pipeline: *pipeline, Limit: limit}
+	if len(*since) > 0 {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return f, usageError(err.Error())
+		}
+		f.Since = t
+	}
+
+	return f, nil
+}
+
+// parses a duration before now, with d for days, or a date
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid -since %s, expected a duration (36h, 7d) or a date (2006-01-02)", s)
+}
+
+func historyList(s *history.Store, args []string, stdout, stderr io.Writer) error {
+	f, err := filterFlags("list", args, stderr, 20)
+	if err != nil {
+		return err
+	}
+
+	runs, err := s.Runs(f)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(stdout)
+	t.AppendHeader(table.Row{"ID", "Pipeline", "Result", "Started", "Duration", "Commit", "Branch"})
+	for _, r := range runs {
+		t.AppendRow(table.Row{r.ID, r.Pipeline, r.Result, r.Start.Local().Format(time.DateTime), r.Duration.Round(time.Millisecond), shortSHA(r.CommitSHA), r.Branch})
+	}
+	t.Render()
+
+	return nil
+}
+
+func historyShow(s *history.Store, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return usageError("show takes the id of a run")
+	}
+
+	r, err := s.Run(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "run:      %s\npipeline: %s\nresult:   %s\nstarted:  %s\nduration: %s\n",
+		r.ID, r.Pipeline, r.Result, r.Start.Local().Format(time.DateTime), r.Duration.Round(time.Millisecond))
+	if len(r.CommitSHA) > 0 {
+		fmt.Fprintf(stdout, "commit:   %s\n", r.CommitSHA)
+	}
+	if len(r.Branch) > 0 {
+		fmt.Fprintf(stdout, "branch:   %s\n", r.Branch)
+	}
+	if len(r.Error) > 0 {
+		fmt.Fprintf(stdout, "error:    %s\n", r.Error)
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(stdout)
+	t.AppendHeader(table.Row{"Result", "Job", "Step", "Duration", "Reason"})
+	for _, j := range r.Jobs {
+		t.AppendRow(table.Row{j.Result, j.Name, "", j.Duration.Round(time.Millisecond), j.Reason})
+		for _, step := range j.Steps {
+			t.AppendRow(table.Row{step.Result, "", step.Name, step.Duration.Round(time.Millisecond), step.Error})
+		}
+	}
+	t.Render()
+
+	return nil
+}
+
+func historyStats(s *history.Store, args []string, stdout, stderr io.Writer) error {
+	f, err := filterFlags("stats", args, stderr, 0)
+	if err != nil {
+		return err
+	}
+
+	stats, err := s.StepStats(f)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(stdout)
+	t.AppendHeader(table.Row{"Pipeline", "Job", "Step", "Runs", "Pass rate", "P50", "P95", "Flakiness"})
+	for _, st := range stats {
+		t.AppendRow(table.Row{
+			st.Pipeline, st.Job, st.Step, st.Runs,
+			fmt.Sprintf("%.0f%%", st.PassRate*100),
+			st.P50.Round(time.Millisecond), st.P95.Round(time.Millisecond),
+			fmt.Sprintf("%.2f", st.Flakiness),
+		})
+	}
+	t.Render()
+
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+
+	return sha
+}
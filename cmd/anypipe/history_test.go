@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/history"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryCmd(t *testing.T) {
+	dir := t.TempDir()
+	s := history.NewStore(dir)
+	start := time.Now().Add(-time.Hour)
+	for i, result := range []string{"PASS", "FAIL", "PASS"} {
+		_, err := s.Record(history.Run{
+			ID:        "run" + string(rune('a'+i)),
+			Pipeline:  "ci",
+			Result:    result,
+			Start:     start.Add(time.Duration(i) * time.Minute),
+			Duration:  time.Minute,
+			CommitSHA: "0123456789abcdef",
+			Jobs: []history.Job{{Name: "test", Result: result, Duration: time.Minute, Steps: []history.Step{
+				{Name: "unit", Result: result, Duration: time.Duration(i+1) * time.Second, Error: map[string]string{"FAIL": "3 tests failed"}[result]},
+			}}},
+		})
+		assert.NoError(t, err)
+	}
+
+	type testcase struct {
+		name             string
+		args             []string
+		expectedCode     int
+		expectedStdout   []string
+		unexpectedStdout []string
+		expectedStderr   string
+	}
+
+	testcases := []testcase{
+		{
+			name:           "list",
+			args:           []string{"history", "-dir", dir, "list"},
+			expectedStdout: []string{"runa", "runb", "runc", "01234567", "FAIL"},
+		},
+		{
+			name:             "list with limit",
+			args:             []string{"history", "-dir", dir, "list", "-limit", "1"},
+			expectedStdout:   []string{"runc"},
+			unexpectedStdout: []string{"runa", "runb"},
+		},
+		{
+			name:             "list since",
+			args:             []string{"history", "-dir", dir, "list", "-since", "1d", "-pipeline", "release"},
+			unexpectedStdout: []string{"runa"},
+		},
+		{
+			name:           "show",
+			args:           []string{"history", "-dir", dir, "show", "runb"},
+			expectedStdout: []string{"run:      runb", "commit:   0123456789abcdef", "unit", "3 tests failed"},
+		},
+		{
+			name:           "stats",
+			args:           []string{"history", "-dir", dir, "stats"},
+			expectedStdout: []string{"unit", "67%", "2s", "3s", "1.00"},
+		},
+		{
+			name:           "unknown run",
+			args:           []string{"history", "-dir", dir, "show", "nope"},
+			expectedCode:   1,
+			expectedStderr: "run not found: nope",
+		},
+		{
+			name:           "show without id",
+			args:           []string{"history", "-dir", dir, "show"},
+			expectedCode:   2,
+			expectedStderr: "show takes the id of a run",
+		},
+		{
+			name:           "invalid since",
+			args:           []string{"history", "-dir", dir, "stats", "-since", "yesterday"},
+			expectedCode:   2,
+			expectedStderr: "invalid -since yesterday",
+		},
+		{
+			name:           "unknown history command",
+			args:           []string{"history", "-dir", dir, "prune"},
+			expectedCode:   2,
+			expectedStderr: "unknown history command prune",
+		},
+		{
+			name:           "unknown command",
+			args:           []string{"deploy"},
+			expectedCode:   2,
+			expectedStderr: "unknown command deploy",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+			assert.Equal(t, tc.expectedCode, run(tc.args, stdout, stderr))
+			for _, s := range tc.expectedStdout {
+				assert.Contains(t, stdout.String(), s)
+			}
+			for _, s := range tc.unexpectedStdout {
+				assert.NotContains(t, stdout.String(), s)
+			}
+			assert.Contains(t, stderr.String(), tc.expectedStderr)
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 8, 10, 12, 0, 0, 0, time.Local)
+
+	type testcase struct {
+		name     string
+		since    string
+		expected time.Time
+		err      bool
+	}
+
+	testcases := []testcase{
+		{name: "days", since: "7d", expected: time.Date(2024, 8, 3, 12, 0, 0, 0, time.Local)},
+		{name: "duration", since: "90m", expected: time.Date(2024, 8, 10, 10, 30, 0, 0, time.Local)},
+		{name: "date", since: "2024-08-01", expected: time.Date(2024, 8, 1, 0, 0, 0, 0, time.Local)},
+		{name: "invalid", since: "last week", err: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			since, err := parseSince(tc.since, now)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tc.expected.Equal(since), since)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+// anypipe is the command line companion of pipelines built with the anypipe package
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: anypipe <command> [arguments]
+
+commands:
+  history   list, show and analyse the runs recorded with WithHistory
+
+steps are Go functions, so pipelines are served and run on remote agents by the pipeline program itself, see
+server.Command for its serve command and anypipe.AgentCommand for its agent command
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// runs the command given by args, and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "history":
+		return historyCmd(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %s\n%s", args[0], usage)
+		return 2
+	}
+}
@@ -0,0 +1,69 @@
+package anypipe
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+)
+
+const agentUsage = `usage: %s agent -coordinator url [-token token] [-name name] [-slots n]
+
+runs the jobs the coordinator assigns to this agent, -slots at a time, until the pipeline's context is done.
+the token defaults to $ANYPIPE_TOKEN and the name to the host name
+`
+
+// runs the agent command of a pipeline program, args being the program's arguments, and returns the exit code.
+// the agent runs the jobs of p, see RunAgent:
+//
+//	func main() {
+//		p := buildPipeline(ctx, logger)
+//		if len(os.Args) > 1 && os.Args[1] == "agent" {
+//			os.Exit(anypipe.AgentCommand(p, os.Args[1:], os.Stdout, os.Stderr))
+//		}
+//		...
+//	}
+func AgentCommand(p Anypipe, args []string, stdout, stderr io.Writer) int {
+	return agentCommand(args, stdout, stderr, p.RunAgent)
+}
+
+func agentCommand(args []string, stdout, stderr io.Writer, runAgent func(a *remote.Agent) error) int {
+	usage := func(w io.Writer) {
+		fmt.Fprintf(w, agentUsage, os.Args[0])
+	}
+
+	switch {
+	case len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help"):
+		usage(stdout)
+		return 0
+	case len(args) == 0 || args[0] != "agent":
+		usage(stderr)
+		return 2
+	}
+
+	hostname, _ := os.Hostname()
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { usage(stderr) }
+	coordinator := fs.String("coordinator", "", "URL of the coordinator, e.g. http://coordinator:8080")
+	token := fs.String("token", os.Getenv("ANYPIPE_TOKEN"), "bearer token of the coordinator")
+	name := fs.String("name", hostname, "name the agent registers with")
+	slots := fs.Int("slots", 1, "jobs run at once")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if len(*coordinator) == 0 || *slots < 1 {
+		fmt.Fprintln(stderr, "-coordinator is required and -slots must be at least 1")
+		usage(stderr)
+		return 2
+	}
+
+	if err := runAgent(remote.NewAgent(*coordinator, *token, *name, *slots)); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
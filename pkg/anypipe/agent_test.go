@@ -0,0 +1,89 @@
+package anypipe
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentCommand(t *testing.T) {
+	t.Setenv("ANYPIPE_TOKEN", "t0k3n")
+
+	type testcase struct {
+		name         string
+		args         []string
+		err          error
+		expectedCode int
+		expected     *remote.Agent
+	}
+
+	testcases := []testcase{
+		{
+			name:         "help",
+			args:         []string{"help"},
+			expectedCode: 0,
+		},
+		{
+			name:         "other command",
+			args:         []string{"serve"},
+			expectedCode: 2,
+		},
+		{
+			name:         "no coordinator",
+			args:         []string{"agent", "-slots", "2"},
+			expectedCode: 2,
+		},
+		{
+			name:         "no slots",
+			args:         []string{"agent", "-coordinator", "http://coordinator:8080", "-slots", "0"},
+			expectedCode: 2,
+		},
+		{
+			name:         "token from the environment",
+			args:         []string{"agent", "-coordinator", "http://coordinator:8080", "-name", "runner-1", "-slots", "4"},
+			expectedCode: 0,
+			expected:     &remote.Agent{URL: "http://coordinator:8080", Token: "t0k3n", Name: "runner-1", Slots: 4},
+		},
+		{
+			name:         "token flag",
+			args:         []string{"agent", "-coordinator", "http://coordinator:8080", "-name", "runner-1", "-token", "other"},
+			expectedCode: 0,
+			expected:     &remote.Agent{URL: "http://coordinator:8080", Token: "other", Name: "runner-1", Slots: 1},
+		},
+		{
+			name:         "agent fails",
+			args:         []string{"agent", "-coordinator", "http://coordinator:8080", "-name", "runner-1"},
+			err:          errors.New("failed to register with coordinator"),
+			expectedCode: 1,
+			expected:     &remote.Agent{URL: "http://coordinator:8080", Token: "t0k3n", Name: "runner-1", Slots: 1},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var agent *remote.Agent
+			runAgent := func(a *remote.Agent) error {
+				agent = a
+				return tc.err
+			}
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			assert.Equal(t, tc.expectedCode, agentCommand(tc.args, stdout, stderr, runAgent), stderr.String())
+			if tc.expected == nil {
+				assert.Nil(t, agent)
+				return
+			}
+
+			assert.Equal(t, tc.expected.URL, agent.URL)
+			assert.Equal(t, tc.expected.Token, agent.Token)
+			assert.Equal(t, tc.expected.Name, agent.Name)
+			assert.Equal(t, tc.expected.Slots, agent.Slots)
+			if tc.err != nil {
+				assert.Contains(t, stderr.String(), tc.err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ApprovalGate is a job that pauses the pipeline until an approver approves or rejects it. a rejection, or
+// no decision within the timeout, fails the gate, so the jobs after it don't run
+type ApprovalGate struct {
+	Name    string
+	Message string
+	// no timeout if 0
+	Timeout  time.Duration
+	Approver approval.Approver
+	// the decision, once made
+	Decision   *approval.Decision
+	Semaphores []string
+	Metrics    []StepMetrics
+	handler    EventHandler
+	// set by builder methods that don't apply to approval gates, returned by Run
+	err error
+}
+
+func NewApprovalGate(name, message string, approver approval.Approver, timeout time.Duration) Job {
+	return &ApprovalGate{
+		Name:     name,
+		Message:  message,
+		Timeout:  timeout,
+		Approver: approver,
+	}
+}
+
+func (g *ApprovalGate) unsupported(method string) Job {
+	g.err = errors.Join(g.err, fmt.Errorf("%s is not supported by approval gate %s", method, g.Name))
+
+	return g
+}
+
+func (g *ApprovalGate) WithStep(stepName string, f StepFunc) Job {
+	return g.unsupported("WithStep")
+}
+
+func (g *ApprovalGate) WithSteps(steps ...Step) Job {
+	return g.unsupported("WithSteps")
+}
+
+func (g *ApprovalGate) WithEnv(key, value string) Job {
+	return g.unsupported("WithEnv")
+}
+
+func (g *ApprovalGate) WithHostEnv(names ...string) Job {
+	return g.unsupported("WithHostEnv")
+}
+
+func (g *ApprovalGate) WithEnvFile(path string) Job {
+	return g.unsupported("WithEnvFile")
+}
+
+func (g *ApprovalGate) WithCachedStep(stepName string, sc StepCache, f StepFunc) Job {
+	return g.unsupported("WithCachedStep")
+}
+
+func (g *ApprovalGate) WithSecretEnv(envKey, variable string) Job {
+	return g.unsupported("WithSecretEnv")
+}
+
+func (g *ApprovalGate) WithSecretFile(name, variable string) Job {
+	return g.unsupported("WithSecretFile")
+}
+
+func (g *ApprovalGate) WithArtifacts(name string, globs ...string) Job {
+	return g.unsupported("WithArtifacts")
+}
+
+func (g *ApprovalGate) WithArtifactInput(name, dst string) Job {
+	return g.unsupported("WithArtifactInput")
+}
+
+func (g *ApprovalGate) WithCache(path, key string, restoreKeys ...string) Job {
+	return g.unsupported("WithCache")
+}
+
+func (g *ApprovalGate) WithResources(cpus float64, memory int64) Job {
+	return g.unsupported("WithResources")
+}
+
+func (g *ApprovalGate) WithWorkspace(ws Workspace) Job {
+	return g.unsupported("WithWorkspace")
+}
+
+func (g *ApprovalGate) WithSetsVariables(names ...string) Job {
+	return g.unsupported("WithSetsVariables")
+}
+
+// gates have a single step, so the failure policy makes no difference
+func (g *ApprovalGate) WithFailurePolicy(policy FailurePolicy) Job {
+	return g
+}
+
+func (g *ApprovalGate) WithCIProvider(p ci.Provider) Job {
+	return g
+}
+
+func (g *ApprovalGate) WithEventHandler(h EventHandler) Job {
+	g.handler = h
+
+	return g
+}
+
+// e.g. so only one deployment waits for approval at a time
+func (g *ApprovalGate) WithSemaphores(names ...string) Job {
+	g.Semaphores = append(g.Semaphores, names...)
+
+	return g
+}
+
+func (g *ApprovalGate) Run(ctx context.Context,
+	log *slog.Logger,
+	du dockerutils.DockerUtils,
+	variables map[string]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, fmt.Sprintf("approval %s", g.Name), attribute.String("job", g.Name))
+	defer func() { endSpan(ctx, span, err) }()
+
+	// only the decision of this run is reported
+	g.Metrics, g.Decision = nil, nil
+	g.emit(Event{Type: EventJobStarted})
+	startTime := time.Now()
+	defer func() {
+		m := StepMetrics{StepName: "approval", Duration: time.Since(startTime), Result: err}
+		if g.Decision != nil {
+			m.Logs = []LogEntry{{Time: g.Decision.Time, Stream: StreamLog, Text: describeDecision(*g.Decision)}}
+		}
+		g.Metrics = append(g.Metrics, m)
+
+		e := Event{Type: EventJobFinished, Result: ResultPass, Duration: time.Since(startTime)}
+		if err != nil {
+			e.Result = ResultFail
+			if errors.Is(err, ErrCancelled) {
+				e.Result = ResultCancelled
+			}
+			e.Error = err.Error()
+		}
+		g.emit(e)
+	}()
+
+	if g.err != nil {
+		return g.err
+	}
+
+	waitCtx := ctx
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeoutCause(ctx, g.Timeout, fmt.Errorf("no decision within %s", g.Timeout))
+		defer cancel()
+	}
+
+	log.Info(fmt.Sprintf("waiting for approval of %s", g.Name))
+	d, err := g.Approver.Await(waitCtx, approval.Request{Job: g.Name, Message: g.Message})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return fmt.Errorf("%w: %s", ErrCancelled, context.Cause(ctx))
+	case err != nil && waitCtx.Err() != nil:
+		return fmt.Errorf("approval timed out: %s", context.Cause(waitCtx))
+	case err != nil:
+		return fmt.Errorf("failed to wait for approval: %w", err)
+	}
+
+	g.Decision = &d
+	log.Info(describeDecision(d))
+	if !d.Approved {
+		return errors.New(describeDecision(d))
+	}
+
+	return nil
+}
+
+func describeDecision(d approval.Decision) string {
+	s := fmt.Sprintf("rejected by %s", d.Approver)
+	if d.Approved {
+		s = fmt.Sprintf("approved by %s", d.Approver)
+	}
+	if len(d.Comment) > 0 {
+		s = fmt.Sprintf("%s: %s", s, d.Comment)
+	}
+
+	return s
+}
+
+func (g *ApprovalGate) emit(e Event) {
+	if g.handler == nil {
+		return
+	}
+
+	e.Job = g.Name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	g.handler(e)
+}
+
+func (g *ApprovalGate) DisplaySummary() {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetTitle(g.Name)
+	t.AppendHeader(table.Row{"Result", "Decision"})
+
+	for _, m := range g.Metrics {
+		decision := ""
+		if m.Result != nil {
+			decision = m.Result.Error()
+		} else if g.Decision != nil {
+			decision = describeDecision(*g.Decision)
+		}
+		t.AppendRow(table.Row{resultOf(m), decision})
+	}
+
+	t.Render()
+}
+
+func (g *ApprovalGate) GetName() string {
+	return g.Name
+}
+
+func (g *ApprovalGate) GetMetrics() []StepMetrics {
+	return g.Metrics
+}
+
+func (g *ApprovalGate) GetArtifacts() []Artifact {
+	return []Artifact{}
+}
+
+func (g *ApprovalGate) GetResources() Resources {
+	return Resources{}
+}
+
+func (g *ApprovalGate) GetSemaphores() []string {
+	return g.Semaphores
+}
+
+func (g *ApprovalGate) GetArtifactInputs() []string {
+	return []string{}
+}
+
+func (g *ApprovalGate) GetArtifactOutputs() []string {
+	return []string{}
+}
+
+func (g *ApprovalGate) GetSetVariables() []string {
+	return []string{}
+}
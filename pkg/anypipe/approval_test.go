@@ -0,0 +1,92 @@
+package anypipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestApprovalGate(t *testing.T) {
+	type testcase struct {
+		name            string
+		approver        approval.ApproverFunc
+		timeout         time.Duration
+		expectedResults []JobResult
+	}
+
+	alice := approval.Decision{Approved: true, Approver: "alice"}
+	bob := approval.Decision{Approver: "bob", Comment: "not on a friday"}
+
+	testcases := []testcase{
+		{
+			name: "continues once approved",
+			approver: func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				return alice, nil
+			},
+			expectedResults: []JobResult{
+				{Job: "approve", Result: ResultPass, Reason: "approved by alice", Approval: &alice},
+				{Job: "deploy", Result: ResultPass},
+			},
+		},
+		{
+			name: "stops once rejected",
+			approver: func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				return bob, nil
+			},
+			expectedResults: []JobResult{
+				{Job: "approve", Result: ResultFail, Reason: "rejected by bob: not on a friday", Approval: &bob},
+				{Job: "deploy", Result: ResultNotRun, Reason: "job approve failed (finish-running)"},
+			},
+		},
+		{
+			name: "stops without a decision in time",
+			approver: func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				<-ctx.Done()
+				return approval.Decision{}, context.Cause(ctx)
+			},
+			timeout: 10 * time.Millisecond,
+			expectedResults: []JobResult{
+				{Job: "approve", Result: ResultFail, Reason: "approval timed out: no decision within 10ms"},
+				{Job: "deploy", Result: ResultNotRun, Reason: "job approve failed (finish-running)"},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+			requests := []approval.Request{}
+			approver := approval.ApproverFunc(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				requests = append(requests, req)
+				return tc.approver(ctx, req)
+			})
+
+			pipeline := testPipeline()
+			pipeline.WithSequentialJobs(
+				NewApprovalGate("approve", "deploy v1.2.3 to production?", approver, tc.timeout),
+				NewJobImpl("deploy", "testimage:latest").WithStep("deploy", passingStep),
+			)
+
+			_ = pipeline.run(du, map[string]interface{}{})
+			assert.Equal(t, tc.expectedResults, pipeline.Results)
+			assert.Equal(t, []approval.Request{{Job: "approve", Message: "deploy v1.2.3 to production?"}}, requests)
+		})
+	}
+}
+
+func TestApprovalGateUnsupported(t *testing.T) {
+	g := NewApprovalGate("approve", "", approval.NewFileApprover(t.TempDir()), 0).WithStep("step", passingStep)
+
+	err := g.Run(context.Background(), testPipeline().log, nil, map[string]interface{}{})
+	assert.ErrorContains(t, err, "WithStep is not supported by approval gate approve")
+}
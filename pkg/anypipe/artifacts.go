@@ -0,0 +1,262 @@
+package anypipe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// Artifact is a set of files collected from a job's container, which later jobs can use as input
+type Artifact struct {
+	Name string
+	// job that produced the artifact
+	Job string
+	// directory on the host the files are stored in
+	Dir   string
+	Files []ArtifactFile
+}
+
+// ArtifactFile is a file of an artifact, Path is relative to the artifact's directory
+type ArtifactFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// returns the total size of the artifact's files
+func (a Artifact) Size() int64 {
+	var size int64
+	for _, f := range a.Files {
+		size += f.Size
+	}
+
+	return size
+}
+
+// artifactStore keeps the artifacts produced during a pipeline run
+type artifactStore struct {
+	mu        sync.Mutex
+	dir       string
+	artifacts map[string]Artifact
+}
+
+func newArtifactStore(dir string) *artifactStore {
+	return &artifactStore{
+		dir:       dir,
+		artifacts: map[string]Artifact{},
+	}
+}
+
+func (s *artifactStore) get(name string) (Artifact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.artifacts[name]
+	return a, ok
+}
+
+func (s *artifactStore) put(a Artifact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.artifacts[a.Name]; ok {
+		return fmt.Errorf("artifact %s was already produced by job %s", a.Name, existing.Job)
+	}
+	s.artifacts[a.Name] = a
+
+	return nil
+}
+
+// container paths matching the globs, relative to the working directory unless the glob is absolute
+func (j *JobImpl) matchArtifactPaths(du dockerutils.DockerUtils, c *dockerutils.Container, globs []string) ([]string, error) {
+	// globs are left unquoted so the shell expands them, unmatched globs are filtered out by the existence check
+	stdout, stderr, ec, err := du.Exec(c, fmt.Sprintf(`for p in %s; do [ -e "$p" ] && echo "$p"; done; true`, strings.Join(globs, " ")))
+	if err != nil {
+		return nil, err
+	}
+	if ec != 0 {
+		return nil, fmt.Errorf("failed to match artifact paths %s: %s", strings.Join(globs, " "), stderr)
+	}
+
+	matches := []string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			matches = append(matches, line)
+		}
+	}
+
+	return matches, nil
+}
+
+// copies the files matching the artifact's globs from the container into the run's artifact store
+func (j *JobImpl) collectArtifact(s *artifactStore, du dockerutils.DockerUtils, c *dockerutils.Container, name string, globs []string) (Artifact, error) {
+	a := Artifact{
+		Name: name,
+		Job:  j.Name,
+		Dir:  filepath.Join(s.dir, safeFileName(name)),
+	}
+
+	matches, err := j.matchArtifactPaths(du, c, globs)
+	if err != nil {
+		return a, err
+	}
+
+	// files of a previous run of the job must not end up in the artifact
+	if err := os.RemoveAll(a.Dir); err != nil {
+		return a, err
+	}
+
+	for _, m := range matches {
+		// keep the path of the match inside the artifact, e.g. dist/app ends up in <artifact dir>/dist/app
+		dst := filepath.Join(a.Dir, filepath.FromSlash(path.Dir(strings.TrimPrefix(path.Clean(m), "/"))))
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return a, err
+		}
+
+		// the globs are expanded in the working directory of Exec, while copies are resolved from /
+		src := m
+		if !path.IsAbs(src) {
+			src = path.Join(dockerutils.WorkingDir, src)
+		}
+		if err := du.CopyFrom(c, src, dst); err != nil {
+			return a, fmt.Errorf("failed to collect %s of artifact %s: %w", m, name, err)
+		}
+	}
+
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return a, err
+	}
+
+	a.Files, err = artifactFiles(a.Dir)
+	if err != nil {
+		return a, err
+	}
+
+	return a, s.put(a)
+}
+
+// lists the files in dir with their size and checksum
+func artifactFiles(dir string) ([]ArtifactFile, error) {
+	files := []ArtifactFile{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		size, err := io.Copy(h, f)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, ArtifactFile{
+			Path:   filepath.ToSlash(rel),
+			Size:   size,
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+
+	sort.Slice(files, func(i, k int) bool { return files[i].Path < files[k].Path })
+	return files, err
+}
+
+// collects the artifacts declared by the job, with references in their globs interpolated
+func (j *JobImpl) collectArtifacts(s *artifactStore, du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	names := []string{}
+	for name := range j.ArtifactPaths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		globs := []string{}
+		for _, glob := range j.ArtifactPaths[name] {
+			// the globs are expanded by the shell, see matchArtifactPaths
+			g, err := InterpolateShell(glob, variables)
+			if err != nil {
+				return err
+			}
+			globs = append(globs, g)
+		}
+
+		a, err := j.collectArtifact(s, du, c, name, globs)
+		if err != nil {
+			return err
+		}
+		j.Artifacts = append(j.Artifacts, a)
+	}
+
+	return nil
+}
+
+// places the artifacts the job takes as input into its container, with references in their destination interpolated
+func (j *JobImpl) placeArtifacts(s *artifactStore, du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	names := []string{}
+	for name := range j.ArtifactInputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a, ok := s.get(name)
+		if !ok {
+			return fmt.Errorf("artifact %s is not available, it must be produced by an earlier job", name)
+		}
+
+		dst, err := Interpolate(j.ArtifactInputs[name], variables)
+		if err != nil {
+			return err
+		}
+		_, stderr, ec, err := du.Exec(c, fmt.Sprintf("mkdir -p %s", ShellQuote(dst)))
+		if err != nil {
+			return err
+		}
+		if ec != 0 {
+			return fmt.Errorf("failed to create %s for artifact %s: %s", dst, name, stderr)
+		}
+
+		if err := du.CopyTo(c, a.Dir, dst); err != nil {
+			return fmt.Errorf("failed to place artifact %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// formats a size in bytes for humans
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,150 @@
+package anypipe
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestArtifactsBetweenJobs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	dir := t.TempDir()
+	producer := &dockerutils.Container{}
+	consumer := &dockerutils.Container{}
+
+	gomock.InOrder(
+		du.EXPECT().CreateContainerWithOptions("builder:latest", dockerutils.ContainerOptions{}).Times(1).Return(producer, nil),
+		du.EXPECT().Exec(producer, `for p in dist/* /tmp/report.xml; do [ -e "$p" ] && echo "$p"; done; true`).Times(1).Return("dist/app\n/tmp/report.xml\n", "", 0, nil),
+		du.EXPECT().CopyFrom(producer, "/home/dist/app", filepath.Join(dir, "build", "dist")).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			return os.WriteFile(filepath.Join(dst, "app"), []byte("binary"), 0755)
+		}),
+		du.EXPECT().CopyFrom(producer, "/tmp/report.xml", filepath.Join(dir, "build", "tmp")).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			return os.WriteFile(filepath.Join(dst, "report.xml"), []byte("<testsuites/>"), 0644)
+		}),
+		du.EXPECT().CreateContainerWithOptions("runner:latest", dockerutils.ContainerOptions{}).Times(1).Return(consumer, nil),
+		du.EXPECT().Exec(consumer, "mkdir -p '/input'").Times(1).Return("", "", 0, nil),
+		du.EXPECT().CopyTo(consumer, filepath.Join(dir, "build"), "/input").Times(1).Return(nil),
+	)
+
+	build := NewJobImpl("build", "builder:latest").
+		WithArtifacts("build", "dist/*", "/tmp/report.xml")
+	deploy := NewJobImpl("deploy", "runner:latest").
+		WithArtifactInput("build", "/input")
+
+	pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithSequentialJobs(build, deploy).
+		WithArtifactDir(dir).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+
+	assert.Equal(t, []Artifact{
+		{
+			Name: "build",
+			Job:  "build",
+			Dir:  filepath.Join(dir, "build"),
+			Files: []ArtifactFile{
+				{Path: "dist/app", Size: 6, SHA256: "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"},
+				{Path: "tmp/report.xml", Size: 13, SHA256: "14971007a6c99471a0dd7d408b2769fa55dfa8534c3d21e761a2d9f6f1ef7467"},
+			},
+		},
+	}, build.GetArtifacts())
+	assert.Equal(t, int64(19), build.GetArtifacts()[0].Size())
+	assert.Empty(t, deploy.GetArtifacts())
+}
+
+func TestArtifactsRerun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	dir := t.TempDir()
+	c := &dockerutils.Container{}
+
+	// a file left over from a previous run must not end up in the artifact
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "build", "home", "dist"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "build", "home", "dist", "stale"), []byte("old"), 0644))
+
+	du.EXPECT().CreateContainerWithOptions("builder:latest", dockerutils.ContainerOptions{}).Times(2).Return(c, nil)
+	du.EXPECT().Exec(c, `for p in /home/dist/*; do [ -e "$p" ] && echo "$p"; done; true`).Times(2).Return("/home/dist/app\n", "", 0, nil)
+	du.EXPECT().CopyFrom(c, "/home/dist/app", filepath.Join(dir, "build", "home", "dist")).Times(2).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+		return os.WriteFile(filepath.Join(dst, "app"), []byte("binary"), 0755)
+	})
+
+	build := NewJobImpl("build", "builder:latest").
+		WithArtifacts("build", "/home/dist/*")
+
+	expected := []Artifact{
+		{
+			Name: "build",
+			Job:  "build",
+			Dir:  filepath.Join(dir, "build"),
+			Files: []ArtifactFile{
+				{Path: "home/dist/app", Size: 6, SHA256: "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"},
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+			WithSequentialJobs(build).
+			WithArtifactDir(dir).
+			WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+		assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+		assert.Equal(t, expected, build.GetArtifacts())
+	}
+}
+
+func TestMissingArtifactInput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+
+	du.EXPECT().CreateContainerWithOptions("runner:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	deploy := NewJobImpl("deploy", "runner:latest").
+		WithArtifactInput("build", "/input").
+		WithStep("never runs", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			t.Fail()
+			return nil
+		})
+
+	pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithSequentialJobs(deploy).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	err := pipeline.(*AnypipeImpl).run(du, map[string]interface{}{})
+	assert.ErrorContains(t, err, "artifact build is not available")
+}
+
+func TestFormatBytes(t *testing.T) {
+	type testcase struct {
+		size     int64
+		expected string
+	}
+
+	testcases := []testcase{
+		{size: 0, expected: "0 B"},
+		{size: 1023, expected: "1023 B"},
+		{size: 1024, expected: "1.0 KiB"},
+		{size: 1536, expected: "1.5 KiB"},
+		{size: 5 * 1024 * 1024, expected: "5.0 MiB"},
+	}
+
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, formatBytes(tc.size))
+	}
+}
@@ -0,0 +1,160 @@
+package anypipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"text/template"
+
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/utils"
+)
+
+// Cache is a directory of the job's container persisted between runs, e.g. the Go module cache
+type Cache struct {
+	Path string
+	// key template, e.g. `go-mod-{{ hashFiles "go.sum" }}`
+	Key string
+	// key prefix templates tried in order when there is no entry for Key, e.g. "go-mod-"
+	RestoreKeys []string
+	// the key Key resolved to, once the job ran
+	ResolvedKey string
+	// the key of the entry that was restored, empty on a cache miss
+	RestoredKey string
+	// whether the directory was saved under ResolvedKey after the job succeeded
+	Saved bool
+}
+
+// data available to key templates
+type cacheKeyData struct {
+	Job   string
+	Image string
+	OS    string
+	Arch  string
+}
+
+// renders a key template. hashFiles hashes files on the host, relative to the working directory
+func (j *JobImpl) renderCacheKey(key string) (string, error) {
+	t, err := template.New("key").Funcs(template.FuncMap{"hashFiles": cache.HashFiles}).Parse(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid cache key %s: %w", key, err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	err = t.Execute(buf, cacheKeyData{Job: j.Name, Image: j.image, OS: runtime.GOOS, Arch: runtime.GOARCH})
+	if err != nil {
+		return "", fmt.Errorf("invalid cache key %s: %w", key, err)
+	}
+
+	return buf.String(), nil
+}
+
+// restores the job's caches into the container. caches only speed jobs up, so failures are logged and ignored
+func (j *JobImpl) restoreCaches(ctx context.Context, log *slog.Logger, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container) {
+	for i := range j.Caches {
+		if err := j.restoreCache(ctx, log, s, du, c, &j.Caches[i]); err != nil {
+			log.Warn(fmt.Sprintf("failed to restore cache %s: %s", j.Caches[i].Path, err.Error()))
+		}
+	}
+}
+
+func (j *JobImpl) restoreCache(ctx context.Context, log *slog.Logger, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container, ch *Cache) error {
+	key, err := j.renderCacheKey(ch.Key)
+	if err != nil {
+		return err
+	}
+	ch.ResolvedKey = key
+
+	restoreKeys := []string{}
+	for _, rk := range ch.RestoreKeys {
+		rendered, err := j.renderCacheKey(rk)
+		if err != nil {
+			return err
+		}
+		restoreKeys = append(restoreKeys, rendered)
+	}
+
+	found, _, err := cache.Lookup(ctx, s, key, restoreKeys...)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		log.Info(fmt.Sprintf("cache miss for %s (key: %s)", ch.Path, key))
+		return nil
+	}
+
+	rc, err := s.Get(ctx, found)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dir, err := os.MkdirTemp("", "anypipe-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := utils.Untar(rc, dir); err != nil {
+		return err
+	}
+
+	_, stderr, ec, err := du.Exec(c, fmt.Sprintf("mkdir -p %s", ShellQuote(ch.Path)))
+	if err != nil {
+		return err
+	}
+	if ec != 0 {
+		return fmt.Errorf("failed to create %s: %s", ch.Path, stderr)
+	}
+
+	if err := du.CopyTo(c, dir, ch.Path); err != nil {
+		return err
+	}
+
+	ch.RestoredKey = found
+	log.Info(fmt.Sprintf("restored cache %s into %s", found, ch.Path))
+	return nil
+}
+
+// saves the job's caches, unless they were restored from an entry with the same key
+func (j *JobImpl) saveCaches(ctx context.Context, log *slog.Logger, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container) {
+	for i := range j.Caches {
+		ch := &j.Caches[i]
+		if len(ch.ResolvedKey) == 0 || ch.RestoredKey == ch.ResolvedKey {
+			continue
+		}
+
+		if err := j.saveCache(ctx, s, du, c, ch); err != nil {
+			log.Warn(fmt.Sprintf("failed to save cache %s: %s", ch.Path, err.Error()))
+			continue
+		}
+		ch.Saved = true
+		log.Info(fmt.Sprintf("saved cache %s from %s", ch.ResolvedKey, ch.Path))
+	}
+}
+
+func (j *JobImpl) saveCache(ctx context.Context, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container, ch *Cache) error {
+	dir, err := os.MkdirTemp("", "anypipe-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := du.CopyFrom(c, ch.Path, dir); err != nil {
+		return err
+	}
+
+	// the directory is copied with its name, only its contents are cached
+	buf, err := utils.Tar(filepath.Join(dir, path.Base(path.Clean(ch.Path))))
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, ch.ResolvedKey, buf)
+}
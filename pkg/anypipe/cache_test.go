@@ -0,0 +1,184 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestJobCaches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	goSum := filepath.Join(t.TempDir(), "go.sum")
+	assert.NoError(t, os.WriteFile(goSum, []byte("v1"), 0644))
+	hash, err := cache.HashFiles(goSum)
+	assert.NoError(t, err)
+
+	store := cache.NewLocalStore(t.TempDir())
+	c := &dockerutils.Container{}
+
+	// runs a job caching /go/pkg/mod, the mock CopyFrom produces a module cache with a single file
+	runJob := func(du *dockerutils.MockDockerUtils, failed bool) *JobImpl {
+		du.EXPECT().CreateContainerWithOptions("golang:1.22", dockerutils.ContainerOptions{}).Times(1).Return(c, nil)
+
+		job := NewJobImpl("build", "golang:1.22").
+			WithCache("/go/pkg/mod", `go-mod-{{ .OS }}-{{ hashFiles "`+goSum+`" }}`, "go-mod-{{ .OS }}-").
+			WithStep("build", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				if failed {
+					return errors.New("build failed")
+				}
+				return nil
+			})
+
+		pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+			WithSequentialJobs(job).
+			WithCacheStore(store).
+			WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+		_ = pipeline.(*AnypipeImpl).run(du, map[string]interface{}{})
+		return job.(*JobImpl)
+	}
+
+	expectSave := func(du *dockerutils.MockDockerUtils, content string) {
+		du.EXPECT().CopyFrom(c, "/go/pkg/mod", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			assert.NoError(t, os.MkdirAll(filepath.Join(dst, "mod"), 0755))
+			return os.WriteFile(filepath.Join(dst, "mod", "module.txt"), []byte(content), 0644)
+		})
+	}
+
+	expectRestore := func(du *dockerutils.MockDockerUtils, content string) {
+		du.EXPECT().Exec(c, "mkdir -p '/go/pkg/mod'").Times(1).Return("", "", 0, nil)
+		du.EXPECT().CopyTo(c, gomock.Any(), "/go/pkg/mod").Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			restored, err := os.ReadFile(filepath.Join(src, "module.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(restored))
+			return nil
+		})
+	}
+
+	key := "go-mod-" + runtime.GOOS + "-" + hash
+
+	t.Run("failed job does not save", func(t *testing.T) {
+		job := runJob(dockerutils.NewMockDockerUtils(ctrl), true)
+		assert.Equal(t, key, job.Caches[0].ResolvedKey)
+		assert.False(t, job.Caches[0].Saved)
+	})
+
+	t.Run("miss saves the cache", func(t *testing.T) {
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		expectSave(du, "first")
+
+		job := runJob(du, false)
+		assert.Equal(t, "", job.Caches[0].RestoredKey)
+		assert.True(t, job.Caches[0].Saved)
+	})
+
+	t.Run("exact hit restores without saving", func(t *testing.T) {
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		expectRestore(du, "first")
+
+		job := runJob(du, false)
+		assert.Equal(t, key, job.Caches[0].RestoredKey)
+		assert.False(t, job.Caches[0].Saved)
+	})
+
+	t.Run("restore key fallback saves under the new key", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(goSum, []byte("v2"), 0644))
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		expectRestore(du, "first")
+		expectSave(du, "second")
+
+		job := runJob(du, false)
+		assert.Equal(t, key, job.Caches[0].RestoredKey)
+		assert.NotEqual(t, key, job.Caches[0].ResolvedKey)
+		assert.True(t, job.Caches[0].Saved)
+
+		entries, err := store.List(context.Background(), "go-mod-")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+
+	t.Run("each run reports its own outcome", func(t *testing.T) {
+		job := NewJobImpl("build", "golang:1.22").
+			WithCache("/root/.cache/it's here", "rerun").
+			WithStep("build", passingStep).(*JobImpl)
+
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		du.EXPECT().CreateContainerWithOptions("golang:1.22", dockerutils.ContainerOptions{}).Times(2).Return(c, nil)
+		du.EXPECT().CopyFrom(c, "/root/.cache/it's here", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			assert.NoError(t, os.MkdirAll(filepath.Join(dst, "it's here"), 0755))
+			return os.WriteFile(filepath.Join(dst, "it's here", "entry"), []byte("cached"), 0644)
+		})
+		du.EXPECT().Exec(c, `mkdir -p '/root/.cache/it'\''s here'`).Times(1).Return("", "", 0, nil)
+		du.EXPECT().CopyTo(c, gomock.Any(), "/root/.cache/it's here").Times(1).Return(nil)
+
+		for _, expected := range []Cache{
+			{Path: "/root/.cache/it's here", Key: "rerun", ResolvedKey: "rerun", Saved: true},
+			{Path: "/root/.cache/it's here", Key: "rerun", ResolvedKey: "rerun", RestoredKey: "rerun"},
+		} {
+			pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+				WithSequentialJobs(job).
+				WithCacheStore(store).
+				WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+			assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+			assert.Equal(t, []Cache{expected}, job.Caches)
+		}
+	})
+}
+
+func TestPipelineEvictsCaches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := cache.NewLocalStore(t.TempDir())
+	for _, key := range []string{"a", "b", "c"} {
+		assert.NoError(t, store.Put(context.Background(), key, io.LimitReader(zeroes{}, 10)))
+	}
+
+	pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithCacheStore(store).
+		WithCacheEviction(cache.Policy{MaxSize: 25}).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(dockerutils.NewMockDockerUtils(ctrl), map[string]interface{}{}))
+
+	entries, err := store.List(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	job := NewJobImpl("build", "golang:1.22").(*JobImpl)
+
+	key, err := job.renderCacheKey("{{ .Job }}-{{ .Image }}")
+	assert.NoError(t, err)
+	assert.Equal(t, "build-golang:1.22", key)
+
+	_, err = job.renderCacheKey("{{ .Missing }}")
+	assert.Error(t, err)
+
+	_, err = job.renderCacheKey("{{ hashFiles")
+	assert.Error(t, err)
+}
+
+type zeroes struct{}
+
+func (zeroes) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
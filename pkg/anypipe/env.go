@@ -0,0 +1,245 @@
+package anypipe
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// Env is the environment declared on a pipeline or job. steps add theirs on top of the job's, which adds
+// its own on top of the pipeline's. within a level, files are overridden by host variables, which are
+// overridden by explicit variables
+type Env struct {
+	// .env files, loaded in order when the pipeline or job runs
+	Files []string
+	// host environment variables passed through, if set
+	Host []string
+	// values may reference variables, see Interpolate
+	Vars map[string]string
+}
+
+func (e *Env) set(key, value string) {
+	if e.Vars == nil {
+		e.Vars = map[string]string{}
+	}
+	e.Vars[key] = value
+}
+
+// returns the environment, with its files loaded and its values interpolated
+func (e Env) resolve(variables map[string]interface{}) (map[string]string, error) {
+	env := map[string]string{}
+	for _, f := range e.Files {
+		loaded, err := LoadEnvFile(f)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(env, loaded)
+	}
+
+	for _, name := range e.Host {
+		if v, ok := os.LookupEnv(name); ok {
+			env[name] = v
+		}
+	}
+
+	for k, v := range e.Vars {
+		expanded, err := Interpolate(v, variables)
+		if err != nil {
+			return nil, fmt.Errorf("env variable %s: %w", k, err)
+		}
+		env[k] = expanded
+	}
+
+	return env, nil
+}
+
+// LoadEnvFile reads KEY=VALUE lines from a .env file. blank lines and lines starting with # are ignored,
+// as is an `export ` prefix. values may be single-quoted (taken literally) or double-quoted (with \n, \t,
+// \" and \\ escapes); unquoted values end at ` #`
+func LoadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || len(key) == 0 || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+
+		value, err = parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		env[key] = value
+	}
+
+	return env, scanner.Err()
+}
+
+func parseEnvValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := closingQuote(v)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated double-quoted value")
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return v[1 : end+1], nil
+	}
+
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+
+	return strings.TrimSpace(v), nil
+}
+
+// index of the quote closing a double-quoted value, -1 if there is none
+func closingQuote(v string) int {
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sets env on the container, returning what it replaced so it can be restored
+func applyEnv(c *dockerutils.Container, env map[string]string) map[string]*string {
+	previous := map[string]*string{}
+	for k, v := range env {
+		if old, ok := c.LookupEnv(k); ok {
+			previous[k] = &old
+		} else {
+			previous[k] = nil
+		}
+		c.AddEnv(k, v)
+	}
+
+	return previous
+}
+
+// undoes applyEnv, except for the variables changed since, e.g. by the step itself
+func restoreEnv(c *dockerutils.Container, env map[string]string, previous map[string]*string) {
+	for k, old := range previous {
+		if current, _ := c.LookupEnv(k); current != env[k] {
+			continue
+		}
+		if old == nil {
+			c.RemoveEnv(k)
+		} else {
+			c.AddEnv(k, *old)
+		}
+	}
+}
+
+// the keys of the env variables, mapped to whether their value is recorded with the steps. the values of
+// those set with WithEnv are, those passed through from the host or loaded from .env files may hold anything
+// and are redacted. files that can't be loaded are left out, they fail the job anyway
+func (e Env) declared() map[string]bool {
+	keys := map[string]bool{}
+	for _, f := range e.Files {
+		loaded, _ := LoadEnvFile(f)
+		for k := range loaded {
+			keys[k] = false
+		}
+	}
+	for _, k := range e.Host {
+		keys[k] = false
+	}
+	for k := range e.Vars {
+		keys[k] = true
+	}
+
+	return keys
+}
+
+// the container's environment with secrets masked, as recorded for each step. the variables of the pipeline,
+// job and step env are recorded, see Env.declared, as are those of WithSecretEnv with their values masked.
+// anything else set in the container is left out
+func (j *JobImpl) effectiveEnv(c *dockerutils.Container, declared map[string]bool) map[string]string {
+	env := map[string]string{}
+	for _, kv := range c.Env() {
+		k, v, _ := strings.Cut(kv, "=")
+		recorded, ok := declared[k]
+		_, secret := j.SecretEnv[k]
+		switch {
+		case secret || (ok && !recorded):
+			v = mask
+		case !ok:
+			continue
+		}
+		env[k] = j.masker.mask(v)
+	}
+
+	return env
+}
+
+// returns the env of the step, if any
+func stepEnvOf(step Step) map[string]string {
+	if s, ok := step.(*StepImpl); ok {
+		return s.Env
+	}
+
+	return nil
+}
+
+// env variables as sorted KEY=VALUE pairs
+func envPairs(env map[string]string) []string {
+	pairs := []string{}
+	for k, v := range env {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+
+	return pairs
+}
+
+// sets an env variable in the container of every job. value may reference variables, see Interpolate
+func (p *AnypipeImpl) WithEnv(key, value string) Anypipe {
+	p.Env.set(key, value)
+
+	return p
+}
+
+// passes the host's env variables through to the container of every job, those that aren't set are left out
+func (p *AnypipeImpl) WithHostEnv(names ...string) Anypipe {
+	p.Env.Host = append(p.Env.Host, names...)
+
+	return p
+}
+
+// sets the env variables of a .env file in the container of every job, see LoadEnvFile. the file is read
+// when the pipeline runs
+func (p *AnypipeImpl) WithEnvFile(path string) Anypipe {
+	p.Env.Files = append(p.Env.Files, path)
+
+	return p
+}
@@ -0,0 +1,91 @@
+package anypipe
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+type EventType string
+
+const (
+	EventPipelineStarted  EventType = "pipeline_started"
+	EventPipelineFinished EventType = "pipeline_finished"
+	EventJobStarted       EventType = "job_started"
+	EventJobFinished      EventType = "job_finished"
+	EventStepStarted      EventType = "step_started"
+	EventStepFinished     EventType = "step_finished"
+	// a step is about to execute a command in its container
+	EventExec EventType = "exec"
+	// a command executed by a step finished, carries its output
+	EventOutput EventType = "output"
+)
+
+// Event describes progress of a running pipeline. Only the fields relevant to the event type are set
+type Event struct {
+	Type     EventType     `json:"type"`
+	Time     time.Time     `json:"time"`
+	Pipeline string        `json:"pipeline,omitempty"`
+	Job      string        `json:"job,omitempty"`
+	Step     string        `json:"step,omitempty"`
+	Command  string        `json:"command,omitempty"`
+	Stdout   string        `json:"stdout,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	ExitCode int           `json:"exitCode,omitempty"`
+	Result   string        `json:"result,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// EventHandler is called synchronously for every event, it should return quickly
+type EventHandler func(e Event)
+
+// combines several handlers into one
+func multiHandler(handlers []EventHandler) EventHandler {
+	return func(e Event) {
+		for _, h := range handlers {
+			h(e)
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// observedDockerUtils reports the commands a step executes, and their output, as events.
+// once ctx is done, commands are no longer executed
+type observedDockerUtils struct {
+	dockerutils.DockerUtils
+	emit EventHandler
+	ctx  context.Context
+	// logger of the step, see StepLogger
+	log *slog.Logger
+}
+
+func (o *observedDockerUtils) Exec(c *dockerutils.Container, cmd string) (stdout, stderr string, exitcode int, err error) {
+	if o.ctx != nil && o.ctx.Err() != nil {
+		return "", "", -1, context.Cause(o.ctx)
+	}
+
+	o.emit(Event{Type: EventExec, Command: cmd})
+
+	stdout, stderr, exitcode, err = o.DockerUtils.Exec(c, cmd)
+
+	o.emit(Event{
+		Type:     EventOutput,
+		Command:  cmd,
+		Stdout:   stdout,
+		Stderr:   stderr,
+		ExitCode: exitcode,
+		Error:    errorString(err),
+	})
+
+	return
+}
@@ -0,0 +1,69 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestJobEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	f1 := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		_, _, _, err := du.Exec(c, "echo hello")
+		return err
+	}
+	f2 := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return errors.New("some error")
+	}
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().Exec(gomock.Any(), "echo hello").Times(1).Return("hello\n", "", 0, nil)
+
+	events := []Event{}
+	job := NewJobImpl("job", "testimage:latest").
+		WithStep("step1", f1).
+		WithStep("step2", f2).
+		WithStep("step3", f1).
+		WithEventHandler(func(e Event) { events = append(events, e) })
+
+	assert.Error(t, job.Run(context.Background(), testLogger, du, map[string]interface{}{}))
+
+	type summary struct {
+		Type   EventType
+		Step   string
+		Result string
+	}
+	got := []summary{}
+	for _, e := range events {
+		assert.Equal(t, "job", e.Job)
+		assert.False(t, e.Time.IsZero())
+		got = append(got, summary{e.Type, e.Step, e.Result})
+	}
+
+	assert.Equal(t, []summary{
+		{EventJobStarted, "", ""},
+		{EventStepStarted, "step1", ""},
+		{EventExec, "step1", ""},
+		{EventOutput, "step1", ""},
+		{EventStepFinished, "step1", "PASS"},
+		{EventStepStarted, "step2", ""},
+		{EventStepFinished, "step2", "FAIL"},
+		{EventStepFinished, "step3", "SKIP"},
+		{EventJobFinished, "", "FAIL"},
+	}, got)
+
+	assert.Equal(t, "echo hello", events[3].Command)
+	assert.Equal(t, "hello\n", events[3].Stdout)
+	assert.Equal(t, "some error", events[6].Error)
+}
@@ -0,0 +1,81 @@
+package anypipe
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/history"
+)
+
+// records every run of the pipeline in s, so `anypipe history` can tell how runs went over time
+func (p *AnypipeImpl) WithHistory(s *history.Store) Anypipe {
+	p.history = s
+	p.handlers = append(p.handlers, p.jobDurations)
+
+	return p
+}
+
+// keeps how long each job of the current run took
+func (p *AnypipeImpl) jobDurations(e Event) {
+	switch e.Type {
+	case EventPipelineStarted:
+		p.durations = map[string]time.Duration{}
+	case EventJobFinished:
+		if p.durations != nil {
+			p.durations[e.Job] = e.Duration
+		}
+	}
+}
+
+// the record of the run, with secrets masked
+func (p *AnypipeImpl) historyRun(start time.Time, duration time.Duration, err error) history.Run {
+	r := p.notifyResult(duration, err)
+	run := history.Run{
+		ID:        p.RunID,
+		Pipeline:  r.Pipeline,
+		Result:    r.Result,
+		Start:     start,
+		Duration:  r.Duration,
+		Error:     r.Error,
+		CommitSHA: r.CommitSHA,
+		Branch:    r.Branch,
+		Jobs:      []history.Job{},
+	}
+
+	steps := map[string][]history.Step{}
+	for _, job := range p.Jobs {
+		for _, m := range job.GetMetrics() {
+			s := history.Step{Name: m.StepName, Result: resultOf(m), Duration: m.Duration}
+			if m.Result != nil {
+				s.Error = p.masker.mask(m.Result.Error())
+			}
+			steps[job.GetName()] = append(steps[job.GetName()], s)
+		}
+	}
+
+	for _, jr := range r.Jobs {
+		run.Jobs = append(run.Jobs, history.Job{
+			Name:     jr.Job,
+			Result:   jr.Result,
+			Reason:   jr.Reason,
+			Duration: p.durations[jr.Job],
+			Steps:    steps[jr.Job],
+		})
+	}
+
+	return run
+}
+
+// records the run in the history, if the pipeline keeps one. failing to do so doesn't fail the pipeline
+func (p *AnypipeImpl) recordHistory(start time.Time, duration time.Duration, err error) {
+	if p.history == nil {
+		return
+	}
+
+	r, herr := p.history.Record(p.historyRun(start, duration, err))
+	if herr != nil {
+		p.log.Error(fmt.Sprintf("failed to record run in history %s: %s", p.history.Dir, herr.Error()))
+		return
+	}
+	p.log.Debug(fmt.Sprintf("recorded run %s in history %s", r.ID, p.history.Dir))
+}
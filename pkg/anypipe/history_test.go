@@ -0,0 +1,113 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/history"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	leaking := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return errors.New("login with hunter2 failed")
+	}
+
+	store := history.NewStore(t.TempDir())
+	for i := 0; i < 2; i++ {
+		pipeline := testPipeline()
+		pipeline.WithMaskedValues("hunter2").
+			WithSequentialJobs(NewJobImpl("build", "testimage:latest").WithStep("compile", slowStep)).
+			WithSequentialJobs(NewJobImpl("deploy", "testimage:latest").WithStep("login", leaking).WithStep("push", passingStep)).
+			WithSequentialJobs(NewJobImpl("notify", "testimage:latest").WithStep("send", passingStep)).
+			WithHistory(store)
+
+		assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	}
+
+	runs, err := store.Runs(history.Filter{Pipeline: "test pipeline"})
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+
+	r := runs[0]
+	assert.NotEmpty(t, r.ID)
+	assert.Equal(t, "FAIL", r.Result)
+	assert.False(t, r.Start.IsZero())
+	assert.NotZero(t, r.Duration)
+	assert.Len(t, r.Jobs, 3)
+
+	assert.Equal(t, "build", r.Jobs[0].Name)
+	assert.Equal(t, ResultPass, r.Jobs[0].Result)
+	assert.GreaterOrEqual(t, r.Jobs[0].Duration, r.Jobs[0].Steps[0].Duration)
+	assert.Equal(t, []history.Step{{Name: "compile", Result: "PASS", Duration: r.Jobs[0].Steps[0].Duration}}, r.Jobs[0].Steps)
+
+	assert.Equal(t, ResultFail, r.Jobs[1].Result)
+	assert.Equal(t, "login with *** failed", r.Jobs[1].Steps[0].Error)
+	assert.Equal(t, "SKIP", r.Jobs[1].Steps[1].Result)
+
+	assert.Equal(t, history.Job{Name: "notify", Result: ResultNotRun, Reason: "job deploy failed (finish-running)"}, r.Jobs[2])
+
+	stats, err := store.StepStats(history.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "login", stats[1].Step)
+	assert.Equal(t, 2, stats[1].Failed)
+}
+
+func TestHistoryRerun(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	// approves the first run, fails to reach a decision on the second
+	decisions := 0
+	approver := approval.ApproverFunc(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+		decisions++
+		if decisions > 1 {
+			return approval.Decision{}, errors.New("approver is unreachable")
+		}
+		return approval.Decision{Approved: true, Approver: "alice"}, nil
+	})
+
+	store := history.NewStore(t.TempDir())
+	pipeline := testPipeline()
+	pipeline.WithSequentialJobs(NewJobImpl("build", "testimage:latest").WithStep("compile", passingStep).WithStep("test", passingStep)).
+		WithSequentialJobs(NewApprovalGate("approve", "deploy?", approver, 0)).
+		WithHistory(store)
+
+	assert.NoError(t, pipeline.run(du, map[string]interface{}{}))
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+
+	gate := pipeline.Jobs[1].(*ApprovalGate)
+	assert.Nil(t, gate.Decision)
+	assert.Len(t, gate.Metrics, 1)
+	assert.Len(t, pipeline.Jobs[0].GetMetrics(), 2)
+
+	runs, err := store.Runs(history.Filter{Pipeline: "test pipeline"})
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	for _, r := range runs {
+		assert.Len(t, r.Jobs, 2)
+		assert.Len(t, r.Jobs[0].Steps, 2, r.ID)
+		assert.Len(t, r.Jobs[1].Steps, 1, r.ID)
+	}
+
+	latest := runs[0]
+	if runs[1].Start.After(latest.Start) {
+		latest = runs[1]
+	}
+	assert.Equal(t, "FAIL", latest.Result)
+	assert.Equal(t, "failed to wait for approval: approver is unreachable", latest.Jobs[1].Steps[0].Error)
+}
@@ -0,0 +1,166 @@
+package anypipe
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// JobOutputsVariable holds the outputs of the jobs that finished in earlier stages, keyed by job and variable,
+// as referenced by ${{ jobs.<job>.outputs.<name> }}. it is updated by the pipeline between stages
+const JobOutputsVariable = "anypipe.jobs"
+
+var referenceRegex = regexp.MustCompile(`\$\{\{(.*?)\}\}`)
+
+// Interpolate expands the references in s:
+//
+//	${{ vars.name }}                  the variable name, which must not be a Secret
+//	${{ secrets.name }}               the value of the secret variable name
+//	${{ env.NAME }}                   the host's environment variable NAME
+//	${{ jobs.build.outputs.version }} the variable version, as set by the job build
+//
+// referencing anything that isn't set is an error
+func Interpolate(s string, variables map[string]interface{}) (string, error) {
+	return interpolate(s, variables, func(v string) string { return v })
+}
+
+// InterpolateShell expands the references in a command run with `sh -c`, quoting each value so it is
+// passed as a single word and can't break out of the command. references must not be quoted themselves
+func InterpolateShell(cmd string, variables map[string]interface{}) (string, error) {
+	return interpolate(cmd, variables, ShellQuote)
+}
+
+// ShellQuote quotes s for sh, so it is passed as a single word. e.g. it's becomes
+//
+//	'it'\''s'
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func interpolate(s string, variables map[string]interface{}, escape func(string) string) (string, error) {
+	errs := []string{}
+	expanded := referenceRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		expr := strings.TrimSpace(referenceRegex.FindStringSubmatch(ref)[1])
+		v, err := resolveReference(expr, variables)
+		if err != nil {
+			errs = append(errs, err.Error())
+			return ref
+		}
+
+		return escape(v)
+	})
+
+	if len(errs) > 0 {
+		return "", fmt.Errorf("failed to interpolate %q: %s", s, strings.Join(errs, ", "))
+	}
+	// checked on the original string, a value may contain ${{ on its own
+	if strings.Contains(referenceRegex.ReplaceAllString(s, ""), "${{") {
+		return "", fmt.Errorf("failed to interpolate %q: unterminated reference", s)
+	}
+
+	return expanded, nil
+}
+
+func resolveReference(expr string, variables map[string]interface{}) (string, error) {
+	namespace, name, _ := strings.Cut(expr, ".")
+	if len(name) == 0 {
+		return "", fmt.Errorf("invalid reference %s", expr)
+	}
+
+	switch namespace {
+	case "vars":
+		v, ok := variables[name]
+		if !ok || v == nil {
+			return "", fmt.Errorf("variable %s is not set", name)
+		}
+		switch v.(type) {
+		case Secret, *Secret:
+			return "", fmt.Errorf("variable %s is a secret, reference it as secrets.%s", name, name)
+		}
+		return fmt.Sprint(v), nil
+	case "secrets":
+		switch v := variables[name].(type) {
+		case Secret:
+			return v.Value(), nil
+		case *Secret:
+			return v.Value(), nil
+		case nil:
+			return "", fmt.Errorf("secret %s is not set", name)
+		default:
+			return "", fmt.Errorf("variable %s is not a secret, reference it as vars.%s", name, name)
+		}
+	case "env":
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case "jobs":
+		job, output, ok := strings.Cut(name, ".outputs.")
+		if !ok || len(output) == 0 {
+			return "", fmt.Errorf("invalid reference %s, expected jobs.<job>.outputs.<name>", expr)
+		}
+		outputs, _ := variables[JobOutputsVariable].(map[string]map[string]interface{})
+		jobOutputs, ok := outputs[job]
+		if !ok {
+			return "", fmt.Errorf("job %s has no outputs, it did not run in an earlier stage", job)
+		}
+		v, ok := jobOutputs[output]
+		if !ok {
+			return "", fmt.Errorf("job %s has no output %s", job, output)
+		}
+		switch s := v.(type) {
+		case Secret:
+			return s.Value(), nil
+		case *Secret:
+			return s.Value(), nil
+		}
+		return fmt.Sprint(v), nil
+	}
+
+	return "", fmt.Errorf("unknown reference %s, expected vars, secrets, env or jobs", expr)
+}
+
+// the variables the job set or changed, compared to those it started with
+func jobOutputs(before, after map[string]interface{}) map[string]interface{} {
+	outputs := map[string]interface{}{}
+	for k, v := range after {
+		if k == JobOutputsVariable {
+			continue
+		}
+		if old, ok := before[k]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		outputs[k] = v
+	}
+
+	return outputs
+}
+
+// records the outputs of a finished job, published to the variables once its stage finished
+func (p *AnypipeImpl) recordOutputs(job string, outputs map[string]interface{}) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	if p.JobOutputs == nil {
+		p.JobOutputs = map[string]map[string]interface{}{}
+	}
+	p.JobOutputs[job] = outputs
+}
+
+// makes the outputs of the jobs that finished so far available to the next stages. a new map is set,
+// so jobs still holding the previous one never see it change
+func (p *AnypipeImpl) publishOutputs(variables map[string]interface{}) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	outputs := map[string]map[string]interface{}{}
+	if previous, ok := variables[JobOutputsVariable].(map[string]map[string]interface{}); ok {
+		maps.Copy(outputs, previous)
+	}
+	maps.Copy(outputs, p.JobOutputs)
+	variables[JobOutputsVariable] = outputs
+}
@@ -0,0 +1,131 @@
+package anypipe
+
+import (
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestInterpolate(t *testing.T) {
+	type testcase struct {
+		name        string
+		input       string
+		expected    string
+		expectedErr string
+	}
+
+	t.Setenv("ANYPIPE_TEST_REGION", "eu-west-1")
+	variables := map[string]interface{}{
+		"out_file":     "report.txt",
+		"count":        3,
+		"inner.target": "linux",
+		"token":        NewSecret("hunter2"),
+		JobOutputsVariable: map[string]map[string]interface{}{
+			"build":      {"version": "1.2.3", "signing_key": NewSecret("k3y")},
+			"build arm":  {"version": "1.2.4"},
+			"no outputs": {},
+		},
+	}
+
+	testcases := []testcase{
+		{name: "without references", input: "cat file", expected: "cat file"},
+		{name: "variable", input: "cat ${{ vars.out_file }}", expected: "cat report.txt"},
+		{name: "without spaces", input: "${{vars.out_file}}", expected: "report.txt"},
+		{name: "non-string variable", input: "seq ${{ vars.count }}", expected: "seq 3"},
+		{name: "variable with a dot", input: "${{ vars.inner.target }}", expected: "linux"},
+		{name: "secret", input: "${{ secrets.token }}", expected: "hunter2"},
+		{name: "env", input: "${{ env.ANYPIPE_TEST_REGION }}", expected: "eu-west-1"},
+		{name: "job output", input: "app:${{ jobs.build.outputs.version }}", expected: "app:1.2.3"},
+		{name: "secret job output", input: "${{ jobs.build.outputs.signing_key }}", expected: "k3y"},
+		{name: "job name with a space", input: "${{ jobs.build arm.outputs.version }}", expected: "1.2.4"},
+		{name: "several references", input: "${{ vars.out_file }}-${{ vars.count }}", expected: "report.txt-3"},
+		{name: "unknown variable", input: "cat ${{ vars.missing }}", expectedErr: `failed to interpolate "cat ${{ vars.missing }}": variable missing is not set`},
+		{name: "secret as a variable", input: "${{ vars.token }}", expectedErr: "variable token is a secret, reference it as secrets.token"},
+		{name: "variable as a secret", input: "${{ secrets.out_file }}", expectedErr: "variable out_file is not a secret, reference it as vars.out_file"},
+		{name: "unknown secret", input: "${{ secrets.missing }}", expectedErr: "secret missing is not set"},
+		{name: "unknown env", input: "${{ env.ANYPIPE_TEST_MISSING }}", expectedErr: "environment variable ANYPIPE_TEST_MISSING is not set"},
+		{name: "unknown job", input: "${{ jobs.deploy.outputs.url }}", expectedErr: "job deploy has no outputs, it did not run in an earlier stage"},
+		{name: "unknown job output", input: "${{ jobs.no outputs.outputs.url }}", expectedErr: "job no outputs has no output url"},
+		{name: "invalid job reference", input: "${{ jobs.build.version }}", expectedErr: "invalid reference jobs.build.version, expected jobs.<job>.outputs.<name>"},
+		{name: "unknown namespace", input: "${{ steps.x }}", expectedErr: "unknown reference steps.x, expected vars, secrets, env or jobs"},
+		{name: "invalid reference", input: "${{ vars }}", expectedErr: "invalid reference vars"},
+		{name: "all errors", input: "${{ vars.a }} ${{ vars.b }}", expectedErr: "variable a is not set, variable b is not set"},
+		{name: "unterminated reference", input: "cat ${{ vars.out_file", expectedErr: "unterminated reference"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := Interpolate(tc.input, variables)
+			if len(tc.expectedErr) > 0 {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestInterpolateShell(t *testing.T) {
+	variables := map[string]interface{}{
+		"file":  "my report.txt",
+		"evil":  "x'; rm -rf / #",
+		"subst": "$(whoami) `id` ${{ vars.file }}",
+	}
+
+	actual, err := InterpolateShell("cat ${{ vars.file }} ${{ vars.evil }} ${{ vars.subst }}", variables)
+	assert.NoError(t, err)
+	assert.Equal(t, `cat 'my report.txt' 'x'\''; rm -rf / #' '$(whoami) `+"`id`"+` ${{ vars.file }}'`, actual)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `''`, ShellQuote(""))
+	assert.Equal(t, `'plain'`, ShellQuote("plain"))
+	assert.Equal(t, `'it'\''s'`, ShellQuote("it's"))
+	assert.Equal(t, `'$HOME "x"'`, ShellQuote(`$HOME "x"`))
+}
+
+func TestJobOutputs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions("testimage:1.2.3", gomock.Any()).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	seen := ""
+	pipeline := testPipeline()
+	pipeline.WithSequentialJobs(
+		NewJobImpl("build", "testimage:latest").WithStep("version", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			variables["version"] = "1.2.3"
+			return nil
+		}),
+	).WithParallelJobs(
+		NewJobImpl("deploy", "testimage:${{ jobs.build.outputs.version }}").WithStep("deploy", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			var err error
+			seen, err = Interpolate("${{ jobs.build.outputs.version }}", variables)
+			return err
+		}),
+		NewJobImpl("other", "testimage:latest").WithStep("step", passingStep),
+	)
+
+	variables := map[string]interface{}{"initial": "value"}
+	assert.NoError(t, pipeline.run(du, variables))
+	assert.Equal(t, "1.2.3", seen)
+	assert.Equal(t, map[string]map[string]interface{}{"build": {"version": "1.2.3"}, "deploy": {}, "other": {}}, pipeline.JobOutputs)
+	assert.Equal(t, map[string]interface{}{"initial": "value", "version": "1.2.3"}, variables)
+}
+
+func TestUnknownReferenceFailsJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+
+	pipeline := testPipeline()
+	pipeline.WithSequentialJobs(NewJobImpl("deploy", "testimage:${{ vars.missing }}").WithStep("step", passingStep))
+
+	assert.ErrorContains(t, pipeline.run(du, map[string]interface{}{}), "variable missing is not set")
+}
@@ -1,27 +1,68 @@
 package anypipe
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
 	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Job interface {
 	WithStep(stepName string, f StepFunc) Job
-	Run(log *slog.Logger, du dockerutils.DockerUtils, variables map[string]interface{}) error
+	WithSteps(steps ...Step) Job
+	WithCachedStep(stepName string, sc StepCache, f StepFunc) Job
+	WithCIProvider(p ci.Provider) Job
+	WithEventHandler(h EventHandler) Job
+	WithSecretEnv(envKey, variable string) Job
+	WithSecretFile(name, variable string) Job
+	WithArtifacts(name string, globs ...string) Job
+	WithArtifactInput(name, dst string) Job
+	WithCache(path, key string, restoreKeys ...string) Job
+	WithResources(cpus float64, memory int64) Job
+	WithSemaphores(names ...string) Job
+	WithFailurePolicy(policy FailurePolicy) Job
+	WithWorkspace(ws Workspace) Job
+	WithEnv(key, value string) Job
+	WithHostEnv(names ...string) Job
+	WithEnvFile(path string) Job
+	WithSetsVariables(names ...string) Job
+	Run(ctx context.Context, log *slog.Logger, du dockerutils.DockerUtils, variables map[string]interface{}) error
 	DisplaySummary()
+	GetName() string
+	GetMetrics() []StepMetrics
+	GetArtifacts() []Artifact
+	GetResources() Resources
+	GetSemaphores() []string
+	GetArtifactInputs() []string
+	GetArtifactOutputs() []string
+	GetSetVariables() []string
 }
 
 type StepMetrics struct {
 	StepName string
 	Duration time.Duration
 	Result   error
+	// the step did not run, its outputs were restored from a previous run with the same inputs
+	Cached bool
+	// env variables of the step, as set in the container while it ran. secrets are masked, and the values of
+	// those passed through from the host or loaded from .env files are redacted
+	Env map[string]string
+	// commands, output and log records captured while the step ran
+	Logs []LogEntry
+	// file the logs were written to, if the pipeline has a log directory
+	LogFile string
 }
 
 type JobImpl struct {
@@ -29,13 +70,50 @@ type JobImpl struct {
 	ImageRef string
 	Steps    []Step
 	Metrics  []StepMetrics
+	// env variables of the container set from secret variables, keyed by env variable
+	SecretEnv map[string]string
+	// files under SecretsDir in the container set from secret variables, keyed by file name
+	SecretFiles map[string]string
+	// container paths (globs) collected into artifacts after the job, keyed by artifact
+	ArtifactPaths map[string][]string
+	// container directories artifacts of earlier jobs are placed into before the first step, keyed by artifact
+	ArtifactInputs map[string]string
+	// artifacts collected after the job ran
+	Artifacts []Artifact
+	// container directories restored before the steps and saved after success
+	Caches []Cache
+	// resources requested from the host, the container is limited to them
+	Resources Resources
+	// named semaphores held while the job runs
+	Semaphores []string
+	// what still runs once a step failed
+	FailurePolicy FailurePolicy
+	// host directory shared with the container
+	Workspace *Workspace
+	// env variables of the container, over those of the pipeline
+	Env Env
+	// variables the steps set, which its later steps and the jobs of later stages may reference
+	SetsVariables []string
+	// the image ref with its references interpolated, once the job runs
+	image    string
+	provider ci.Provider
+	handler  EventHandler
+	masker   *masker
 }
 
 func NewJobImpl(name, imageRef string) Job {
 	return &JobImpl{
-		Name:     name,
-		ImageRef: imageRef,
-		Steps:    []Step{},
+		Name:           name,
+		ImageRef:       imageRef,
+		image:          imageRef,
+		Steps:          []Step{},
+		SecretEnv:      map[string]string{},
+		SecretFiles:    map[string]string{},
+		ArtifactPaths:  map[string][]string{},
+		ArtifactInputs: map[string]string{},
+		FailurePolicy:  FinishRunning,
+		provider:       ci.Current(),
+		masker:         newMasker(),
 	}
 }
 
@@ -46,50 +124,452 @@ func (j *JobImpl) WithStep(stepName string, f StepFunc) Job {
 	return j
 }
 
-func (j *JobImpl) Run(log *slog.Logger,
+// adds steps built with NewStepImpl, e.g. to set their env with Step.WithEnv
+func (j *JobImpl) WithSteps(steps ...Step) Job {
+	j.Steps = append(j.Steps, steps...)
+
+	return j
+}
+
+// adds a step that is skipped, and its outputs restored, when its inputs are unchanged since a previous
+// successful run. the outputs are kept in the pipeline's cache store, steps always run without one
+func (j *JobImpl) WithCachedStep(stepName string, sc StepCache, f StepFunc) Job {
+	j.Steps = append(j.Steps, &StepImpl{
+		Name:  stepName,
+		Impl:  f,
+		Cache: &sc,
+	})
+
+	return j
+}
+
+// overrides the detected CI provider used to group logs and annotate failures
+func (j *JobImpl) WithCIProvider(p ci.Provider) Job {
+	j.provider = p
+
+	return j
+}
+
+// sets the handler receiving progress events for the job's steps
+func (j *JobImpl) WithEventHandler(h EventHandler) Job {
+	j.handler = h
+
+	return j
+}
+
+// exposes the secret variable as an env variable in the job's container
+func (j *JobImpl) WithSecretEnv(envKey, variable string) Job {
+	j.SecretEnv[envKey] = variable
+
+	return j
+}
+
+// writes the secret variable to SecretsDir/<name> in the job's container. SecretsDir is a tmpfs mount,
+// so the secret is never written to the container's filesystem
+func (j *JobImpl) WithSecretFile(name, variable string) Job {
+	j.SecretFiles[name] = variable
+
+	return j
+}
+
+// collects the container paths matching globs into the artifact name once the job's steps ran.
+// relative globs are matched in the working directory of the steps
+func (j *JobImpl) WithArtifacts(name string, globs ...string) Job {
+	j.ArtifactPaths[name] = append(j.ArtifactPaths[name], globs...)
+
+	return j
+}
+
+// places the artifact name, produced by an earlier job, into dst in the container before the first step
+func (j *JobImpl) WithArtifactInput(name, dst string) Job {
+	j.ArtifactInputs[name] = dst
+
+	return j
+}
+
+// persists the container directory path between runs in the pipeline's cache store. key is a template,
+// e.g. `go-mod-{{ hashFiles "go.sum" }}`. when no entry is stored under key, the most recent entry starting
+// with one of the restore keys is restored instead. the directory is only saved if the job succeeds
+func (j *JobImpl) WithCache(path, key string, restoreKeys ...string) Job {
+	j.Caches = append(j.Caches, Cache{Path: path, Key: key, RestoreKeys: restoreKeys})
+
+	return j
+}
+
+// requests cpus and memory (in bytes) from the pipeline's resource budget, and limits the container to them
+func (j *JobImpl) WithResources(cpus float64, memory int64) Job {
+	j.Resources = Resources{CPUs: cpus, Memory: memory}
+
+	return j
+}
+
+// the job only starts once it can hold the named semaphores, e.g. for jobs sharing a single test database
+func (j *JobImpl) WithSemaphores(names ...string) Job {
+	j.Semaphores = append(j.Semaphores, names...)
+
+	return j
+}
+
+// decides what still runs once a step failed, defaults to FinishRunning. steps run one at a time,
+// so FailFast and FinishRunning both skip the remaining steps, while RunAll runs them anyway
+func (j *JobImpl) WithFailurePolicy(policy FailurePolicy) Job {
+	j.FailurePolicy = policy
+
+	return j
+}
+
+// shares a host directory with the container, at ws.Path, e.g. so steps work on the repository without
+// copying it in and their outputs back
+func (j *JobImpl) WithWorkspace(ws Workspace) Job {
+	j.Workspace = &ws
+
+	return j
+}
+
+// sets an env variable in the container. value may reference variables, see Interpolate
+func (j *JobImpl) WithEnv(key, value string) Job {
+	j.Env.set(key, value)
+
+	return j
+}
+
+// passes the host's env variables through to the container, those that aren't set are left out
+func (j *JobImpl) WithHostEnv(names ...string) Job {
+	j.Env.Host = append(j.Env.Host, names...)
+
+	return j
+}
+
+// sets the env variables of a .env file in the container, see LoadEnvFile. the file is read when the job runs
+func (j *JobImpl) WithEnvFile(path string) Job {
+	j.Env.Files = append(j.Env.Files, path)
+
+	return j
+}
+
+// declares variables the steps set, so Validate accepts references to them in later steps and later stages
+// although they aren't passed to Run
+func (j *JobImpl) WithSetsVariables(names ...string) Job {
+	j.SetsVariables = append(j.SetsVariables, names...)
+
+	return j
+}
+
+func (j *JobImpl) GetName() string {
+	return j.Name
+}
+
+func (j *JobImpl) GetMetrics() []StepMetrics {
+	return j.Metrics
+}
+
+func (j *JobImpl) GetArtifacts() []Artifact {
+	return j.Artifacts
+}
+
+func (j *JobImpl) GetResources() Resources {
+	return j.Resources
+}
+
+func (j *JobImpl) GetSemaphores() []string {
+	return j.Semaphores
+}
+
+// names of the artifacts the job takes as input
+func (j *JobImpl) GetArtifactInputs() []string {
+	names := []string{}
+	for name := range j.ArtifactInputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// names of the artifacts the job produces
+func (j *JobImpl) GetArtifactOutputs() []string {
+	names := []string{}
+	for name := range j.ArtifactPaths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (j *JobImpl) GetSetVariables() []string {
+	return j.SetsVariables
+}
+
+func (j *JobImpl) Run(ctx context.Context,
+	log *slog.Logger,
 	du dockerutils.DockerUtils,
-	variables map[string]interface{}) error {
+	variables map[string]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, fmt.Sprintf("job %s", j.Name), attribute.String("job", j.Name), attribute.String("image", j.ImageRef))
+	defer func() { endSpan(ctx, span, err) }()
+
+	r := runFrom(ctx)
+	j.masker = r.masker
+	j.collectSecrets(variables)
+	log = maskedLogger(log, j.masker)
+	// only the metrics, artifacts and caches of this run are reported
+	j.Metrics, j.Artifacts = nil, nil
+	for i := range j.Caches {
+		j.Caches[i].ResolvedKey, j.Caches[i].RestoredKey, j.Caches[i].Saved = "", "", false
+	}
 
 	log.Info(fmt.Sprintf("starting job %s", j.Name))
+	j.emit(Event{Type: EventJobStarted})
+	jobStart := time.Now()
 
-	c, err := du.CreateContainer(j.ImageRef)
+	var c *dockerutils.Container
+	var env map[string]string
+	var existing map[string]bool
+	copied := false
+	j.image, err = Interpolate(j.ImageRef, variables)
+	if err == nil {
+		env, err = j.Env.resolve(variables)
+	}
+	if err == nil {
+		existing, err = j.workspacePaths()
+	}
+	if err == nil {
+		c, copied, err = j.createContainer(log, traced(ctx, du))
+	}
+	if err == nil {
+		// the pipeline's env, with the job's on top
+		for k, v := range r.env {
+			c.AddEnv(k, v)
+		}
+		for k, v := range env {
+			c.AddEnv(k, v)
+		}
+	}
+	if err == nil {
+		err = j.injectSecrets(du, c, variables)
+	}
+	if err == nil {
+		err = j.placeArtifacts(r.artifacts, traced(ctx, du), c, variables)
+	}
 	if err != nil {
+		err = j.masker.maskError(err)
+		j.emit(Event{Type: EventJobFinished, Result: "FAIL", Duration: time.Since(jobStart), Error: err.Error()})
 		return err
 	}
 
+	if r.caches != nil {
+		j.restoreCaches(ctx, log, r.caches, traced(ctx, du), c)
+	}
+
 	gotError := false
+	cancelled := false
+	// why the remaining steps are skipped
+	skipReason := ""
 	for _, step := range j.Steps {
-		if gotError {
+		if len(skipReason) == 0 && ctx.Err() != nil {
+			cancelled = true
+			skipReason = fmt.Sprintf("cancelled: %s", context.Cause(ctx))
+		}
+
+		if len(skipReason) > 0 {
 			// mark step as skipped
-			j.Metrics = append(j.Metrics, StepMetrics{
+			j.record(StepMetrics{
 				StepName: step.GetName(),
 				Duration: time.Duration(0),
-				Result:   errors.New("SKIPPED"),
+				Result:   fmt.Errorf("SKIPPED: %s", skipReason),
 			})
 			continue
 		}
+
+		stepLog := newStepLog(j.masker)
+		stepEmit := func(e Event) {
+			e.Step = step.GetName()
+			stepLog.handle(j.maskEvent(e))
+			j.emit(e)
+		}
+		stepEmit(Event{Type: EventStepStarted})
+
+		stepKey := ""
+		if sc := stepCacheOf(step); sc != nil && r.caches != nil {
+			startTime := time.Now()
+			var hit bool
+			stepKey, hit = j.cachedStep(ctx, capturingLogger(log, stepLog), r.caches, traced(ctx, du), c, step.GetName(), sc)
+			if hit {
+				j.record(StepMetrics{
+					StepName: step.GetName(),
+					Duration: time.Since(startTime),
+					Cached:   true,
+					Logs:     stepLog.snapshot(),
+				})
+				continue
+			}
+		}
+
+		group := fmt.Sprintf("%s / %s", j.Name, step.GetName())
+		j.provider.StartGroup(group)
 		startTime := time.Now()
-		err := step.Run(log, du, c, variables)
+		var effectiveEnv map[string]string
+		stepEnv, err := Env{Vars: stepEnvOf(step)}.resolve(variables)
+		if err == nil {
+			previous := applyEnv(c, stepEnv)
+			// the step's env over the job's, over the pipeline's
+			declared := maps.Clone(r.declaredEnv)
+			if declared == nil {
+				declared = map[string]bool{}
+			}
+			maps.Copy(declared, j.Env.declared())
+			maps.Copy(declared, Env{Vars: stepEnvOf(step)}.declared())
+			effectiveEnv = j.effectiveEnv(c, declared)
+			stepLogger := capturingLogger(log, stepLog)
+			err = step.Run(ctx, stepLogger, &observedDockerUtils{DockerUtils: du, emit: stepEmit, ctx: ctx, log: stepLogger}, c, variables)
+			restoreEnv(c, stepEnv, previous)
+		}
 		endTime := time.Now()
 		stepDuration := endTime.Sub(startTime)
-		if err != nil {
+		j.provider.EndGroup(group)
+		// steps may add secrets to the variables
+		j.collectSecrets(variables)
+		err = j.masker.maskError(err)
+		if err != nil && ctx.Err() != nil {
+			// the step was interrupted by the cancellation
+			cancelled = true
+			skipReason = fmt.Sprintf("cancelled: %s", context.Cause(ctx))
+		} else if err != nil {
 			gotError = true
+			if j.FailurePolicy != RunAll {
+				skipReason = fmt.Sprintf("step %s failed", step.GetName())
+			}
+			j.provider.Annotate(annotation(group, err))
+		} else if len(stepKey) > 0 {
+			if serr := j.saveStepOutputs(ctx, r.caches, traced(ctx, du), c, stepKey, stepCacheOf(step)); serr != nil {
+				log.Warn(fmt.Sprintf("failed to save the outputs of step %s: %s", step.GetName(), serr.Error()))
+			}
 		}
 
-		j.Metrics = append(j.Metrics, StepMetrics{
+		m := StepMetrics{
 			StepName: step.GetName(),
 			Duration: stepDuration,
 			Result:   err,
-		})
+			Env:      effectiveEnv,
+			Logs:     stepLog.snapshot(),
+		}
+		if len(r.logDir) > 0 {
+			m.LogFile, err = writeStepLog(r.logDir, j.Name, step.GetName(), m.Logs)
+			if err != nil {
+				log.Error(fmt.Sprintf("failed to write logs of step %s: %s", step.GetName(), err.Error()))
+			}
+		}
+		j.record(m)
+	}
+
+	if err := j.syncWorkspace(log, traced(ctx, du), c, copied, existing); err != nil {
+		err = j.masker.maskError(err)
+		log.Error(err.Error())
+		j.provider.Annotate(ci.Annotation{Title: j.Name, Message: err.Error()})
+		gotError = true
+	}
+
+	// artifacts are collected from failed jobs too, e.g. to inspect test reports
+	if err := j.collectArtifacts(r.artifacts, traced(ctx, du), c, variables); err != nil {
+		err = j.masker.maskError(err)
+		log.Error(fmt.Sprintf("failed to collect artifacts of job %s: %s", j.Name, err.Error()))
+		j.provider.Annotate(ci.Annotation{Title: j.Name, Message: err.Error()})
+		gotError = true
+	}
+
+	if r.caches != nil && !gotError && !cancelled {
+		j.saveCaches(ctx, log, r.caches, traced(ctx, du), c)
 	}
 
 	if gotError {
+		j.emit(Event{Type: EventJobFinished, Result: "FAIL", Duration: time.Since(jobStart), Error: "job failed"})
 		return errors.New("job failed")
 	}
 
+	if cancelled {
+		err := fmt.Errorf("%w: %s", ErrCancelled, context.Cause(ctx))
+		j.emit(Event{Type: EventJobFinished, Result: ResultCancelled, Duration: time.Since(jobStart), Error: err.Error()})
+		return err
+	}
+
+	j.emit(Event{Type: EventJobFinished, Result: "PASS", Duration: time.Since(jobStart)})
 	return nil
 }
 
+// computes the cache key of the step and restores its outputs if they were recorded. the step runs normally
+// when anything goes wrong, in which case the returned key is empty
+func (j *JobImpl) cachedStep(ctx context.Context, log *slog.Logger, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container, name string, sc *StepCache) (string, bool) {
+	key, err := j.stepCacheKey(du, c, sc)
+	if err != nil {
+		log.Warn(fmt.Sprintf("not caching step %s: %s", name, err.Error()))
+		return "", false
+	}
+
+	hit, err := j.restoreStepOutputs(ctx, s, du, c, key, sc)
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to restore the outputs of step %s: %s", name, err.Error()))
+		return key, false
+	}
+	if hit {
+		log.Info(fmt.Sprintf("step %s is cached (%s), restored its outputs", name, key))
+	}
+
+	return key, hit
+}
+
+// stores the metrics of a finished (or skipped) step
+func (j *JobImpl) record(m StepMetrics) {
+	j.Metrics = append(j.Metrics, m)
+	j.emit(Event{
+		Type:     EventStepFinished,
+		Step:     m.StepName,
+		Result:   resultOf(m),
+		Duration: m.Duration,
+		Error:    errorString(m.Result),
+	})
+}
+
+// returns the event with secrets masked
+func (j *JobImpl) maskEvent(e Event) Event {
+	e.Command = j.masker.mask(e.Command)
+	e.Stdout = j.masker.mask(e.Stdout)
+	e.Stderr = j.masker.mask(e.Stderr)
+	e.Error = j.masker.mask(e.Error)
+
+	return e
+}
+
+func (j *JobImpl) emit(e Event) {
+	if j.handler == nil {
+		return
+	}
+
+	e = j.maskEvent(e)
+	e.Job = j.Name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	j.handler(e)
+}
+
+// returns PASS, CACHED, FAIL or SKIP for the step
+func resultOf(m StepMetrics) string {
+	if m.Cached {
+		return "CACHED"
+	}
+
+	if m.Result == nil {
+		return "PASS"
+	}
+
+	if strings.Contains(m.Result.Error(), "SKIPPED") {
+		return "SKIP"
+	}
+
+	return "FAIL"
+}
+
 func (j *JobImpl) DisplaySummary() {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
@@ -97,25 +577,80 @@ func (j *JobImpl) DisplaySummary() {
 	t.AppendHeader(table.Row{"Result", "Step", "Duration"})
 
 	for _, m := range j.Metrics {
-		res := "PASS"
-		if m.Result != nil {
-			if strings.Contains(m.Result.Error(), "SKIPPED") {
-				res = "SKIP"
-			} else {
-				res = "FAIL"
-			}
-		}
-		t.AppendRow(table.Row{res, m.StepName, fmt.Sprintf("%s", m.Duration)})
+		t.AppendRow(table.Row{resultOf(m), m.StepName, fmt.Sprintf("%s", m.Duration)})
 	}
 	t.Render()
 
-	if len(os.Getenv("GITHUB_ACTIONS")) > 0 {
-		githubSummary, err := os.OpenFile(os.Getenv("GITHUB_STEP_SUMMARY"), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-		if err != nil {
-			return
+	artifacts := j.artifactsTable()
+	if artifacts != nil {
+		artifacts.SetOutputMirror(os.Stdout)
+		artifacts.Render()
+	}
+
+	for _, m := range j.Metrics {
+		if m.Result == nil || len(m.Logs) == 0 {
+			continue
+		}
+
+		fmt.Printf("last %d log lines of failed step %s:\n", len(tail(m.Logs, logTailLines)), m.StepName)
+		for _, e := range tail(m.Logs, logTailLines) {
+			fmt.Println(e.String())
+		}
+		if len(m.LogFile) > 0 {
+			fmt.Printf("full log: %s\n", m.LogFile)
 		}
+	}
 
-		t.SetOutputMirror(githubSummary)
+	_ = j.provider.WriteSummary(func(w io.Writer) {
+		t.SetOutputMirror(w)
 		t.RenderMarkdown()
+		if artifacts != nil {
+			fmt.Fprintln(w)
+			artifacts.SetOutputMirror(w)
+			artifacts.RenderMarkdown()
+		}
+	})
+}
+
+// lists the files of the job's artifacts with their size and checksum, nil if the job has no artifacts
+func (j *JobImpl) artifactsTable() table.Writer {
+	if len(j.Artifacts) == 0 {
+		return nil
+	}
+
+	t := table.NewWriter()
+	t.SetTitle(fmt.Sprintf("%s artifacts", j.Name))
+	t.AppendHeader(table.Row{"Artifact", "File", "Size", "SHA256"})
+
+	for _, a := range j.Artifacts {
+		if len(a.Files) == 0 {
+			t.AppendRow(table.Row{a.Name, "(no files)", formatBytes(0), ""})
+		}
+		for _, f := range a.Files {
+			t.AppendRow(table.Row{a.Name, f.Path, formatBytes(f.Size), f.SHA256})
+		}
 	}
+
+	return t
+}
+
+// builds the CI annotation for a failed step, including the source location when the step supplied one
+func annotation(title string, err error) ci.Annotation {
+	a := ci.Annotation{
+		Title:   title,
+		Message: err.Error(),
+	}
+
+	var stepErr *StepError
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &stepErr):
+		a.File = stepErr.File
+		a.Line = stepErr.Line
+		a.Warning = stepErr.Warning
+	case errors.As(err, &panicErr):
+		a.File, a.Line = panicErr.Location()
+	}
+
+	return a
 }
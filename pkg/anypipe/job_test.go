@@ -1,12 +1,16 @@
 package anypipe
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/notmiguelalves/anypipe/pkg/ci"
 	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -37,14 +41,14 @@ func TestJob(t *testing.T) {
 		return nil
 	}
 
-	du.EXPECT().CreateContainer("testimage:latest").Times(1).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
 	du.EXPECT().Exec(gomock.Any(), "echo 'TESTVALUE'").Times(1).Return("TESTVALUE", "", 0, nil)
 
 	job := NewJobImpl("test job", "testimage:latest").
 		WithStep("step1", f1).
 		WithStep("step2", f2)
 
-	err := job.Run(testLogger, du, map[string]interface{}{})
+	err := job.Run(context.Background(), testLogger, du, map[string]interface{}{})
 	assert.NoError(t, err)
 }
 
@@ -63,13 +67,48 @@ func TestJobWithFailedSteps(t *testing.T) {
 		return nil
 	}
 
-	du.EXPECT().CreateContainer("testimage:latest").Times(1).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
 
 	job := NewJobImpl("bad job", "testimage:latest").
 		WithStep("step1", f1).
 		WithStep("step2", f2)
 
-	err := job.Run(testLogger, du, map[string]interface{}{})
+	err := job.Run(context.Background(), testLogger, du, map[string]interface{}{})
 	assert.Error(t, err)
 	job.DisplaySummary()
 }
+
+func TestJobInGitHubActions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	env := map[string]string{
+		"GITHUB_ACTIONS":      "true",
+		"GITHUB_STEP_SUMMARY": filepath.Join(t.TempDir(), "summary"),
+	}
+	out := bytes.NewBuffer([]byte{})
+
+	f1 := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return &StepError{Err: errors.New("some error"), File: "main.go", Line: 3}
+	}
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	job := NewJobImpl("gh job", "testimage:latest").
+		WithStep("step1", f1).
+		WithStep("step2", f1)
+	job.WithCIProvider(ci.NewGitHub(env, out))
+
+	err := job.Run(context.Background(), testLogger, du, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "::group::gh job / step1\n::endgroup::\n::error title=gh job / step1,file=main.go,line=3::some error\n", out.String())
+
+	job.DisplaySummary()
+	summary, err := os.ReadFile(env["GITHUB_STEP_SUMMARY"])
+	assert.NoError(t, err)
+	assert.Contains(t, string(summary), "| FAIL | step1 |")
+	assert.Contains(t, string(summary), "| SKIP | step2 |")
+}
@@ -0,0 +1,194 @@
+package anypipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// number of log lines printed for failed steps in the summary
+const logTailLines = 20
+
+const (
+	StreamCommand = "cmd"
+	StreamStdout  = "stdout"
+	StreamStderr  = "stderr"
+	StreamLog     = "log"
+)
+
+// LogEntry is a line of output captured while a step ran
+type LogEntry struct {
+	Time time.Time
+	// one of StreamCommand, StreamStdout, StreamStderr or StreamLog
+	Stream string
+	Text   string
+}
+
+func (e LogEntry) String() string {
+	return fmt.Sprintf("%s [%s] %s", e.Time.Format(time.RFC3339Nano), e.Stream, e.Text)
+}
+
+// stepLog collects the commands, their output and the log records of a running step
+type stepLog struct {
+	mu      sync.Mutex
+	entries []LogEntry
+	masker  *masker
+	now     func() time.Time
+}
+
+func newStepLog(m *masker) *stepLog {
+	return &stepLog{
+		masker: m,
+		now:    time.Now,
+	}
+}
+
+// adds one entry per line of text
+func (l *stepLog) add(stream, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		l.entries = append(l.entries, LogEntry{
+			Time:   now,
+			Stream: stream,
+			Text:   l.masker.mask(strings.TrimRight(line, "\r")),
+		})
+	}
+}
+
+// captures the commands executed by the step and their output
+func (l *stepLog) handle(e Event) {
+	switch e.Type {
+	case EventExec:
+		l.add(StreamCommand, e.Command)
+	case EventOutput:
+		if len(e.Stdout) > 0 {
+			l.add(StreamStdout, e.Stdout)
+		}
+		if len(e.Stderr) > 0 {
+			l.add(StreamStderr, e.Stderr)
+		}
+	}
+}
+
+func (l *stepLog) snapshot() []LogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]LogEntry{}, l.entries...)
+}
+
+// StepLogger returns the logger of the step du was passed to, its records are captured in the step's logs along
+// with the output of its commands. outside of a step it returns slog.Default():
+//
+//	job.WithStep("build", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+//		anypipe.StepLogger(du).Info("building", "version", variables["version"])
+//		...
+//	})
+func StepLogger(du dockerutils.DockerUtils) *slog.Logger {
+	for {
+		switch d := du.(type) {
+		case *tracedDockerUtils:
+			du = d.DockerUtils
+		case *observedDockerUtils:
+			if d.log != nil {
+				return d.log
+			}
+			return slog.Default()
+		default:
+			return slog.Default()
+		}
+	}
+}
+
+// captureHandler copies log records into the step log before passing them on
+type captureHandler struct {
+	slog.Handler
+	log   *stepLog
+	attrs string
+}
+
+// returns a logger that also captures its records in l
+func capturingLogger(log *slog.Logger, l *stepLog) *slog.Logger {
+	return slog.New(&captureHandler{Handler: log.Handler(), log: l})
+}
+
+func formatAttr(a slog.Attr) string {
+	return fmt.Sprintf(" %s=%s", a.Key, a.Value.Resolve().String())
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	text := fmt.Sprintf("%s %s%s", r.Level, r.Message, h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		text += formatAttr(a)
+		return true
+	})
+	h.log.add(StreamLog, text)
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	formatted := h.attrs
+	for _, a := range attrs {
+		formatted += formatAttr(a)
+	}
+
+	return &captureHandler{Handler: h.Handler.WithAttrs(attrs), log: h.log, attrs: formatted}
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	return &captureHandler{Handler: h.Handler.WithGroup(name), log: h.log, attrs: h.attrs}
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// turns a job or step name into a safe file name
+func safeFileName(name string) string {
+	name = strings.Trim(unsafePathChars.ReplaceAllString(name, "_"), "_")
+	if len(name) == 0 {
+		return "_"
+	}
+
+	return name
+}
+
+// writes the entries to <dir>/<job>/<step>.log, returns the path of the file
+func writeStepLog(dir, job, step string, entries []LogEntry) (string, error) {
+	jobDir := filepath.Join(dir, safeFileName(job))
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return "", err
+	}
+
+	lines := []string{}
+	for _, e := range entries {
+		lines = append(lines, e.String())
+	}
+
+	path := filepath.Join(jobDir, safeFileName(step)+".log")
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+
+	return path, os.WriteFile(path, []byte(content), 0644)
+}
+
+// returns the last n entries
+func tail(entries []LogEntry, n int) []LogEntry {
+	if len(entries) <= n {
+		return entries
+	}
+
+	return entries[len(entries)-n:]
+}
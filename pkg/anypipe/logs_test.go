@@ -0,0 +1,113 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStepLog(t *testing.T) {
+	l := newStepLog(newMasker("hunter2"))
+	l.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	l.handle(Event{Type: EventStepStarted})
+	l.handle(Event{Type: EventExec, Command: "echo hunter2"})
+	l.handle(Event{Type: EventOutput, Command: "echo hunter2", Stdout: "hunter2\nline2\r\n", Stderr: "oops\n"})
+
+	logger := capturingLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), l).With("step", "build")
+	logger.Info("done", "token", "hunter2")
+
+	entries := l.snapshot()
+	assert.Equal(t, []LogEntry{
+		{Time: l.now(), Stream: StreamCommand, Text: "echo ***"},
+		{Time: l.now(), Stream: StreamStdout, Text: "***"},
+		{Time: l.now(), Stream: StreamStdout, Text: "line2"},
+		{Time: l.now(), Stream: StreamStderr, Text: "oops"},
+		{Time: l.now(), Stream: StreamLog, Text: "INFO done step=build token=***"},
+	}, entries)
+	assert.Equal(t, "2024-01-02T03:04:05Z [stdout] line2", entries[2].String())
+
+	assert.Equal(t, entries[3:], tail(entries, 2))
+	assert.Equal(t, entries, tail(entries, 10))
+}
+
+func TestSafeFileName(t *testing.T) {
+	type testcase struct {
+		name     string
+		expected string
+	}
+
+	testcases := []testcase{
+		{name: "build", expected: "build"},
+		{name: "run unit tests", expected: "run_unit_tests"},
+		{name: "../../etc/passwd", expected: ".._.._etc_passwd"},
+		{name: "/", expected: "_"},
+		{name: "", expected: "_"},
+	}
+
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, safeFileName(tc.name))
+	}
+}
+
+func TestPipelineWritesLogFiles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	dir := t.TempDir()
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().Exec(gomock.Any(), "go test ./...").Times(1).Return("ok\n", "token s3cr3t leaked\n", 1, nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+	du.EXPECT().Exec(gomock.Any(), "go test ./...").Times(1).Return("ok\n", "", 0, nil)
+
+	job := NewJobImpl("unit tests", "testimage:latest").
+		WithStep("go test", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			_, _, ec, _ := du.Exec(c, "go test ./...")
+			StepLogger(du).Info("tests ran", "exit_code", ec)
+			if ec != 0 {
+				return errors.New("tests failed")
+			}
+			return nil
+		})
+
+	pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithSequentialJobs(job).
+		WithMaskedValues("s3cr3t").
+		WithLogDir(dir).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	assert.Error(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+
+	m := job.GetMetrics()[0]
+	assert.Equal(t, filepath.Join(dir, pipeline.(*AnypipeImpl).RunID, "unit_tests", "go_test.log"), m.LogFile)
+
+	content, err := os.ReadFile(m.LogFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "[cmd] go test ./...")
+	assert.Contains(t, string(content), "[stdout] ok")
+	assert.Contains(t, string(content), "[stderr] token *** leaked")
+	assert.Contains(t, string(content), "[log] INFO running step go test")
+	assert.Contains(t, string(content), "[log] INFO tests ran exit_code=1")
+	assert.NotContains(t, string(content), "s3cr3t")
+	assert.Equal(t, len(m.Logs), strings.Count(string(content), "\n"))
+
+	// the next run writes its logs apart, the previous ones are kept
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+	next := job.GetMetrics()[len(job.GetMetrics())-1]
+	assert.NotEqual(t, m.LogFile, next.LogFile)
+	assert.FileExists(t, m.LogFile)
+	assert.FileExists(t, next.LogFile)
+}
@@ -0,0 +1,33 @@
+package anypipe
+
+import (
+	"github.com/notmiguelalves/anypipe/pkg/metrics"
+)
+
+// returns an event handler recording pipeline, job and step metrics in r
+func metricsHandler(r *metrics.Registry) EventHandler {
+	pipelineRuns := r.Counter("anypipe_pipeline_runs_total", "Pipeline runs, by result.", "pipeline", "result")
+	jobDuration := r.Histogram("anypipe_job_duration_seconds", "Job durations, by result.", nil, "job", "result")
+	stepDuration := r.Histogram("anypipe_step_duration_seconds", "Step durations, by result.", nil, "job", "step", "result")
+	stepFailures := r.Counter("anypipe_step_failures_total", "Failed steps.", "job", "step")
+
+	return func(e Event) {
+		switch e.Type {
+		case EventPipelineFinished:
+			pipelineRuns.Inc(e.Pipeline, e.Result)
+
+		case EventJobFinished:
+			jobDuration.Observe(e.Duration.Seconds(), e.Job, e.Result)
+
+		case EventStepFinished:
+			if e.Result == "SKIP" {
+				return
+			}
+
+			stepDuration.Observe(e.Duration.Seconds(), e.Job, e.Step, e.Result)
+			if e.Result == "FAIL" {
+				stepFailures.Inc(e.Job, e.Step)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPipelineMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	pass := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return nil
+	}
+	fail := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return errors.New("some error")
+	}
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	path := filepath.Join(t.TempDir(), "anypipe.prom")
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "test pipeline").
+		WithSequentialJobs(
+			NewJobImpl("job", "testimage:latest").
+				WithStep("step1", pass).
+				WithStep("step2", fail).
+				WithStep("step3", pass),
+		).
+		WithMetricsTextfile(path)
+
+	assert.Error(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), `anypipe_pipeline_runs_total{pipeline="test pipeline",result="FAIL"} 1`)
+	assert.Contains(t, string(res), `anypipe_job_duration_seconds_count{job="job",result="FAIL"} 1`)
+	assert.Contains(t, string(res), `anypipe_step_duration_seconds_count{job="job",step="step1",result="PASS"} 1`)
+	assert.Contains(t, string(res), `anypipe_step_duration_seconds_count{job="job",step="step2",result="FAIL"} 1`)
+	assert.NotContains(t, string(res), `step="step3"`)
+	assert.Contains(t, string(res), `anypipe_step_failures_total{job="job",step="step2"} 1`)
+}
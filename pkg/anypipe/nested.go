@@ -0,0 +1,399 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PipelineJob runs a pipeline as a job of another pipeline. the nested pipeline shares the run of its parent:
+// secrets, artifacts, caches and the log directory (in a sub-directory named after it)
+type PipelineJob struct {
+	pipeline *AnypipeImpl
+	// variables set by the nested pipeline are exported to the parent with the prefix, and variables of the
+	// parent starting with the prefix are visible to the nested pipeline without it
+	Prefix     string
+	Resources  Resources
+	Semaphores []string
+	// set on every job of the nested pipeline when it runs, over the jobs' own
+	SecretEnv   map[string]string
+	SecretFiles map[string]string
+	Workspace   *Workspace
+	applied     sync.Once
+	handler     EventHandler
+	// set by builder methods that don't apply to nested pipelines, returned by Run
+	err error
+}
+
+// wraps p, built with NewPipelineImpl, so it can be added to another pipeline like any job
+func NewPipelineJob(p Anypipe, prefix string) Job {
+	pj := &PipelineJob{Prefix: prefix, SecretEnv: map[string]string{}, SecretFiles: map[string]string{}}
+
+	inner, ok := p.(*AnypipeImpl)
+	if !ok {
+		pj.pipeline = NewPipelineImpl(context.Background(), slog.Default(), fmt.Sprintf("%T", p)).(*AnypipeImpl)
+		pj.err = fmt.Errorf("only pipelines created with NewPipelineImpl can be nested, got %T", p)
+		return pj
+	}
+
+	inner.nested = true
+	inner.handlers = append(inner.handlers, pj.forward)
+	pj.pipeline = inner
+
+	return pj
+}
+
+// passes the events of the nested pipeline on to the parent, with job names qualified by the pipeline's name
+func (pj *PipelineJob) forward(e Event) {
+	if pj.handler == nil || e.Type == EventPipelineStarted || e.Type == EventPipelineFinished {
+		return
+	}
+
+	e.Job = pj.qualify(e.Job)
+	pj.handler(e)
+}
+
+func (pj *PipelineJob) qualify(name string) string {
+	return fmt.Sprintf("%s / %s", pj.pipeline.Name, name)
+}
+
+func (pj *PipelineJob) unsupported(method string) Job {
+	pj.err = errors.Join(pj.err, fmt.Errorf("%s is not supported by nested pipeline %s, add it to one of its jobs", method, pj.pipeline.Name))
+
+	return pj
+}
+
+func (pj *PipelineJob) WithStep(stepName string, f StepFunc) Job {
+	return pj.unsupported("WithStep")
+}
+
+func (pj *PipelineJob) WithSteps(steps ...Step) Job {
+	return pj.unsupported("WithSteps")
+}
+
+func (pj *PipelineJob) WithCachedStep(stepName string, sc StepCache, f StepFunc) Job {
+	return pj.unsupported("WithCachedStep")
+}
+
+func (pj *PipelineJob) WithArtifacts(name string, globs ...string) Job {
+	return pj.unsupported("WithArtifacts")
+}
+
+func (pj *PipelineJob) WithArtifactInput(name, dst string) Job {
+	return pj.unsupported("WithArtifactInput")
+}
+
+func (pj *PipelineJob) WithCache(path, key string, restoreKeys ...string) Job {
+	return pj.unsupported("WithCache")
+}
+
+func (pj *PipelineJob) WithSetsVariables(names ...string) Job {
+	return pj.unsupported("WithSetsVariables")
+}
+
+// the provider is used by the nested pipeline and all of its jobs
+func (pj *PipelineJob) WithCIProvider(p ci.Provider) Job {
+	pj.pipeline.WithCIProvider(p)
+
+	return pj
+}
+
+func (pj *PipelineJob) WithEventHandler(h EventHandler) Job {
+	pj.handler = h
+
+	return pj
+}
+
+// exposes the secret variable in the containers of all jobs of the nested pipeline
+func (pj *PipelineJob) WithSecretEnv(envKey, variable string) Job {
+	pj.SecretEnv[envKey] = variable
+
+	return pj
+}
+
+// writes the secret variable to a file in the containers of all jobs of the nested pipeline
+func (pj *PipelineJob) WithSecretFile(name, variable string) Job {
+	pj.SecretFiles[name] = variable
+
+	return pj
+}
+
+// shares the host directory with the containers of all jobs of the nested pipeline
+func (pj *PipelineJob) WithWorkspace(ws Workspace) Job {
+	pj.Workspace = &ws
+
+	return pj
+}
+
+// sets the secrets and the workspace of the nested pipeline on each of its jobs, whenever they were added. it
+// happens once, when the nested pipeline first runs or an agent is first assigned one of its jobs
+func (pj *PipelineJob) applySettings() {
+	pj.applied.Do(func() {
+		for _, job := range pj.pipeline.Jobs {
+			for _, k := range sortedKeys(pj.SecretEnv) {
+				job.WithSecretEnv(k, pj.SecretEnv[k])
+			}
+			for _, name := range sortedKeys(pj.SecretFiles) {
+				job.WithSecretFile(name, pj.SecretFiles[name])
+			}
+			if pj.Workspace != nil {
+				job.WithWorkspace(*pj.Workspace)
+			}
+		}
+	})
+}
+
+// sets an env variable in the containers of all jobs of the nested pipeline, over those of the parent
+func (pj *PipelineJob) WithEnv(key, value string) Job {
+	pj.pipeline.WithEnv(key, value)
+
+	return pj
+}
+
+func (pj *PipelineJob) WithHostEnv(names ...string) Job {
+	pj.pipeline.WithHostEnv(names...)
+
+	return pj
+}
+
+func (pj *PipelineJob) WithEnvFile(path string) Job {
+	pj.pipeline.WithEnvFile(path)
+
+	return pj
+}
+
+// the nested pipeline as a whole holds the resources while it runs
+func (pj *PipelineJob) WithResources(cpus float64, memory int64) Job {
+	pj.Resources = Resources{CPUs: cpus, Memory: memory}
+
+	return pj
+}
+
+func (pj *PipelineJob) WithSemaphores(names ...string) Job {
+	pj.Semaphores = append(pj.Semaphores, names...)
+
+	return pj
+}
+
+// sets the failure policy of the nested pipeline
+func (pj *PipelineJob) WithFailurePolicy(policy FailurePolicy) Job {
+	pj.pipeline.WithFailurePolicy(policy)
+
+	return pj
+}
+
+func (pj *PipelineJob) Run(ctx context.Context,
+	log *slog.Logger,
+	du dockerutils.DockerUtils,
+	variables map[string]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, fmt.Sprintf("pipeline %s", pj.pipeline.Name), attribute.String("pipeline", pj.pipeline.Name))
+	defer func() { endSpan(ctx, span, err) }()
+
+	pj.emit(Event{Type: EventJobStarted})
+	startTime := time.Now()
+	defer func() {
+		e := Event{Type: EventJobFinished, Result: ResultPass, Duration: time.Since(startTime)}
+		if err != nil {
+			e.Result = ResultFail
+			e.Error = err.Error()
+		}
+		pj.emit(e)
+	}()
+
+	if pj.err != nil {
+		return pj.err
+	}
+
+	pj.applySettings()
+	r := runFrom(ctx)
+	r.masker.add(pj.pipeline.Masked...)
+	nested := *r
+	if len(r.logDir) > 0 {
+		nested.logDir = filepath.Join(r.logDir, safeFileName(pj.pipeline.Name))
+	}
+	ctx = withRun(ctx, &nested)
+
+	log.Info(fmt.Sprintf("starting nested pipeline %s", pj.pipeline.Name))
+	scoped := pj.scopeVariables(variables)
+	before := maps.Clone(scoped)
+
+	// the nested pipeline's env, on top of the parent's
+	env, err := pj.pipeline.Env.resolve(scoped)
+	if err != nil {
+		return err
+	}
+	nested.env = maps.Clone(r.env)
+	if nested.env == nil {
+		nested.env = map[string]string{}
+	}
+	maps.Copy(nested.env, env)
+	nested.declaredEnv = map[string]bool{}
+	maps.Copy(nested.declaredEnv, r.declaredEnv)
+	maps.Copy(nested.declaredEnv, pj.pipeline.Env.declared())
+
+	_ = pj.pipeline.runJobs(ctx, du, scoped)
+	pj.exportVariables(variables, before, scoped)
+
+	return pj.rollUp()
+}
+
+// the variables as seen by the nested pipeline
+func (pj *PipelineJob) scopeVariables(variables map[string]interface{}) map[string]interface{} {
+	scoped := maps.Clone(variables)
+	if len(pj.Prefix) == 0 {
+		return scoped
+	}
+
+	for k, v := range variables {
+		if name, ok := strings.CutPrefix(k, pj.Prefix); ok {
+			scoped[name] = v
+		}
+	}
+
+	return scoped
+}
+
+// copies the variables set or changed by the nested pipeline to the parent's, with the prefix
+func (pj *PipelineJob) exportVariables(variables, before, after map[string]interface{}) {
+	for k, v := range after {
+		// the outputs of the nested pipeline's jobs are its own
+		if k == JobOutputsVariable {
+			continue
+		}
+		if old, ok := before[k]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		variables[pj.Prefix+k] = v
+	}
+}
+
+// the nested pipeline fails if any of its jobs failed, and is cancelled if its jobs were
+func (pj *PipelineJob) rollUp() error {
+	failed := []string{}
+	cancelled := []string{}
+	for _, r := range pj.pipeline.Results {
+		switch r.Result {
+		case ResultFail:
+			failed = append(failed, r.Job)
+		case ResultCancelled:
+			cancelled = append(cancelled, r.Job)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("nested pipeline %s failed: jobs %s failed", pj.pipeline.Name, strings.Join(failed, ", "))
+	}
+	if len(cancelled) > 0 {
+		return fmt.Errorf("%w: jobs %s of nested pipeline %s were cancelled", ErrCancelled, strings.Join(cancelled, ", "), pj.pipeline.Name)
+	}
+
+	return nil
+}
+
+func (pj *PipelineJob) emit(e Event) {
+	if pj.handler == nil {
+		return
+	}
+
+	e.Job = pj.pipeline.Name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	pj.handler(e)
+}
+
+// displays the summaries of the nested pipeline's jobs, followed by the outcome of each of them
+func (pj *PipelineJob) DisplaySummary() {
+	for _, job := range pj.pipeline.Jobs {
+		if _, ran := pj.pipeline.resultOf(job.GetName()); ran {
+			job.DisplaySummary()
+		}
+	}
+	pj.pipeline.DisplaySummary()
+}
+
+func (pj *PipelineJob) GetName() string {
+	return pj.pipeline.Name
+}
+
+// the metrics of the steps of all jobs of the nested pipeline, named "<job> / <step>"
+func (pj *PipelineJob) GetMetrics() []StepMetrics {
+	metrics := []StepMetrics{}
+	for _, job := range pj.pipeline.Jobs {
+		for _, m := range job.GetMetrics() {
+			m.StepName = fmt.Sprintf("%s / %s", job.GetName(), m.StepName)
+			metrics = append(metrics, m)
+		}
+	}
+
+	return metrics
+}
+
+func (pj *PipelineJob) GetArtifacts() []Artifact {
+	artifacts := []Artifact{}
+	for _, job := range pj.pipeline.Jobs {
+		artifacts = append(artifacts, job.GetArtifacts()...)
+	}
+
+	return artifacts
+}
+
+func (pj *PipelineJob) GetResources() Resources {
+	return pj.Resources
+}
+
+func (pj *PipelineJob) GetSemaphores() []string {
+	return pj.Semaphores
+}
+
+// the artifacts needed by the nested pipeline's jobs that none of them produces
+func (pj *PipelineJob) GetArtifactInputs() []string {
+	outputs := pj.GetArtifactOutputs()
+
+	inputs := []string{}
+	for _, job := range pj.pipeline.Jobs {
+		for _, input := range job.GetArtifactInputs() {
+			if !contains(outputs, input) && !contains(inputs, input) {
+				inputs = append(inputs, input)
+			}
+		}
+	}
+	sort.Strings(inputs)
+
+	return inputs
+}
+
+func (pj *PipelineJob) GetArtifactOutputs() []string {
+	outputs := []string{}
+	for _, job := range pj.pipeline.Jobs {
+		outputs = append(outputs, job.GetArtifactOutputs()...)
+	}
+	sort.Strings(outputs)
+
+	return outputs
+}
+
+// variables the jobs of the nested pipeline declare they set, as exported to the parent
+func (pj *PipelineJob) GetSetVariables() []string {
+	names := []string{}
+	for _, job := range pj.pipeline.Jobs {
+		for _, name := range job.GetSetVariables() {
+			names = append(names, pj.Prefix+name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,197 @@
+package anypipe
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPipelineJob(t *testing.T) {
+	type testcase struct {
+		name            string
+		steps           []StepFunc
+		expectedErr     bool
+		expectedResult  string
+		expectedResults []JobResult
+	}
+
+	testcases := []testcase{
+		{
+			name:           "passes when all jobs pass",
+			steps:          []StepFunc{passingStep, passingStep},
+			expectedResult: ResultPass,
+			expectedResults: []JobResult{
+				{Job: "a", Result: ResultPass},
+				{Job: "b", Result: ResultPass},
+			},
+		},
+		{
+			name:           "fails when a job fails",
+			steps:          []StepFunc{failingStep, passingStep},
+			expectedErr:    true,
+			expectedResult: ResultFail,
+			expectedResults: []JobResult{
+				{Job: "a", Result: ResultFail, Reason: "job failed"},
+				{Job: "b", Result: ResultNotRun, Reason: "job a failed (finish-running)"},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+			inner := testPipeline()
+			inner.Name = "inner"
+			inner.WithSequentialJobs(
+				NewJobImpl("a", "testimage:latest").WithStep("step", tc.steps[0]),
+				NewJobImpl("b", "testimage:latest").WithStep("step", tc.steps[1]),
+			)
+
+			outer := testPipeline()
+			outer.WithSequentialJobs(NewPipelineJob(inner, ""))
+
+			err := outer.run(du, map[string]interface{}{})
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			result, _ := outer.resultOf("inner")
+			assert.Equal(t, tc.expectedResult, result.Result)
+			assert.Equal(t, tc.expectedResults, inner.Results)
+		})
+	}
+}
+
+func TestPipelineJobVariables(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	seen := map[string]interface{}{}
+	inner := testPipeline()
+	inner.Name = "inner"
+	inner.WithSequentialJobs(NewJobImpl("build", "testimage:latest").
+		WithStep("step", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			seen["target"] = variables["target"]
+			seen["shared"] = variables["shared"]
+			variables["version"] = "1.2.3"
+			return nil
+		}))
+
+	outer := testPipeline()
+	outer.WithSequentialJobs(NewPipelineJob(inner, "inner."))
+
+	variables := map[string]interface{}{"inner.target": "linux", "shared": "yes"}
+	assert.NoError(t, outer.run(du, variables))
+
+	assert.Equal(t, map[string]interface{}{"target": "linux", "shared": "yes"}, seen)
+	assert.Equal(t, "1.2.3", variables["inner.version"])
+	assert.NotContains(t, variables, "version")
+	assert.NotContains(t, variables, "inner.shared")
+}
+
+func TestPipelineJobEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	inner := testPipeline()
+	inner.Name = "inner"
+	inner.WithSequentialJobs(NewJobImpl("a", "testimage:latest").WithStep("step", passingStep))
+
+	mu := sync.Mutex{}
+	jobs := []string{}
+	outer := testPipeline()
+	outer.WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == EventJobStarted {
+			jobs = append(jobs, e.Job)
+		}
+	})
+	outer.WithSequentialJobs(NewPipelineJob(inner, ""))
+
+	assert.NoError(t, outer.run(du, map[string]interface{}{}))
+	assert.Equal(t, []string{"inner", "inner / a"}, jobs)
+
+	metrics := outer.Jobs[0].GetMetrics()
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "a / step", metrics[0].StepName)
+}
+
+func TestPipelineJobUnsupported(t *testing.T) {
+	inner := testPipeline()
+	inner.Name = "inner"
+
+	pj := NewPipelineJob(inner, "").WithStep("step", passingStep)
+
+	err := pj.Run(testPipeline().ctx, testPipeline().log, nil, map[string]interface{}{})
+	assert.ErrorContains(t, err, "WithStep is not supported by nested pipeline inner")
+}
+
+func TestPipelineJobSettings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(&dockerutils.Container{}, nil)
+
+	inner := testPipeline()
+	inner.Name = "inner"
+	pj := NewPipelineJob(inner, "").WithSecretEnv("TOKEN", "token").WithSecretEnv("KEY", "key")
+
+	// jobs added after the settings get them too
+	var token string
+	inner.WithSequentialJobs(NewJobImpl("a", "testimage:latest").
+		WithSecretEnv("KEY", "other_key").
+		WithStep("step", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			token, _ = c.LookupEnv("TOKEN")
+			return nil
+		}))
+
+	outer := testPipeline()
+	outer.WithSequentialJobs(pj)
+	assert.NoError(t, outer.run(du, map[string]interface{}{"token": NewSecret("t0k3n"), "key": NewSecret("k3y")}))
+	assert.Equal(t, "t0k3n", token)
+	assert.Equal(t, map[string]string{"TOKEN": "token", "KEY": "key"}, inner.Jobs[0].(*JobImpl).SecretEnv)
+}
+
+func TestPipelineJobCancelled(t *testing.T) {
+	inner := testPipeline()
+	inner.Name = "inner"
+	inner.Results = []JobResult{{Job: "a", Result: ResultCancelled}}
+
+	err := NewPipelineJob(inner, "").(*PipelineJob).rollUp()
+	assert.True(t, errors.Is(err, ErrCancelled))
+}
+
+func TestJobTemplate(t *testing.T) {
+	type params struct {
+		os   string
+		arch string
+	}
+
+	build := JobTemplate[params](func(p params) Job {
+		return NewJobImpl("build "+p.os+"/"+p.arch, "golang:1.22").WithStep("build", passingStep)
+	})
+
+	jobs := build.Jobs(params{"linux", "amd64"}, params{"darwin", "arm64"})
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "build linux/amd64", jobs[0].GetName())
+	assert.Equal(t, "build darwin/arm64", jobs[1].GetName())
+}
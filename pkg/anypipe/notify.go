@@ -0,0 +1,73 @@
+package anypipe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/notify"
+)
+
+// how long notifiers get to deliver the result, retries included
+const notifyTimeout = time.Minute
+
+type notification struct {
+	notifier notify.Notifier
+	when     notify.When
+}
+
+// tells n about the outcome of the pipeline once it finished, on every run or on failures only.
+// notifications that can't be delivered are logged, they don't fail the pipeline
+func (p *AnypipeImpl) WithNotifier(n notify.Notifier, when notify.When) Anypipe {
+	p.notifications = append(p.notifications, notification{notifier: n, when: when})
+
+	return p
+}
+
+// the outcome of the run as seen by notifiers, with secrets masked
+func (p *AnypipeImpl) notifyResult(duration time.Duration, err error) notify.Result {
+	md := p.provider.Metadata()
+	r := notify.Result{
+		Pipeline:  p.Name,
+		Result:    notify.ResultPass,
+		Duration:  duration.Round(time.Millisecond),
+		Jobs:      []notify.JobResult{},
+		CommitSHA: md.CommitSHA,
+		Branch:    md.Branch,
+		BuildURL:  md.BuildURL,
+	}
+
+	for _, jr := range p.Results {
+		r.Jobs = append(r.Jobs, notify.JobResult{Job: jr.Job, Result: jr.Result, Reason: p.masker.mask(jr.Reason)})
+		if jr.Result == ResultCancelled && r.Result == notify.ResultPass {
+			r.Result = notify.ResultCancelled
+		}
+	}
+	if err != nil {
+		r.Result = notify.ResultFail
+		r.Error = p.masker.mask(err.Error())
+	}
+
+	return r
+}
+
+// delivers the result to the notifiers concerned, even if the pipeline was cancelled
+func (p *AnypipeImpl) notify(ctx context.Context, duration time.Duration, err error) {
+	if len(p.notifications) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+
+	r := p.notifyResult(duration, err)
+	for _, n := range p.notifications {
+		if !n.when.Matches(r) {
+			continue
+		}
+
+		if err := n.notifier.Notify(ctx, r); err != nil {
+			p.log.Error(fmt.Sprintf("failed to send notification: %s", err.Error()))
+		}
+	}
+}
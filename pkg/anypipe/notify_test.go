@@ -0,0 +1,80 @@
+package anypipe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/notify"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type recordingNotifier struct {
+	results []notify.Result
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, r notify.Result) error {
+	n.results = append(n.results, r)
+	return nil
+}
+
+func TestNotifiers(t *testing.T) {
+	type testcase struct {
+		name           string
+		step           StepFunc
+		expectedResult string
+		expectedAlways int
+		expectedFailed int
+	}
+
+	testcases := []testcase{
+		{
+			name:           "notifies on success",
+			step:           passingStep,
+			expectedResult: notify.ResultPass,
+			expectedAlways: 1,
+		},
+		{
+			name:           "notifies on failure",
+			step:           failingStep,
+			expectedResult: notify.ResultFail,
+			expectedAlways: 1,
+			expectedFailed: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+			always := &recordingNotifier{}
+			onFailure := &recordingNotifier{}
+			pipeline := testPipeline()
+			pipeline.WithSequentialJobs(NewJobImpl("job", "testimage:latest").WithStep("step", tc.step)).
+				WithNotifier(always, notify.Always).
+				WithNotifier(onFailure, notify.OnFailure)
+
+			_ = pipeline.run(du, map[string]interface{}{})
+			assert.Len(t, always.results, tc.expectedAlways)
+			assert.Len(t, onFailure.results, tc.expectedFailed)
+			assert.Equal(t, "test pipeline", always.results[0].Pipeline)
+			assert.Equal(t, tc.expectedResult, always.results[0].Result)
+			assert.Equal(t, "job", always.results[0].Jobs[0].Job)
+		})
+	}
+}
+
+func TestNotifyResultMasksSecrets(t *testing.T) {
+	pipeline := testPipeline()
+	pipeline.WithMaskedValues("hunter2")
+	pipeline.Results = []JobResult{{Job: "deploy", Result: ResultFail, Reason: "login with hunter2 failed"}}
+
+	r := pipeline.notifyResult(0, assert.AnError)
+	assert.Equal(t, "login with *** failed", r.Jobs[0].Reason)
+	assert.Equal(t, notify.ResultFail, r.Result)
+}
@@ -2,54 +2,585 @@ package anypipe
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
 	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/history"
+	"github.com/notmiguelalves/anypipe/pkg/metrics"
+	"github.com/notmiguelalves/anypipe/pkg/notify"
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Anypipe interface {
 	WithSequentialJobs(jobs ...Job) Anypipe
+	WithParallelJobs(jobs ...Job) Anypipe
+	WithMaxConcurrency(n int) Anypipe
+	WithResourceBudget(cpus float64, memory int64) Anypipe
+	WithSemaphore(name string, capacity int) Anypipe
+	WithFailurePolicy(policy FailurePolicy) Anypipe
+	WithMaskedValues(values ...string) Anypipe
+	WithOutputs(names ...string) Anypipe
+	WithInputs(names ...string) Anypipe
+	WithExportedEnv(names ...string) Anypipe
+	WithCIProvider(p ci.Provider) Anypipe
+	WithEventHandler(h EventHandler) Anypipe
+	WithTracerProvider(tp trace.TracerProvider) Anypipe
+	WithMetrics(r *metrics.Registry) Anypipe
+	WithMetricsTextfile(path string) Anypipe
+	WithLogDir(dir string) Anypipe
+	WithArtifactDir(dir string) Anypipe
+	WithCacheStore(s cache.Store) Anypipe
+	WithCacheEviction(policy cache.Policy) Anypipe
+	WithNotifier(n notify.Notifier, when notify.When) Anypipe
+	WithEnv(key, value string) Anypipe
+	WithHostEnv(names ...string) Anypipe
+	WithEnvFile(path string) Anypipe
+	WithHistory(s *history.Store) Anypipe
+	WithImagePrePull(concurrency int) Anypipe
+	WithCoordinator(c *remote.Coordinator) Anypipe
+	Validate(variables map[string]interface{}) error
 	Run(variables map[string]interface{}) error
+	RunAgent(a *remote.Agent) error
 }
 
 type AnypipeImpl struct {
 	Name string
 	Jobs []Job
-	ctx  context.Context
-	log  *slog.Logger
+	// jobs grouped in the order they run, the jobs of a stage run concurrently
+	Stages [][]Job
+	// maximum number of jobs running at once, 0 for no limit
+	MaxConcurrency int
+	// resources of the host jobs can request, zero values mean no limit
+	Budget Resources
+	// capacity of named semaphores, keyed by name
+	Semaphores map[string]int
+	// what still runs once a job failed
+	FailurePolicy FailurePolicy
+	// env variables of every job's container
+	Env Env
+	// images pulled at once before the jobs run, 0 to let each job pull its image
+	PullConcurrency int
+	// images pulled before the jobs ran, once the pipeline ran
+	Pulls []ImagePull
+	// variables set or changed by each job, once the pipeline ran
+	JobOutputs map[string]map[string]interface{}
+	// outcome of each job, once the pipeline ran
+	Results         []JobResult
+	Masked          []string
+	Outputs         []string
+	Inputs          []string
+	ExportedEnv     []string
+	MetricsTextfile string
+	LogDir          string
+	ArtifactDir     string
+	CacheEviction   cache.Policy
+	ctx             context.Context
+	log             *slog.Logger
+	provider        ci.Provider
+	handlers        []EventHandler
+	tracer          trace.TracerProvider
+	metrics         *metrics.Registry
+	masker          *masker
+	caches          cache.Store
+	emitMu          sync.Mutex
+	resultsMu       sync.Mutex
+	firstFailure    string
+	notifications   []notification
+	history         *history.Store
+	coordinator     *remote.Coordinator
+	// ID of the current run, its step logs are written to <LogDir>/<RunID> and it is recorded under it in the history
+	RunID string
+	// how long each job of the current run took, kept for the history
+	durations map[string]time.Duration
+	// the pipeline runs as a job of another pipeline, which displays its summary
+	nested bool
 }
 
+// creates a pipeline. secrets (see Secret) are masked in everything logged through log
 func NewPipelineImpl(ctx context.Context, log *slog.Logger, name string) Anypipe {
+	m := newMasker()
+
 	return &AnypipeImpl{
-		Name: name,
-		Jobs: []Job{},
-		ctx:  ctx,
-		log:  log,
+		Name:          name,
+		Jobs:          []Job{},
+		Stages:        [][]Job{},
+		Semaphores:    map[string]int{},
+		FailurePolicy: FinishRunning,
+		ctx:           ctx,
+		log:           maskedLogger(log, m),
+		provider:      ci.Current(),
+		tracer:        otel.GetTracerProvider(),
+		masker:        m,
 	}
 }
 
 func (p *AnypipeImpl) WithSequentialJobs(jobs ...Job) Anypipe {
 	p.Jobs = append(p.Jobs, jobs...)
+	for _, job := range jobs {
+		p.Stages = append(p.Stages, []Job{job})
+	}
+
+	return p
+}
+
+// adds jobs running concurrently, within the limits of the scheduler, once the previous jobs finished.
+// each job gets a copy of the variables, which are merged back in order once all of them finished
+func (p *AnypipeImpl) WithParallelJobs(jobs ...Job) Anypipe {
+	p.Jobs = append(p.Jobs, jobs...)
+	p.Stages = append(p.Stages, jobs)
+
+	return p
+}
+
+// limits the number of jobs running at once
+func (p *AnypipeImpl) WithMaxConcurrency(n int) Anypipe {
+	p.MaxConcurrency = n
+
+	return p
+}
+
+// jobs only start when the resources they request (see Job.WithResources) fit in what is left of
+// cpus and memory (in bytes). zero values mean no limit
+func (p *AnypipeImpl) WithResourceBudget(cpus float64, memory int64) Anypipe {
+	p.Budget = Resources{CPUs: cpus, Memory: memory}
+
+	return p
+}
+
+// sets how many jobs can hold the named semaphore at once (see Job.WithSemaphores), defaults to 1
+func (p *AnypipeImpl) WithSemaphore(name string, capacity int) Anypipe {
+	p.Semaphores[name] = capacity
+
+	return p
+}
+
+// values that should never show up in logs, command output or reports. variables of type Secret are masked
+// without having to be registered
+func (p *AnypipeImpl) WithMaskedValues(values ...string) Anypipe {
+	p.Masked = append(p.Masked, values...)
+	p.masker.add(values...)
+
+	return p
+}
+
+// variables exported as outputs once the pipeline finishes, e.g. to $GITHUB_OUTPUT
+func (p *AnypipeImpl) WithOutputs(names ...string) Anypipe {
+	p.Outputs = append(p.Outputs, names...)
+
+	return p
+}
+
+// variables that must be passed to Run, Validate reports those that aren't set
+func (p *AnypipeImpl) WithInputs(names ...string) Anypipe {
+	p.Inputs = append(p.Inputs, names...)
+
+	return p
+}
+
+// variables exported as environment once the pipeline finishes, e.g. to $GITHUB_ENV
+func (p *AnypipeImpl) WithExportedEnv(names ...string) Anypipe {
+	p.ExportedEnv = append(p.ExportedEnv, names...)
+
+	return p
+}
+
+// overrides the detected CI provider, for the pipeline and all of its jobs
+func (p *AnypipeImpl) WithCIProvider(provider ci.Provider) Anypipe {
+	p.provider = provider
 
 	return p
 }
 
+// registers a handler receiving progress events of the pipeline, its jobs and steps
+func (p *AnypipeImpl) WithEventHandler(h EventHandler) Anypipe {
+	p.handlers = append(p.handlers, h)
+
+	return p
+}
+
+// traces the pipeline, its jobs, steps and Docker operations with the given provider.
+// defaults to the globally registered provider
+func (p *AnypipeImpl) WithTracerProvider(tp trace.TracerProvider) Anypipe {
+	p.tracer = tp
+
+	return p
+}
+
+// records step durations and failures, and Docker image pull times, exec counts and copied bytes in r.
+// r can be exposed with r.Server(addr) in long-running modes
+func (p *AnypipeImpl) WithMetrics(r *metrics.Registry) Anypipe {
+	p.metrics = r
+	p.handlers = append(p.handlers, metricsHandler(r))
+
+	return p
+}
+
+// writes the metrics to path, for the node-exporter textfile collector, once the pipeline finishes
+func (p *AnypipeImpl) WithMetricsTextfile(path string) Anypipe {
+	if p.metrics == nil {
+		p.WithMetrics(metrics.NewRegistry())
+	}
+	p.MetricsTextfile = path
+
+	return p
+}
+
+// writes the captured output of each step to <dir>/<job>/<step>.log
+func (p *AnypipeImpl) WithLogDir(dir string) Anypipe {
+	p.LogDir = dir
+
+	return p
+}
+
+// stores the artifacts produced by jobs in dir. by default they are kept in a temporary directory
+// that is removed once the pipeline finishes
+func (p *AnypipeImpl) WithArtifactDir(dir string) Anypipe {
+	p.ArtifactDir = dir
+
+	return p
+}
+
+// keeps the caches declared by jobs (see Job.WithCache) in s. caching is disabled without a store
+func (p *AnypipeImpl) WithCacheStore(s cache.Store) Anypipe {
+	p.caches = s
+
+	return p
+}
+
+// removes cache entries not allowed by the policy once the pipeline finishes
+func (p *AnypipeImpl) WithCacheEviction(policy cache.Policy) Anypipe {
+	p.CacheEviction = policy
+
+	return p
+}
+
+// decides what still runs once a job failed, defaults to FinishRunning
+func (p *AnypipeImpl) WithFailurePolicy(policy FailurePolicy) Anypipe {
+	p.FailurePolicy = policy
+
+	return p
+}
+
+// handlers are called one event at a time, even when jobs run concurrently
+func (p *AnypipeImpl) emit(e Event) {
+	p.emitMu.Lock()
+	defer p.emitMu.Unlock()
+
+	e.Pipeline = p.Name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	multiHandler(p.handlers)(e)
+}
+
 func (p *AnypipeImpl) Run(variables map[string]interface{}) error {
 	p.log.Info(fmt.Sprintf("starting pipeline %s", p.Name))
+	// before connecting to docker, mistakes are reported without pulling any image
+	if err := p.Validate(variables); err != nil {
+		p.log.Error(err.Error())
+		return err
+	}
+	if p.coordinator != nil {
+		// the jobs run on the agents, which connect to docker
+		return p.run(nil, variables)
+	}
+
 	du, err := dockerutils.New(p.ctx, p.log)
 	if err != nil {
 		return err
 	}
 	defer du.Close()
 
-	for _, job := range p.Jobs {
-		err := job.Run(p.log, du, variables)
-		job.DisplaySummary()
+	if p.metrics != nil {
+		du.WithMetrics(p.metrics)
+	}
+
+	return p.run(du, variables)
+}
+
+func (p *AnypipeImpl) run(du dockerutils.DockerUtils, variables map[string]interface{}) (err error) {
+	ctx, span := p.tracer.Tracer(tracerName).Start(p.ctx, fmt.Sprintf("pipeline %s", p.Name), trace.WithAttributes(attribute.String("pipeline", p.Name)))
+	defer func() { endSpan(ctx, span, p.masker.maskError(err)) }()
+
+	md := p.provider.Metadata()
+	p.log.Debug(fmt.Sprintf("running on %s (commit: %s, branch: %s, pr: %s, build: %s)", md.Provider, md.CommitSHA, md.Branch, md.PRNumber, md.BuildURL))
+
+	artifactDir := p.ArtifactDir
+	if len(artifactDir) == 0 {
+		artifactDir, err = os.MkdirTemp("", "anypipe-artifacts-")
 		if err != nil {
 			return err
 		}
+		defer os.RemoveAll(artifactDir)
+	}
+
+	p.RunID, err = history.NewID(time.Now())
+	if err != nil {
+		return err
+	}
+	logDir := p.LogDir
+	if len(logDir) > 0 {
+		logDir = filepath.Join(logDir, p.RunID)
+	}
+
+	p.masker.collect(variables)
+	env, err := p.Env.resolve(variables)
+	if err != nil {
+		p.log.Error(fmt.Sprintf("failed to resolve the env of pipeline %s: %s", p.Name, err.Error()))
+		return err
+	}
+	ctx = withRun(ctx, &run{masker: p.masker, logDir: logDir, runID: p.RunID, artifacts: newArtifactStore(artifactDir), caches: p.caches, env: env, declaredEnv: p.Env.declared(), coordinator: p.coordinator})
+	for _, m := range p.masker.secrets() {
+		p.provider.AddMask(m)
+	}
+	defer p.report(variables)
+	defer p.evictCaches(ctx)
+
+	p.emit(Event{Type: EventPipelineStarted})
+	startTime := time.Now()
+	if p.PullConcurrency > 0 && p.coordinator == nil {
+		p.prePull(ctx, du, variables)
 	}
 
+	err = p.runJobs(ctx, du, variables)
+	p.DisplaySummary()
+	p.notify(ctx, time.Since(startTime), err)
+	p.recordHistory(startTime, time.Since(startTime), err)
+
+	if err != nil {
+		p.emit(Event{Type: EventPipelineFinished, Result: "FAIL", Duration: time.Since(startTime), Error: err.Error()})
+		return err
+	}
+
+	p.emit(Event{Type: EventPipelineFinished, Result: "PASS", Duration: time.Since(startTime)})
 	return nil
 }
+
+// runs the stages of the pipeline in ctx, recording the outcome of each job
+func (p *AnypipeImpl) runJobs(ctx context.Context, du dockerutils.DockerUtils, variables map[string]interface{}) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	p.Results = []JobResult{}
+	p.JobOutputs = map[string]map[string]interface{}{}
+	p.firstFailure = ""
+	// the outputs are only published for the jobs, they remain available in JobOutputs
+	defer delete(variables, JobOutputsVariable)
+
+	sched := newScheduler(p.MaxConcurrency, p.Budget, p.Semaphores)
+	errs := []error{}
+	for _, stage := range p.Stages {
+		errs = append(errs, p.runStage(ctx, cancel, du, sched, stage, variables))
+	}
+
+	return errors.Join(errs...)
+}
+
+// runs the jobs of a stage concurrently, and displays their summaries in order once all of them finished
+func (p *AnypipeImpl) runStage(ctx context.Context, cancel context.CancelCauseFunc, du dockerutils.DockerUtils, sched *scheduler, stage []Job, variables map[string]interface{}) error {
+	if len(stage) == 1 {
+		stage[0].WithCIProvider(p.provider).WithEventHandler(p.emit)
+		err := p.runJob(ctx, cancel, du, sched, stage[0], variables)
+		p.publishOutputs(variables)
+		if !p.nested {
+			stage[0].DisplaySummary()
+		}
+		return err
+	}
+
+	errs := make([]error, len(stage))
+	jobVariables := make([]map[string]interface{}, len(stage))
+	wg := sync.WaitGroup{}
+	for i, job := range stage {
+		job.WithCIProvider(p.provider).WithEventHandler(p.emit)
+		jobVariables[i] = maps.Clone(variables)
+
+		wg.Add(1)
+		go func(i int, job Job) {
+			defer wg.Done()
+			errs[i] = p.runJob(ctx, cancel, du, sched, job, jobVariables[i])
+		}(i, job)
+	}
+	wg.Wait()
+
+	for i, job := range stage {
+		maps.Copy(variables, jobVariables[i])
+		if !p.nested {
+			job.DisplaySummary()
+		}
+	}
+	p.publishOutputs(variables)
+
+	return errors.Join(errs...)
+}
+
+// runs the job once the scheduler admits it, unless the failure policy rules it out by then.
+// returns an error only if the job failed
+func (p *AnypipeImpl) runJob(ctx context.Context, cancel context.CancelCauseFunc, du dockerutils.DockerUtils, sched *scheduler, job Job, variables map[string]interface{}) error {
+	if reason := p.notRunReason(job); len(reason) > 0 {
+		p.notRun(job, reason)
+		return nil
+	}
+
+	err := sched.acquire(ctx, job.GetName(), job.GetResources(), job.GetSemaphores())
+	if err != nil && ctx.Err() != nil {
+		p.notRun(job, fmt.Sprintf("cancelled before it started: %s", context.Cause(ctx)))
+		return nil
+	}
+	if err != nil {
+		p.log.Error(fmt.Sprintf("failed to schedule job %s: %s", job.GetName(), err.Error()))
+		p.recordResult(JobResult{Job: job.GetName(), Result: ResultFail, Reason: err.Error()})
+		return err
+	}
+	defer sched.release(job.GetResources(), job.GetSemaphores())
+
+	// checked again, something may have failed while the job was waiting
+	if reason := p.notRunReason(job); len(reason) > 0 {
+		p.notRun(job, reason)
+		return nil
+	}
+
+	before := maps.Clone(variables)
+	if j, ok := job.(*JobImpl); ok && runFrom(ctx).coordinator != nil {
+		err = p.runRemote(ctx, j, variables)
+	} else {
+		err = job.Run(ctx, p.log, du, variables)
+	}
+	p.recordOutputs(job.GetName(), jobOutputs(before, variables))
+	r := JobResult{Job: job.GetName()}
+	if g, ok := job.(*ApprovalGate); ok && g.Decision != nil {
+		r.Approval = g.Decision
+		r.Reason = describeDecision(*g.Decision)
+	}
+	switch {
+	case err == nil:
+		r.Result = ResultPass
+		p.recordResult(r)
+	case errors.Is(err, ErrCancelled):
+		r.Result, r.Reason = ResultCancelled, err.Error()
+		p.recordResult(r)
+	default:
+		r.Result, r.Reason = ResultFail, err.Error()
+		p.recordResult(r)
+		if p.FailurePolicy == FailFast {
+			cancel(fmt.Errorf("job %s failed (%s)", job.GetName(), FailFast))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// records that the job did not run, and why
+func (p *AnypipeImpl) notRun(job Job, reason string) {
+	p.log.Info(fmt.Sprintf("not running job %s: %s", job.GetName(), reason))
+	p.recordResult(JobResult{Job: job.GetName(), Result: ResultNotRun, Reason: reason})
+	p.emit(Event{Type: EventJobFinished, Job: job.GetName(), Result: "SKIP", Error: reason})
+}
+
+// writes the JUnit report and the selected final variables so later CI steps can use them
+func (p *AnypipeImpl) report(variables map[string]interface{}) {
+	if err := p.provider.WriteJUnit(junitSuites(p.Jobs)); err != nil {
+		p.log.Error(fmt.Sprintf("failed to write junit report: %s", err.Error()))
+	}
+
+	err := p.provider.Export(selectVariables(variables, p.Outputs), selectVariables(variables, p.ExportedEnv))
+	if err != nil {
+		p.log.Error(fmt.Sprintf("failed to export variables: %s", err.Error()))
+	}
+
+	if len(p.MetricsTextfile) > 0 {
+		if err := p.metrics.WriteTextfile(p.MetricsTextfile); err != nil {
+			p.log.Error(fmt.Sprintf("failed to write metrics to %s: %s", p.MetricsTextfile, err.Error()))
+		}
+	}
+}
+
+// applies the cache eviction policy, if any
+func (p *AnypipeImpl) evictCaches(ctx context.Context) {
+	if p.caches == nil || p.CacheEviction == (cache.Policy{}) {
+		return
+	}
+
+	removed, err := cache.Evict(ctx, p.caches, p.CacheEviction, time.Now())
+	if err != nil {
+		p.log.Error(fmt.Sprintf("failed to evict cache entries: %s", err.Error()))
+	}
+	for _, e := range removed {
+		p.log.Info(fmt.Sprintf("evicted cache entry %s (%s)", e.Key, formatBytes(e.Size)))
+	}
+}
+
+// returns the string representation of the selected variables that are set
+func selectVariables(variables map[string]interface{}, names []string) map[string]string {
+	selected := map[string]string{}
+	for _, name := range names {
+		if val, ok := variables[name]; ok {
+			selected[name] = fmt.Sprint(val)
+		}
+	}
+
+	return selected
+}
+
+// converts the step metrics of each job that ran into JUnit test suites
+func junitSuites(jobs []Job) []ci.TestSuite {
+	suites := []ci.TestSuite{}
+	for _, job := range jobs {
+		metrics := job.GetMetrics()
+		if len(metrics) == 0 {
+			continue
+		}
+
+		suite := ci.TestSuite{Name: job.GetName()}
+		var total time.Duration
+		for _, m := range metrics {
+			tc := ci.TestCase{
+				Name:      m.StepName,
+				ClassName: job.GetName(),
+				Time:      ci.JUnitTime(m.Duration),
+			}
+			// the effective env of the step, secrets are masked already
+			if len(m.Env) > 0 {
+				tc.Properties = &ci.Properties{}
+				for _, kv := range envPairs(m.Env) {
+					k, v, _ := strings.Cut(kv, "=")
+					tc.Properties.Properties = append(tc.Properties.Properties, ci.Property{Name: "env." + k, Value: v})
+				}
+			}
+
+			switch resultOf(m) {
+			case "SKIP":
+				tc.Skipped = &ci.Skipped{Message: strings.TrimPrefix(m.Result.Error(), "SKIPPED: ")}
+				suite.Skipped++
+			case "FAIL":
+				tc.Failure = &ci.Failure{Message: m.Result.Error(), Body: m.Result.Error()}
+				var panicErr *PanicError
+				if errors.As(m.Result, &panicErr) {
+					tc.Failure.Body = fmt.Sprintf("%s\n\n%s", m.Result.Error(), panicErr.Stack)
+				}
+				suite.Failures++
+			}
+
+			total += m.Duration
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+		suite.Time = ci.JUnitTime(total)
+		suites = append(suites, suite)
+	}
+
+	return suites
+}
@@ -0,0 +1,66 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPipelineReports(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	dir := t.TempDir()
+	env := map[string]string{
+		"GITLAB_CI":             "true",
+		"ANYPIPE_JUNIT_REPORT":  filepath.Join(dir, "junit.xml"),
+		"ANYPIPE_DOTENV_REPORT": filepath.Join(dir, "build.env"),
+	}
+
+	pass := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		variables["VERSION"] = "1.2.3"
+		return nil
+	}
+	fail := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return errors.New("some error")
+	}
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "test pipeline").
+		WithSequentialJobs(
+			NewJobImpl("job1", "testimage:latest").
+				WithStep("step1", pass).
+				WithStep("step2", fail).
+				WithStep("step3", pass),
+			NewJobImpl("job2", "testimage:latest").
+				WithStep("step1", pass),
+		).
+		WithCIProvider(ci.Detect(env, io.Discard)).
+		WithOutputs("VERSION", "MISSING")
+
+	err := pipeline.(*AnypipeImpl).run(du, map[string]interface{}{})
+	assert.Error(t, err)
+
+	res, err := os.ReadFile(env["ANYPIPE_JUNIT_REPORT"])
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), `<testsuite name="job1" tests="3" failures="1" skipped="1"`)
+	assert.Contains(t, string(res), `<failure message="some error">some error</failure>`)
+	assert.NotContains(t, string(res), "job2")
+
+	res, err = os.ReadFile(env["ANYPIPE_DOTENV_REPORT"])
+	assert.NoError(t, err)
+	assert.Equal(t, "VERSION=1.2.3\n", string(res))
+}
@@ -0,0 +1,125 @@
+package anypipe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+)
+
+// FailurePolicy decides what still runs after a failure
+type FailurePolicy string
+
+const (
+	// cancel everything still running, and start nothing new
+	FailFast FailurePolicy = "fail-fast"
+	// let running jobs finish, but start nothing new. this is the default
+	FinishRunning FailurePolicy = "finish-running"
+	// run everything that does not depend on what failed
+	RunAll FailurePolicy = "run-all"
+)
+
+// ErrCancelled is returned by a job that stopped because the pipeline was cancelled
+var ErrCancelled = errors.New("cancelled")
+
+const (
+	ResultPass      = "PASS"
+	ResultFail      = "FAIL"
+	ResultCancelled = "CANCELLED"
+	ResultNotRun    = "NOT RUN"
+)
+
+// JobResult is the outcome of a job of the pipeline
+type JobResult struct {
+	Job string
+	// one of ResultPass, ResultFail, ResultCancelled or ResultNotRun
+	Result string
+	// why the job failed, was cancelled or did not run, or who approved an approval gate
+	Reason string
+	// the decision made on an approval gate
+	Approval *approval.Decision
+}
+
+// records the outcome of a job, the first failure decides what runs next
+func (p *AnypipeImpl) recordResult(r JobResult) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	p.Results = append(p.Results, r)
+	if r.Result == ResultFail && len(p.firstFailure) == 0 {
+		p.firstFailure = r.Job
+	}
+}
+
+// returns the result recorded for the job, if any
+func (p *AnypipeImpl) resultOf(job string) (JobResult, bool) {
+	p.resultsMu.Lock()
+	defer p.resultsMu.Unlock()
+
+	for _, r := range p.Results {
+		if r.Job == job {
+			return r, true
+		}
+	}
+
+	return JobResult{}, false
+}
+
+// returns why the job must not start, or an empty string if it can
+func (p *AnypipeImpl) notRunReason(job Job) string {
+	p.resultsMu.Lock()
+	firstFailure := p.firstFailure
+	p.resultsMu.Unlock()
+
+	if len(firstFailure) > 0 && p.FailurePolicy != RunAll {
+		return fmt.Sprintf("job %s failed (%s)", firstFailure, p.FailurePolicy)
+	}
+
+	// with run-all, only jobs that need an artifact of a job that did not pass are left out
+	for _, input := range job.GetArtifactInputs() {
+		for _, producer := range p.Jobs {
+			if !contains(producer.GetArtifactOutputs(), input) {
+				continue
+			}
+
+			r, ok := p.resultOf(producer.GetName())
+			if ok && r.Result != ResultPass {
+				return fmt.Sprintf("needs artifact %s of job %s, which did not pass (%s)", input, producer.GetName(), r.Result)
+			}
+		}
+	}
+
+	return ""
+}
+
+// lists the outcome of every job, with the reason for those that did not pass
+func (p *AnypipeImpl) DisplaySummary() {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.SetTitle(p.Name)
+	t.AppendHeader(table.Row{"Result", "Job", "Reason"})
+
+	for _, r := range p.Results {
+		t.AppendRow(table.Row{r.Result, r.Job, r.Reason})
+	}
+	t.Render()
+
+	var pulls table.Writer
+	if len(p.Pulls) > 0 {
+		pulls = p.pullsTable()
+		pulls.SetOutputMirror(os.Stdout)
+		pulls.Render()
+	}
+
+	_ = p.provider.WriteSummary(func(w io.Writer) {
+		t.SetOutputMirror(w)
+		t.RenderMarkdown()
+		if pulls != nil {
+			pulls.SetOutputMirror(w)
+			pulls.RenderMarkdown()
+		}
+	})
+}
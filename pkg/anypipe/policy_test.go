@@ -0,0 +1,183 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func failingStep(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	return errors.New("failed")
+}
+
+func passingStep(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	return nil
+}
+
+func slowStep(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
+
+func slowFailingStep(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	time.Sleep(30 * time.Millisecond)
+	return errors.New("failed")
+}
+
+func testPipeline() *AnypipeImpl {
+	return NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard)).(*AnypipeImpl)
+}
+
+func TestFinishRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(2).Return(&dockerutils.Container{}, nil)
+
+	pipeline := testPipeline()
+	pipeline.WithParallelJobs(
+		NewJobImpl("a", "testimage:latest").WithStep("fail", slowFailingStep),
+		NewJobImpl("b", "testimage:latest").WithStep("slow", slowStep),
+	).WithSequentialJobs(
+		NewJobImpl("c", "testimage:latest").WithStep("step", passingStep),
+	)
+
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.ElementsMatch(t, []JobResult{
+		{Job: "a", Result: ResultFail, Reason: "job failed"},
+		{Job: "b", Result: ResultPass},
+		{Job: "c", Result: ResultNotRun, Reason: "job a failed (finish-running)"},
+	}, pipeline.Results)
+}
+
+func TestFinishRunningStartsNothingNew(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(&dockerutils.Container{}, nil)
+
+	// both jobs need the slot, b asks for it last and waits for a to release it
+	pipeline := testPipeline()
+	pipeline.WithParallelJobs(
+		NewJobImpl("a", "testimage:latest").WithStep("fail", slowFailingStep).WithSemaphores("slot"),
+		delayedJob{Job: NewJobImpl("b", "testimage:latest").WithStep("step", passingStep).WithSemaphores("slot"), delay: 10 * time.Millisecond},
+	)
+
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.ElementsMatch(t, []JobResult{
+		{Job: "a", Result: ResultFail, Reason: "job failed"},
+		{Job: "b", Result: ResultNotRun, Reason: "job a failed (finish-running)"},
+	}, pipeline.Results)
+}
+
+// delayedJob asks for its resources only after a delay
+type delayedJob struct {
+	Job
+	delay time.Duration
+}
+
+func (d delayedJob) GetResources() Resources {
+	time.Sleep(d.delay)
+	return d.Job.GetResources()
+}
+
+func TestFailFast(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(2).Return(&dockerutils.Container{}, nil)
+
+	b := NewJobImpl("b", "testimage:latest").WithStep("slow", slowStep).WithStep("next", passingStep)
+
+	pipeline := testPipeline()
+	pipeline.WithParallelJobs(
+		NewJobImpl("a", "testimage:latest").WithStep("fail", slowFailingStep),
+		b,
+		delayedJob{Job: NewJobImpl("c", "testimage:latest").WithStep("step", passingStep), delay: 10 * time.Millisecond},
+	).WithSequentialJobs(
+		NewJobImpl("d", "testimage:latest").WithStep("step", passingStep),
+	).WithMaxConcurrency(2).WithFailurePolicy(FailFast)
+
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.ElementsMatch(t, []JobResult{
+		{Job: "a", Result: ResultFail, Reason: "job failed"},
+		{Job: "b", Result: ResultCancelled, Reason: "cancelled: job a failed (fail-fast)"},
+		{Job: "c", Result: ResultNotRun, Reason: "cancelled before it started: job a failed (fail-fast)"},
+		{Job: "d", Result: ResultNotRun, Reason: "job a failed (fail-fast)"},
+	}, pipeline.Results)
+
+	assert.Equal(t, "PASS", resultOf(b.GetMetrics()[0]))
+	assert.Equal(t, "SKIP", resultOf(b.GetMetrics()[1]))
+	assert.EqualError(t, b.GetMetrics()[1].Result, "SKIPPED: cancelled: job a failed (fail-fast)")
+}
+
+func TestRunAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(3).Return(&dockerutils.Container{}, nil)
+	// the failed build has nothing to collect
+	du.EXPECT().Exec(gomock.Any(), gomock.Any()).Times(1).Return("", "", 0, nil)
+
+	pipeline := testPipeline()
+	pipeline.WithSequentialJobs(
+		NewJobImpl("build", "testimage:latest").WithStep("build", failingStep).WithArtifacts("bin", "bin/*"),
+		NewJobImpl("lint", "testimage:latest").WithStep("lint", passingStep),
+		NewJobImpl("deploy", "testimage:latest").WithStep("deploy", passingStep).WithArtifactInput("bin", "/bin"),
+		NewJobImpl("docs", "testimage:latest").WithStep("docs", passingStep),
+	).WithFailurePolicy(RunAll)
+
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.Equal(t, []JobResult{
+		{Job: "build", Result: ResultFail, Reason: "job failed"},
+		{Job: "lint", Result: ResultPass},
+		{Job: "deploy", Result: ResultNotRun, Reason: "needs artifact bin of job build, which did not pass (FAIL)"},
+		{Job: "docs", Result: ResultPass},
+	}, pipeline.Results)
+}
+
+func TestJobRunAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(2).Return(&dockerutils.Container{}, nil)
+
+	type testcase struct {
+		policy          FailurePolicy
+		expectedResults []string
+	}
+
+	testcases := []testcase{
+		{policy: FinishRunning, expectedResults: []string{"FAIL", "SKIP"}},
+		{policy: RunAll, expectedResults: []string{"FAIL", "PASS"}},
+	}
+
+	for _, tc := range testcases {
+		job := NewJobImpl("job", "testimage:latest").
+			WithStep("fail", failingStep).
+			WithStep("next", passingStep).
+			WithFailurePolicy(tc.policy)
+
+		assert.EqualError(t, job.Run(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), du, map[string]interface{}{}), "job failed")
+
+		results := []string{}
+		for _, m := range job.GetMetrics() {
+			results = append(results, resultOf(m))
+		}
+		assert.Equal(t, tc.expectedResults, results)
+	}
+}
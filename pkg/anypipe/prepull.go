@@ -0,0 +1,121 @@
+package anypipe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// ImagePull is the outcome of pulling an image before the jobs run
+type ImagePull struct {
+	Image string
+	// jobs that run in the image
+	Jobs     []string
+	Duration time.Duration
+	Error    error
+}
+
+// pulls the images of all jobs, nested pipelines included, before the first job starts, at most concurrency at
+// a time. each image is pulled once, however many jobs use it. images whose ref references job outputs are only
+// known once those jobs ran, they're pulled by their job
+func (p *AnypipeImpl) WithImagePrePull(concurrency int) Anypipe {
+	p.PullConcurrency = concurrency
+
+	return p
+}
+
+// the images of the jobs with their references interpolated, in the order the jobs are declared
+func (p *AnypipeImpl) images(variables map[string]interface{}) []ImagePull {
+	pulls := []ImagePull{}
+	index := map[string]int{}
+	add := func(image, job string) {
+		if i, ok := index[image]; ok {
+			pulls[i].Jobs = append(pulls[i].Jobs, job)
+			return
+		}
+		index[image] = len(pulls)
+		pulls = append(pulls, ImagePull{Image: image, Jobs: []string{job}})
+	}
+
+	for _, job := range p.Jobs {
+		switch j := job.(type) {
+		case *JobImpl:
+			image, err := Interpolate(j.ImageRef, variables)
+			if err != nil || len(image) == 0 {
+				continue
+			}
+			add(image, j.Name)
+		case *PipelineJob:
+			for _, pull := range j.pipeline.images(j.scopeVariables(variables)) {
+				for _, name := range pull.Jobs {
+					add(pull.Image, fmt.Sprintf("%s / %s", j.GetName(), name))
+				}
+			}
+		}
+	}
+
+	return pulls
+}
+
+// pulls the images of the jobs concurrently. failures are logged and left to the jobs, which pull their image
+// again and fail like they would without pre-pulling
+func (p *AnypipeImpl) prePull(ctx context.Context, du dockerutils.DockerUtils, variables map[string]interface{}) {
+	p.Pulls = p.images(variables)
+	if len(p.Pulls) == 0 {
+		return
+	}
+
+	ctx, span := startSpan(ctx, "pull images")
+	defer endSpan(ctx, span, nil)
+	du = traced(ctx, du)
+
+	p.log.Info(fmt.Sprintf("pulling %d images, %d at a time", len(p.Pulls), p.PullConcurrency))
+	sem := make(chan struct{}, p.PullConcurrency)
+	var wg sync.WaitGroup
+	for i := range p.Pulls {
+		wg.Add(1)
+		go func(pull *ImagePull) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				pull.Error = context.Cause(ctx)
+				return
+			}
+
+			startTime := time.Now()
+			pull.Error = du.PullImage(pull.Image)
+			pull.Duration = time.Since(startTime)
+			if pull.Error != nil {
+				p.log.Error(fmt.Sprintf("failed to pull image %s: %s", pull.Image, pull.Error.Error()))
+				return
+			}
+			p.log.Info(fmt.Sprintf("pulled image %s in %s", pull.Image, pull.Duration.Round(time.Millisecond)))
+		}(&p.Pulls[i])
+	}
+	wg.Wait()
+}
+
+// lists the images pulled before the jobs ran, with how long each took
+func (p *AnypipeImpl) pullsTable() table.Writer {
+	t := table.NewWriter()
+	t.SetTitle("images")
+	t.AppendHeader(table.Row{"Result", "Image", "Duration", "Jobs", "Error"})
+
+	for _, pull := range p.Pulls {
+		result := ResultPass
+		if pull.Error != nil {
+			result = ResultFail
+		}
+		t.AppendRow(table.Row{result, pull.Image, pull.Duration.Round(time.Millisecond), strings.Join(pull.Jobs, ", "), p.masker.mask(errorString(pull.Error))})
+	}
+
+	return t
+}
@@ -0,0 +1,131 @@
+package anypipe
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPrePull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().PullImage("golang:1.22").Times(1).Return(nil)
+	du.EXPECT().PullImage("alpine:3.20").Times(1).Return(nil)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(4).Return(&dockerutils.Container{}, nil)
+
+	inner := testPipeline()
+	inner.Name = "inner"
+	inner.WithSequentialJobs(NewJobImpl("lint", "golang:${{ vars.go_version }}").WithStep("step", passingStep))
+
+	pipeline := testPipeline()
+	pipeline.WithImagePrePull(2).
+		WithParallelJobs(
+			NewJobImpl("build", "golang:${{ vars.go_version }}").WithStep("step", passingStep),
+			NewJobImpl("test", "golang:1.22").WithStep("step", passingStep),
+			NewPipelineJob(inner, ""),
+		).
+		// only known once build ran, pulled by the job itself
+		WithSequentialJobs(NewJobImpl("deploy", "alpine:${{ jobs.build.outputs.alpine_version }}").WithStep("step", passingStep))
+
+	build := pipeline.Jobs[0].(*JobImpl)
+	build.Steps = []Step{NewStepImpl("step", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		variables["alpine_version"] = "3.20"
+		return nil
+	})}
+	// deploy's image is pulled when it creates its container, as without pre-pulling
+	assert.NoError(t, pipeline.run(&pullingDockerUtils{DockerUtils: du}, map[string]interface{}{"go_version": "1.22"}))
+
+	assert.Len(t, pipeline.Pulls, 1)
+	assert.Equal(t, "golang:1.22", pipeline.Pulls[0].Image)
+	assert.Equal(t, []string{"build", "test", "inner / lint"}, pipeline.Pulls[0].Jobs)
+	assert.NoError(t, pipeline.Pulls[0].Error)
+}
+
+// pullingDockerUtils pulls the image before creating a container, like DockerUtilsImpl
+type pullingDockerUtils struct {
+	dockerutils.DockerUtils
+	mu     sync.Mutex
+	pulled map[string]bool
+}
+
+func (p *pullingDockerUtils) PullImage(image string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pulled == nil {
+		p.pulled = map[string]bool{}
+	}
+	if p.pulled[image] {
+		return nil
+	}
+	p.pulled[image] = true
+
+	return p.DockerUtils.PullImage(image)
+}
+
+func (p *pullingDockerUtils) CreateContainerWithOptions(image string, opts dockerutils.ContainerOptions) (*dockerutils.Container, error) {
+	if err := p.PullImage(image); err != nil {
+		return nil, err
+	}
+
+	return p.DockerUtils.CreateContainerWithOptions(image, opts)
+}
+
+func TestPrePullConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var running, maxRunning atomic.Int32
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().PullImage(gomock.Any()).Times(5).DoAndReturn(func(image string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+
+	pipeline := testPipeline()
+	pipeline.WithImagePrePull(2)
+	for _, image := range []string{"a", "b", "c", "d", "e"} {
+		pipeline.WithParallelJobs(NewJobImpl(image, image).WithStep("step", passingStep))
+	}
+
+	pipeline.prePull(pipeline.ctx, du, map[string]interface{}{})
+	assert.Equal(t, int32(2), maxRunning.Load())
+	assert.Len(t, pipeline.Pulls, 5)
+	for _, pull := range pipeline.Pulls {
+		assert.GreaterOrEqual(t, pull.Duration, 20*time.Millisecond)
+	}
+}
+
+func TestPrePullFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().PullImage("missing:latest").Times(1).Return(errors.New("manifest unknown"))
+	du.EXPECT().CreateContainerWithOptions("missing:latest", gomock.Any()).Times(1).Return(nil, errors.New("manifest unknown"))
+
+	pipeline := testPipeline()
+	pipeline.WithImagePrePull(4).
+		WithSequentialJobs(NewJobImpl("build", "missing:latest").WithStep("step", passingStep))
+
+	// the job fails when it pulls the image itself, like it would without pre-pulling
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.Equal(t, []JobResult{{Job: "build", Result: ResultFail, Reason: "manifest unknown"}}, pipeline.Results)
+	assert.EqualError(t, pipeline.Pulls[0].Error, "manifest unknown")
+}
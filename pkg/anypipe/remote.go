@@ -0,0 +1,480 @@
+package anypipe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+	"github.com/notmiguelalves/anypipe/pkg/utils"
+)
+
+// runs the jobs of the pipeline, nested pipelines included, on the agents of the coordinator instead of the
+// local Docker daemon. the coordinator must be served where the agents can reach it. approval gates still run
+// here, and the pipeline no longer connects to Docker itself.
+//
+// steps are Go functions, so an agent is the same pipeline program started with RunAgent, it runs the jobs it
+// is assigned by name. variables travel as JSON, e.g. numbers come back as float64
+func (p *AnypipeImpl) WithCoordinator(c *remote.Coordinator) Anypipe {
+	p.coordinator = c
+
+	return p
+}
+
+// runs the jobs the agent is assigned by the coordinator, until the pipeline's context is done
+func (p *AnypipeImpl) RunAgent(a *remote.Agent) error {
+	du, err := dockerutils.New(p.ctx, p.log)
+	if err != nil {
+		return err
+	}
+	defer du.Close()
+
+	if p.metrics != nil {
+		du.WithMetrics(p.metrics)
+	}
+
+	return p.runAgent(p.ctx, du, a)
+}
+
+func (p *AnypipeImpl) runAgent(ctx context.Context, du dockerutils.DockerUtils, a *remote.Agent) error {
+	err := a.Run(ctx, func(ctx context.Context, as remote.Assignment, emit func(e json.RawMessage)) remote.Result {
+		return p.runAssignment(ctx, du, as, emit)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
+
+// remoteStepMetrics are StepMetrics as sent by agents, errors don't survive JSON
+type remoteStepMetrics struct {
+	StepMetrics
+	Result string
+}
+
+// remoteJobError is the error of a job that ran on an agent
+type remoteJobError struct {
+	msg       string
+	cancelled bool
+}
+
+func (e *remoteJobError) Error() string {
+	return e.msg
+}
+
+func (e *remoteJobError) Is(target error) bool {
+	return e.cancelled && target == ErrCancelled
+}
+
+// runs the job on an agent of the coordinator, with its events, metrics, artifacts and outputs relayed back
+func (p *AnypipeImpl) runRemote(ctx context.Context, j *JobImpl, variables map[string]interface{}) error {
+	r := runFrom(ctx)
+	j.masker = r.masker
+	j.collectSecrets(variables)
+	j.Metrics, j.Artifacts = nil, nil
+	startTime := time.Now()
+
+	fail := func(err error) error {
+		err = j.masker.maskError(err)
+		p.log.Error(fmt.Sprintf("failed to run job %s on an agent: %s", j.Name, err.Error()))
+		j.emit(Event{Type: EventJobFinished, Result: "FAIL", Duration: time.Since(startTime), Error: err.Error()})
+		return err
+	}
+
+	as := remote.Assignment{
+		RunID:       r.runID,
+		Pipeline:    p.Name,
+		Job:         j.Name,
+		Env:         r.env,
+		DeclaredEnv: r.declaredEnv,
+		Masked:      r.masker.secrets(),
+		Artifacts:   map[string][]byte{},
+	}
+	var err error
+	as.Vars, err = encodeVariables(variables)
+	if err != nil {
+		return fail(err)
+	}
+	for _, name := range sortedKeys(j.ArtifactInputs) {
+		a, ok := r.artifacts.get(name)
+		if !ok {
+			return fail(fmt.Errorf("artifact %s is not available, it must be produced by an earlier job", name))
+		}
+		buf, err := utils.Tar(a.Dir)
+		if err != nil {
+			return fail(fmt.Errorf("failed to archive artifact %s: %w", name, err))
+		}
+		as.Artifacts[name] = buf.Bytes()
+	}
+
+	p.log.Info(fmt.Sprintf("dispatching job %s to an agent", j.Name))
+	result, err := r.coordinator.Dispatch(ctx, as, func(raw json.RawMessage) {
+		var msg remoteMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			p.log.Warn(fmt.Sprintf("dropped a message of job %s: %s", j.Name, err.Error()))
+			return
+		}
+		switch {
+		case msg.Event != nil:
+			j.emit(*msg.Event)
+		case msg.Log != nil:
+			p.logRemote(ctx, j, *msg.Log)
+		}
+	})
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %s", ErrCancelled, context.Cause(ctx))
+		j.emit(Event{Type: EventJobFinished, Result: ResultCancelled, Duration: time.Since(startTime), Error: err.Error()})
+		return err
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := p.collectResult(r, j, result, variables); err != nil {
+		return fail(err)
+	}
+
+	switch {
+	case result.Cancelled && ctx.Err() != nil:
+		// the agent only knows the job was cancelled, not why
+		return fmt.Errorf("%w: %s", ErrCancelled, context.Cause(ctx))
+	case len(result.Error) > 0:
+		return &remoteJobError{msg: j.masker.mask(result.Error), cancelled: result.Cancelled}
+	}
+
+	return nil
+}
+
+// records the metrics, artifacts and outputs the agent reported for the job
+func (p *AnypipeImpl) collectResult(r *run, j *JobImpl, result remote.Result, variables map[string]interface{}) error {
+	if len(result.Metrics) > 0 {
+		metrics := []remoteStepMetrics{}
+		if err := json.Unmarshal(result.Metrics, &metrics); err != nil {
+			return fmt.Errorf("failed to decode the metrics of job %s: %w", j.Name, err)
+		}
+		for _, m := range metrics {
+			if len(m.Result) > 0 {
+				m.StepMetrics.Result = errors.New(m.Result)
+			}
+			j.Metrics = append(j.Metrics, m.StepMetrics)
+		}
+	}
+
+	for _, name := range sortedKeys(result.Artifacts) {
+		a := Artifact{Name: name, Job: j.Name, Dir: filepath.Join(r.artifacts.dir, safeFileName(name))}
+		if err := os.RemoveAll(a.Dir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(a.Dir, 0755); err != nil {
+			return err
+		}
+		if err := utils.Untar(io.NopCloser(bytes.NewReader(result.Artifacts[name])), a.Dir); err != nil {
+			return fmt.Errorf("failed to extract artifact %s: %w", name, err)
+		}
+
+		var err error
+		a.Files, err = artifactFiles(a.Dir)
+		if err != nil {
+			return err
+		}
+		if err := r.artifacts.put(a); err != nil {
+			return err
+		}
+		j.Artifacts = append(j.Artifacts, a)
+	}
+
+	outputs, err := decodeVariables(result.Outputs)
+	if err != nil {
+		return fmt.Errorf("failed to decode the outputs of job %s: %w", j.Name, err)
+	}
+	maps.Copy(variables, outputs)
+	j.collectSecrets(variables)
+
+	return nil
+}
+
+// logs a record the agent streamed back as if the job ran here
+func (p *AnypipeImpl) logRemote(ctx context.Context, j *JobImpl, rec remoteLogRecord) {
+	h := p.log.Handler()
+	if !h.Enabled(ctx, rec.Level) {
+		return
+	}
+
+	r := slog.NewRecord(rec.Time, rec.Level, j.masker.mask(rec.Message), 0)
+	for _, a := range rec.Attrs {
+		r.AddAttrs(slog.String(a.Key, j.masker.mask(a.Value)))
+	}
+	_ = h.Handle(ctx, r)
+}
+
+// runs a job of the pipeline assigned by the coordinator, on a copy so assignments of the same job can run at once
+func (p *AnypipeImpl) runAssignment(ctx context.Context, du dockerutils.DockerUtils, as remote.Assignment, emit func(e json.RawMessage)) remote.Result {
+	job, logPath, ok := p.findJob(as.Pipeline, as.Job)
+	if !ok {
+		return remote.Result{Error: fmt.Sprintf("pipeline %s has no job %s, the agent must run the same pipeline", as.Pipeline, as.Job)}
+	}
+	send := func(msg remoteMessage) {
+		raw, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+		emit(raw)
+	}
+	// the records are still logged here, and streamed to the coordinator along with the events
+	log := slog.New(&remoteLogHandler{Handler: p.log.Handler(), send: send})
+
+	j := job.clone()
+	j.WithCIProvider(p.provider).WithEventHandler(func(e Event) {
+		send(remoteMessage{Event: &e})
+	})
+
+	variables, err := decodeVariables(as.Vars)
+	if err != nil {
+		return remote.Result{Error: fmt.Sprintf("failed to decode the variables of job %s: %s", as.Job, err.Error())}
+	}
+
+	dir, err := os.MkdirTemp("", "anypipe-agent-")
+	if err != nil {
+		return remote.Result{Error: err.Error()}
+	}
+	defer os.RemoveAll(dir)
+
+	// inputs are kept apart from the artifacts the job produces
+	artifacts := newArtifactStore(filepath.Join(dir, "outputs"))
+	for _, name := range sortedKeys(as.Artifacts) {
+		a := Artifact{Name: name, Dir: filepath.Join(dir, "inputs", safeFileName(name))}
+		err := os.MkdirAll(a.Dir, 0755)
+		if err == nil {
+			err = utils.Untar(io.NopCloser(bytes.NewReader(as.Artifacts[name])), a.Dir)
+		}
+		if err == nil {
+			err = artifacts.put(a)
+		}
+		if err != nil {
+			return remote.Result{Error: fmt.Sprintf("failed to extract artifact %s: %s", name, err.Error())}
+		}
+	}
+
+	// laid out like the logs of a local run
+	logDir := p.LogDir
+	if len(logDir) > 0 {
+		logDir = filepath.Join(logDir, safeFileName(as.RunID), logPath)
+	}
+	m := newMasker(as.Masked...)
+	ctx = withRun(ctx, &run{masker: m, logDir: logDir, runID: as.RunID, artifacts: artifacts, caches: p.caches, env: as.Env, declaredEnv: as.DeclaredEnv})
+	before := maps.Clone(variables)
+	err = j.Run(ctx, log, du, variables)
+
+	result := remote.Result{Error: errorString(err), Cancelled: errors.Is(err, ErrCancelled)}
+	problems := []error{}
+	result.Outputs, err = encodeVariables(jobOutputs(before, variables))
+	problems = append(problems, err)
+
+	metrics := []remoteStepMetrics{}
+	for _, sm := range j.Metrics {
+		metrics = append(metrics, remoteStepMetrics{StepMetrics: sm, Result: errorString(sm.Result)})
+	}
+	result.Metrics, err = json.Marshal(metrics)
+	problems = append(problems, err)
+
+	result.Artifacts = map[string][]byte{}
+	for _, a := range j.Artifacts {
+		buf, err := utils.Tar(a.Dir)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("failed to archive artifact %s: %w", a.Name, err))
+			continue
+		}
+		result.Artifacts[a.Name] = buf.Bytes()
+	}
+
+	if err := errors.Join(problems...); err != nil {
+		log.Error(fmt.Sprintf("failed to report job %s: %s", as.Job, m.mask(err.Error())))
+		if len(result.Error) > 0 {
+			err = fmt.Errorf("%s: %w", result.Error, err)
+		}
+		result.Error = m.mask(err.Error())
+	}
+
+	return result
+}
+
+// returns a copy of the job sharing nothing a run changes, the caches and metrics it records in particular
+func (j *JobImpl) clone() *JobImpl {
+	c := *j
+	c.Steps = slices.Clone(j.Steps)
+	c.Metrics, c.Artifacts = nil, nil
+	c.SecretEnv = maps.Clone(j.SecretEnv)
+	c.SecretFiles = maps.Clone(j.SecretFiles)
+	c.ArtifactPaths = map[string][]string{}
+	for name, globs := range j.ArtifactPaths {
+		c.ArtifactPaths[name] = slices.Clone(globs)
+	}
+	c.ArtifactInputs = maps.Clone(j.ArtifactInputs)
+	c.Caches = slices.Clone(j.Caches)
+	for i := range c.Caches {
+		c.Caches[i].RestoreKeys = slices.Clone(j.Caches[i].RestoreKeys)
+	}
+	c.Semaphores = slices.Clone(j.Semaphores)
+	c.SetsVariables = slices.Clone(j.SetsVariables)
+	if j.Workspace != nil {
+		ws := *j.Workspace
+		c.Workspace = &ws
+	}
+	c.Env = Env{Files: slices.Clone(j.Env.Files), Host: slices.Clone(j.Env.Host), Vars: maps.Clone(j.Env.Vars)}
+
+	return &c
+}
+
+// remoteMessage is streamed back by agents while a job runs, either an event of the job or a log record
+type remoteMessage struct {
+	Event *Event           `json:",omitempty"`
+	Log   *remoteLogRecord `json:",omitempty"`
+}
+
+type remoteLogRecord struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   []remoteLogAttr `json:",omitempty"`
+}
+
+type remoteLogAttr struct {
+	Key   string
+	Value string
+}
+
+// remoteLogHandler streams log records to the coordinator before passing them on
+type remoteLogHandler struct {
+	slog.Handler
+	send  func(remoteMessage)
+	attrs []remoteLogAttr
+	group string
+}
+
+func (h *remoteLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	rec := remoteLogRecord{Time: r.Time, Level: r.Level, Message: r.Message, Attrs: slices.Clone(h.attrs)}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.Attrs = append(rec.Attrs, remoteLogAttr{Key: h.group + a.Key, Value: a.Value.Resolve().String()})
+		return true
+	})
+	h.send(remoteMessage{Log: &rec})
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *remoteLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	added := slices.Clone(h.attrs)
+	for _, a := range attrs {
+		added = append(added, remoteLogAttr{Key: h.group + a.Key, Value: a.Value.Resolve().String()})
+	}
+
+	return &remoteLogHandler{Handler: h.Handler.WithAttrs(attrs), send: h.send, attrs: added, group: h.group}
+}
+
+func (h *remoteLogHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+
+	return &remoteLogHandler{Handler: h.Handler.WithGroup(name), send: h.send, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// finds the job in the pipeline, or in the nested pipeline, with the given name. the returned path is the
+// directory of the nested pipelines the job belongs to, as its logs are written to
+func (p *AnypipeImpl) findJob(pipeline, name string) (*JobImpl, string, bool) {
+	for _, job := range p.Jobs {
+		switch j := job.(type) {
+		case *JobImpl:
+			if p.Name == pipeline && j.Name == name {
+				return j, "", true
+			}
+		case *PipelineJob:
+			// the nested pipeline doesn't run on the agent, its jobs get its settings here
+			j.applySettings()
+			if found, dir, ok := j.pipeline.findJob(pipeline, name); ok {
+				return found, filepath.Join(safeFileName(j.pipeline.Name), dir), true
+			}
+		}
+	}
+
+	return nil, "", false
+}
+
+// splits the variables into secrets and JSON encoded values, the outputs of earlier jobs are encoded the same way
+func encodeVariables(variables map[string]interface{}) (remote.Variables, error) {
+	vars := remote.Variables{Plain: map[string]json.RawMessage{}, Secrets: map[string]string{}}
+	for k, v := range variables {
+		switch s := v.(type) {
+		case Secret:
+			vars.Secrets[k] = s.Value()
+			continue
+		case *Secret:
+			vars.Secrets[k] = s.Value()
+			continue
+		case map[string]map[string]interface{}:
+			if k == JobOutputsVariable {
+				jobs := map[string]remote.Variables{}
+				for job, outputs := range s {
+					encoded, err := encodeVariables(outputs)
+					if err != nil {
+						return vars, err
+					}
+					jobs[job] = encoded
+				}
+				v = jobs
+			}
+		}
+
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return vars, fmt.Errorf("variable %s can't be sent to an agent: %w", k, err)
+		}
+		vars.Plain[k] = raw
+	}
+
+	return vars, nil
+}
+
+func decodeVariables(vars remote.Variables) (map[string]interface{}, error) {
+	variables := map[string]interface{}{}
+	for k, raw := range vars.Plain {
+		if k == JobOutputsVariable {
+			jobs := map[string]remote.Variables{}
+			if err := json.Unmarshal(raw, &jobs); err != nil {
+				return nil, fmt.Errorf("variable %s: %w", k, err)
+			}
+			outputs := map[string]map[string]interface{}{}
+			for job, encoded := range jobs {
+				decoded, err := decodeVariables(encoded)
+				if err != nil {
+					return nil, err
+				}
+				outputs[job] = decoded
+			}
+			variables[k] = outputs
+			continue
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("variable %s: %w", k, err)
+		}
+		variables[k] = v
+	}
+	for k, s := range vars.Secrets {
+		variables[k] = NewSecret(s)
+	}
+
+	return variables, nil
+}
@@ -0,0 +1,326 @@
+package anypipe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+// starts an agent running the pipeline's jobs with du, it stops at the end of the test
+func startAgent(t *testing.T, c *remote.Coordinator, p *AnypipeImpl, du dockerutils.DockerUtils) {
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+
+	a := remote.NewAgent(srv.URL, "", "agent", 1)
+	a.PollWait = 100 * time.Millisecond
+	a.FlushInterval = 10 * time.Millisecond
+	a.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, p.runAgent(ctx, du, a))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// syncBuffer is written to by the log handlers of concurrent jobs
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.b.String()
+}
+
+// the same pipeline is built by the coordinator and the agent, like a pipeline program started in both modes
+func remotePipeline(t *testing.T) *AnypipeImpl {
+	build := NewJobImpl("build", "builder:latest").
+		WithSecretEnv("TOKEN", "token").
+		WithArtifacts("bin", "dist/*").
+		WithStep("compile", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			if _, _, _, err := du.Exec(c, "make"); err != nil {
+				return err
+			}
+			variables["version"] = "1.2.3"
+			variables["deploy_key"] = NewSecret("k3y")
+			return nil
+		})
+
+	test := NewJobImpl("test", "runner:${{ jobs.build.outputs.version }}").
+		WithArtifactInput("bin", "/input").
+		WithStep("check", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+			assert.Equal(t, "1.2.3", variables["version"])
+			assert.Equal(t, float64(3), variables["retries"])
+			assert.Equal(t, NewSecret("hunter2"), variables["token"])
+			assert.Equal(t, NewSecret("k3y"), variables["deploy_key"])
+			variables["tested"] = true
+			return nil
+		})
+
+	return testPipeline().WithSequentialJobs(build, test).(*AnypipeImpl)
+}
+
+func TestRemoteJobs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	builder := &dockerutils.Container{}
+	runner := &dockerutils.Container{}
+	gomock.InOrder(
+		du.EXPECT().CreateContainerWithOptions("builder:latest", dockerutils.ContainerOptions{}).Times(1).Return(builder, nil),
+		du.EXPECT().Exec(builder, "make").Times(1).Return("built with hunter2", "", 0, nil),
+		du.EXPECT().Exec(builder, `for p in dist/*; do [ -e "$p" ] && echo "$p"; done; true`).Times(1).Return("dist/app\n", "", 0, nil),
+		du.EXPECT().CopyFrom(builder, "/home/dist/app", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			return os.WriteFile(filepath.Join(dst, "app"), []byte("binary"), 0755)
+		}),
+		du.EXPECT().CreateContainerWithOptions("runner:1.2.3", dockerutils.ContainerOptions{}).Times(1).Return(runner, nil),
+		du.EXPECT().Exec(runner, "mkdir -p '/input'").Times(1).Return("", "", 0, nil),
+		du.EXPECT().CopyTo(runner, gomock.Any(), "/input").Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			b, err := os.ReadFile(filepath.Join(src, "dist", "app"))
+			assert.NoError(t, err)
+			assert.Equal(t, "binary", string(b))
+			return nil
+		}),
+	)
+
+	c := remote.NewCoordinator("")
+	startAgent(t, c, remotePipeline(t), du)
+
+	var mu sync.Mutex
+	events := []Event{}
+	logs := &syncBuffer{}
+	pipeline := remotePipeline(t)
+	pipeline.log = slog.New(slog.NewTextHandler(logs, nil))
+	pipeline.WithCoordinator(c).WithEventHandler(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+
+	variables := map[string]interface{}{"token": NewSecret("hunter2"), "retries": 3}
+	assert.NoError(t, pipeline.Run(variables))
+
+	assert.Equal(t, []JobResult{{Job: "build", Result: ResultPass}, {Job: "test", Result: ResultPass}}, pipeline.Results)
+	assert.Equal(t, "1.2.3", variables["version"])
+	assert.Equal(t, true, variables["tested"])
+	assert.Equal(t, 3, variables["retries"])
+	assert.Equal(t, NewSecret("k3y"), variables["deploy_key"])
+
+	build := pipeline.Jobs[0].(*JobImpl)
+	assert.Len(t, build.Metrics, 1)
+	assert.Equal(t, "compile", build.Metrics[0].StepName)
+	assert.Equal(t, "PASS", resultOf(build.Metrics[0]))
+	assert.Len(t, build.Artifacts, 1)
+	assert.Equal(t, []ArtifactFile{
+		{Path: "dist/app", Size: 6, SHA256: "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd"},
+	}, build.Artifacts[0].Files)
+
+	// logs of the agent are relayed
+	assert.Contains(t, logs.String(), "starting job build")
+	assert.Contains(t, logs.String(), "starting job test")
+
+	// events of the agent are relayed, with secrets masked
+	mu.Lock()
+	defer mu.Unlock()
+	outputs := []Event{}
+	finished := []string{}
+	for _, e := range events {
+		assert.Equal(t, "test pipeline", e.Pipeline)
+		switch e.Type {
+		case EventOutput:
+			outputs = append(outputs, e)
+		case EventJobFinished:
+			finished = append(finished, e.Job+" "+e.Result)
+		}
+	}
+	assert.Len(t, outputs, 1)
+	assert.Equal(t, "built with ***", outputs[0].Stdout)
+	assert.Equal(t, "build", outputs[0].Job)
+	assert.Equal(t, "compile", outputs[0].Step)
+	assert.Equal(t, []string{"build PASS", "test PASS"}, finished)
+}
+
+func TestRemoteJobFailures(t *testing.T) {
+	type testcase struct {
+		name      string
+		job       Job
+		agentJobs []Job
+		expected  JobResult
+		metrics   []string
+	}
+
+	failing := func() Job {
+		return NewJobImpl("build", "builder:latest").
+			WithStep("compile", failingStep).
+			WithStep("package", passingStep)
+	}
+
+	testcases := []testcase{
+		{
+			name:      "failing step",
+			job:       failing(),
+			agentJobs: []Job{failing()},
+			expected:  JobResult{Job: "build", Result: ResultFail, Reason: "job failed"},
+			metrics:   []string{"FAIL", "SKIP"},
+		},
+		{
+			name:      "job unknown to the agent",
+			job:       failing(),
+			agentJobs: []Job{NewJobImpl("lint", "linter:latest").WithStep("lint", passingStep)},
+			expected:  JobResult{Job: "build", Result: ResultFail, Reason: "pipeline test pipeline has no job build, the agent must run the same pipeline"},
+			metrics:   []string{},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+			c := remote.NewCoordinator("")
+			startAgent(t, c, testPipeline().WithSequentialJobs(tc.agentJobs...).(*AnypipeImpl), du)
+
+			pipeline := testPipeline().WithCoordinator(c).WithSequentialJobs(tc.job).(*AnypipeImpl)
+			assert.Error(t, pipeline.Run(map[string]interface{}{}))
+			assert.Equal(t, []JobResult{tc.expected}, pipeline.Results)
+
+			metrics := []string{}
+			for _, m := range tc.job.GetMetrics() {
+				metrics = append(metrics, resultOf(m))
+			}
+			assert.Equal(t, tc.metrics, metrics)
+		})
+	}
+}
+
+func TestRemoteJobCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).AnyTimes().Return(&dockerutils.Container{}, nil)
+
+	jobs := func() []Job {
+		return []Job{
+			NewJobImpl("a", "testimage:latest").WithStep("fail", slowFailingStep),
+			NewJobImpl("b", "testimage:latest").WithStep("wait", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				time.Sleep(200 * time.Millisecond)
+				return errors.New("interrupted")
+			}),
+		}
+	}
+
+	c := remote.NewCoordinator("")
+	agentPipeline := testPipeline().WithParallelJobs(jobs()...).(*AnypipeImpl)
+	startAgent(t, c, agentPipeline, du)
+	// a second agent, so both jobs run at once
+	startAgent(t, c, agentPipeline, du)
+
+	pipeline := testPipeline().WithCoordinator(c).WithFailurePolicy(FailFast).WithParallelJobs(jobs()...).(*AnypipeImpl)
+	assert.Error(t, pipeline.Run(map[string]interface{}{}))
+	assert.ElementsMatch(t, []JobResult{
+		{Job: "a", Result: ResultFail, Reason: "job failed"},
+		{Job: "b", Result: ResultCancelled, Reason: "cancelled: job a failed (fail-fast)"},
+	}, pipeline.Results)
+}
+
+func TestCloneJob(t *testing.T) {
+	job := NewJobImpl("build", "golang:1.22").
+		WithCache("/go/pkg/mod", "go-mod", "go-").
+		WithArtifacts("bin", "dist/*").
+		WithSecretEnv("TOKEN", "token").
+		WithEnv("CGO_ENABLED", "0").
+		WithWorkspace(Workspace{HostDir: "/src"}).
+		WithSemaphores("deploy").
+		WithStep("compile", passingStep).(*JobImpl)
+
+	c := job.clone()
+	assert.Equal(t, job.Caches, c.Caches)
+	assert.Equal(t, job.Env, c.Env)
+
+	c.Caches[0].ResolvedKey = "go-mod"
+	c.Caches[0].RestoreKeys[0] = "changed"
+	c.ArtifactPaths["bin"][0] = "changed"
+	c.SecretEnv["OTHER"] = "other"
+	c.Env.Vars["CGO_ENABLED"] = "1"
+	c.Workspace.HostDir = "/other"
+	c.Semaphores[0] = "changed"
+	c.Steps[0] = nil
+	c.Metrics = append(c.Metrics, StepMetrics{StepName: "compile"})
+
+	assert.Equal(t, []Cache{{Path: "/go/pkg/mod", Key: "go-mod", RestoreKeys: []string{"go-"}}}, job.Caches)
+	assert.Equal(t, []string{"dist/*"}, job.ArtifactPaths["bin"])
+	assert.Equal(t, map[string]string{"TOKEN": "token"}, job.SecretEnv)
+	assert.Equal(t, "0", job.Env.Vars["CGO_ENABLED"])
+	assert.Equal(t, "/src", job.Workspace.HostDir)
+	assert.Equal(t, []string{"deploy"}, job.Semaphores)
+	assert.NotNil(t, job.Steps[0])
+	assert.Empty(t, job.Metrics)
+}
+
+func TestEncodeVariables(t *testing.T) {
+	variables := map[string]interface{}{
+		"name":    "anypipe",
+		"count":   2,
+		"enabled": true,
+		"token":   NewSecret("hunter2"),
+		"key":     &Secret{value: "k3y"},
+		JobOutputsVariable: map[string]map[string]interface{}{
+			"build": {"version": "1.2.3", "signing_key": NewSecret("s1gn")},
+		},
+	}
+
+	vars, err := encodeVariables(variables)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"token": "hunter2", "key": "k3y"}, vars.Secrets)
+	assert.NotContains(t, string(vars.Plain[JobOutputsVariable]), "***")
+
+	decoded, err := decodeVariables(vars)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "anypipe",
+		"count":   float64(2),
+		"enabled": true,
+		"token":   NewSecret("hunter2"),
+		"key":     NewSecret("k3y"),
+		JobOutputsVariable: map[string]map[string]interface{}{
+			"build": {"version": "1.2.3", "signing_key": NewSecret("s1gn")},
+		},
+	}, decoded)
+
+	_, err = encodeVariables(map[string]interface{}{"callback": func() {}})
+	assert.ErrorContains(t, err, "variable callback can't be sent to an agent")
+}
@@ -0,0 +1,48 @@
+package anypipe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/remote"
+)
+
+// run holds the state shared by all jobs of a pipeline run. It travels in the context passed to Job.Run
+type run struct {
+	masker *masker
+	// directory step logs are written to, empty if they are only kept in memory
+	logDir string
+	// ID of the pipeline run
+	runID string
+	// artifacts produced by the jobs of the run
+	artifacts *artifactStore
+	// store job caches are kept in, nil if caching is disabled
+	caches cache.Store
+	// env variables of the pipeline, set in every job's container
+	env map[string]string
+	// keys of the env of the pipeline and its parents, mapped to whether their value is recorded with the steps,
+	// see Env.declared
+	declaredEnv map[string]bool
+	// coordinator the jobs are dispatched to, nil if they run locally
+	coordinator *remote.Coordinator
+}
+
+type runKey struct{}
+
+func withRun(ctx context.Context, r *run) context.Context {
+	return context.WithValue(ctx, runKey{}, r)
+}
+
+// returns the run the context belongs to, or a new one when a job runs outside of a pipeline
+func runFrom(ctx context.Context) *run {
+	if r, ok := ctx.Value(runKey{}).(*run); ok {
+		return r
+	}
+
+	return &run{
+		masker:    newMasker(),
+		artifacts: newArtifactStore(filepath.Join(os.TempDir(), "anypipe-artifacts")),
+	}
+}
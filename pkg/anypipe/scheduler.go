@@ -0,0 +1,141 @@
+package anypipe
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Resources a job requests from the host. the job's container is limited to them
+type Resources struct {
+	// number of CPUs, fractions are allowed
+	CPUs float64
+	// memory in bytes
+	Memory int64
+}
+
+func (r Resources) add(o Resources) Resources {
+	return Resources{CPUs: r.CPUs + o.CPUs, Memory: r.Memory + o.Memory}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{CPUs: r.CPUs - o.CPUs, Memory: r.Memory - o.Memory}
+}
+
+// whether r fits in budget. zero budget values mean no limit
+func (r Resources) fits(budget Resources) bool {
+	return (budget.CPUs == 0 || r.CPUs <= budget.CPUs) && (budget.Memory == 0 || r.Memory <= budget.Memory)
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("%g CPUs, %s memory", r.CPUs, formatBytes(r.Memory))
+}
+
+// scheduler admits jobs once a slot, the resources they request and their semaphores are available
+type scheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// maximum number of jobs running at once, 0 for no limit
+	maxConcurrency int
+	running        int
+	budget         Resources
+	used           Resources
+	// capacity of named semaphores, semaphores not listed have a capacity of 1
+	capacity map[string]int
+	held     map[string]int
+}
+
+func newScheduler(maxConcurrency int, budget Resources, capacity map[string]int) *scheduler {
+	s := &scheduler{
+		maxConcurrency: maxConcurrency,
+		budget:         budget,
+		capacity:       capacity,
+		held:           map[string]int{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+func (s *scheduler) semaphoreCapacity(name string) int {
+	if c, ok := s.capacity[name]; ok {
+		return c
+	}
+
+	return 1
+}
+
+// whether the job can start now. must be called with s.mu held
+func (s *scheduler) admissible(req Resources, semaphores []string) bool {
+	if s.maxConcurrency > 0 && s.running >= s.maxConcurrency {
+		return false
+	}
+
+	if !s.used.add(req).fits(s.budget) {
+		return false
+	}
+
+	for _, name := range semaphores {
+		if s.held[name] >= s.semaphoreCapacity(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// blocks until the job can start, or ctx is done. jobs requesting more than the whole budget, or a semaphore
+// without capacity, are rejected since they could never start
+func (s *scheduler) acquire(ctx context.Context, job string, req Resources, semaphores []string) error {
+	if !req.fits(s.budget) {
+		return fmt.Errorf("job %s requests %s, more than the budget of %s", job, req, s.budget)
+	}
+	for _, name := range semaphores {
+		if s.semaphoreCapacity(name) < 1 {
+			return fmt.Errorf("job %s needs semaphore %s, which has no capacity", job, name)
+		}
+	}
+
+	// wake up the waiters when ctx is done, so they can give up
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// jobs are never admitted once ctx is done, even if a slot was freed in the meantime
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.admissible(req, semaphores) {
+			break
+		}
+		s.cond.Wait()
+	}
+
+	s.running++
+	s.used = s.used.add(req)
+	for _, name := range semaphores {
+		s.held[name]++
+	}
+
+	return nil
+}
+
+// frees what the job acquired, letting waiting jobs start
+func (s *scheduler) release(req Resources, semaphores []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	s.used = s.used.sub(req)
+	for _, name := range semaphores {
+		s.held[name]--
+	}
+	s.cond.Broadcast()
+}
@@ -0,0 +1,131 @@
+package anypipe
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+type scheduledJob struct {
+	req        Resources
+	semaphores []string
+}
+
+// runs the jobs concurrently through the scheduler, returns the highest number of jobs running at once
+func peakConcurrency(t *testing.T, s *scheduler, jobs []scheduledJob) int {
+	var running, peak int32
+	wg := sync.WaitGroup{}
+
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job scheduledJob) {
+			defer wg.Done()
+
+			assert.NoError(t, s.acquire(context.Background(), "job", job.req, job.semaphores))
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			s.release(job.req, job.semaphores)
+		}(job)
+	}
+	wg.Wait()
+
+	return int(peak)
+}
+
+func TestScheduler(t *testing.T) {
+	type testcase struct {
+		name         string
+		scheduler    *scheduler
+		jobs         []scheduledJob
+		expectedPeak int
+	}
+
+	four := []scheduledJob{{}, {}, {}, {}}
+	twoCPUs := []scheduledJob{{req: Resources{CPUs: 2}}, {req: Resources{CPUs: 2}}, {req: Resources{CPUs: 2}}}
+	database := []scheduledJob{{semaphores: []string{"db"}}, {semaphores: []string{"db"}}, {semaphores: []string{"db"}}, {}}
+
+	testcases := []testcase{
+		{name: "no limits", scheduler: newScheduler(0, Resources{}, nil), jobs: four, expectedPeak: 4},
+		{name: "max concurrency", scheduler: newScheduler(2, Resources{}, nil), jobs: four, expectedPeak: 2},
+		{name: "cpu budget", scheduler: newScheduler(0, Resources{CPUs: 5}, nil), jobs: twoCPUs, expectedPeak: 2},
+		{name: "memory budget", scheduler: newScheduler(0, Resources{Memory: 1 << 30}, nil), jobs: []scheduledJob{{req: Resources{Memory: 1 << 30}}, {req: Resources{Memory: 1 << 30}}}, expectedPeak: 1},
+		{name: "semaphore", scheduler: newScheduler(0, Resources{}, nil), jobs: database, expectedPeak: 2},
+		{name: "semaphore capacity", scheduler: newScheduler(0, Resources{}, map[string]int{"db": 3}), jobs: database, expectedPeak: 4},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedPeak, peakConcurrency(t, tc.scheduler, tc.jobs))
+		})
+	}
+}
+
+func TestSchedulerRejects(t *testing.T) {
+	s := newScheduler(0, Resources{CPUs: 4, Memory: 1 << 30}, map[string]int{"none": 0})
+
+	assert.ErrorContains(t, s.acquire(context.Background(), "big", Resources{CPUs: 8}, nil), "job big requests 8 CPUs, 0 B memory, more than the budget of 4 CPUs, 1.0 GiB memory")
+	assert.ErrorContains(t, s.acquire(context.Background(), "db", Resources{}, []string{"none"}), "no capacity")
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(1, Resources{}, nil)
+	assert.NoError(t, s.acquire(context.Background(), "first", Resources{}, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, s.acquire(ctx, "second", Resources{}, nil), context.DeadlineExceeded)
+}
+
+func TestParallelJobs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{CPUs: 2}).Times(3).Return(&dockerutils.Container{}, nil)
+
+	var running, peak int32
+	job := func(name string) Job {
+		return NewJobImpl(name, "testimage:latest").
+			WithResources(2, 0).
+			WithStep("step", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				n := atomic.AddInt32(&running, 1)
+				if n > atomic.LoadInt32(&peak) {
+					atomic.StoreInt32(&peak, n)
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+
+				variables[name] = "done"
+				return nil
+			})
+	}
+
+	pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+		WithParallelJobs(job("a"), job("b"), job("c")).
+		WithMaxConcurrency(2).
+		WithResourceBudget(2, 0).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	variables := map[string]interface{}{"initial": "value"}
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(du, variables))
+
+	assert.Equal(t, int32(1), peak)
+	assert.Equal(t, map[string]interface{}{"initial": "value", "a": "done", "b": "done", "c": "done"}, variables)
+}
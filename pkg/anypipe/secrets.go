@@ -0,0 +1,284 @@
+package anypipe
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+const mask = "***"
+
+// Secret is a variable whose value is masked in logs, captured command output, summaries and reports.
+// Printing or logging a Secret only shows "***", use Value() to get the actual value
+type Secret struct {
+	value string
+}
+
+func NewSecret(value string) Secret {
+	return Secret{value: value}
+}
+
+func (s Secret) Value() string {
+	return s.value
+}
+
+func (s Secret) String() string {
+	return mask
+}
+
+func (s Secret) GoString() string {
+	return mask
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(mask)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + mask + `"`), nil
+}
+
+// returns the value of a secret variable. plain strings are accepted too, and treated as secrets
+func secretValue(variables map[string]interface{}, name string) (string, error) {
+	switch v := variables[name].(type) {
+	case Secret:
+		return v.Value(), nil
+	case *Secret:
+		return v.Value(), nil
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret variable %s is not set", name)
+	default:
+		return "", fmt.Errorf("secret variable %s must be a Secret or a string, got %T", name, v)
+	}
+}
+
+// masker replaces known secret values with "***"
+type masker struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+func newMasker(values ...string) *masker {
+	m := &masker{}
+	m.add(values...)
+
+	return m
+}
+
+// registers values to be masked, returns the ones that were not known yet
+func (m *masker) add(values ...string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := []string{}
+	for _, v := range values {
+		if len(v) == 0 || contains(m.values, v) {
+			continue
+		}
+		m.values = append(m.values, v)
+		added = append(added, v)
+	}
+
+	// longest first, so a secret containing another one is fully masked
+	sort.Slice(m.values, func(i, j int) bool { return len(m.values[i]) > len(m.values[j]) })
+
+	return added
+}
+
+// registers the values of all Secret variables, returns the ones that were not known yet
+func (m *masker) collect(variables map[string]interface{}) []string {
+	values := []string{}
+	for _, v := range variables {
+		switch s := v.(type) {
+		case Secret:
+			values = append(values, s.Value())
+		case *Secret:
+			values = append(values, s.Value())
+		}
+	}
+
+	return m.add(values...)
+}
+
+// returns the known secret values
+func (m *masker) secrets() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]string{}, m.values...)
+}
+
+func (m *masker) mask(s string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, v := range m.values {
+		s = strings.ReplaceAll(s, v, mask)
+	}
+
+	return s
+}
+
+// returns err with its message masked, or err itself if there is nothing to mask
+func (m *masker) maskError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := m.mask(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+
+	return &maskedError{err: err, msg: msg}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maskedError hides secrets from the message of the wrapped error
+type maskedError struct {
+	err error
+	msg string
+}
+
+func (e *maskedError) Error() string {
+	return e.msg
+}
+
+func (e *maskedError) Unwrap() error {
+	return e.err
+}
+
+// maskingHandler masks secrets in the message and attributes of log records
+type maskingHandler struct {
+	slog.Handler
+	m *masker
+}
+
+// returns a logger masking secrets known to m, unless log already does
+func maskedLogger(log *slog.Logger, m *masker) *slog.Logger {
+	if h, ok := log.Handler().(*maskingHandler); ok && h.m == m {
+		return log
+	}
+
+	return slog.New(&maskingHandler{Handler: log.Handler(), m: m})
+}
+
+func (h *maskingHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, h.m.mask(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(h.maskAttr(a))
+		return true
+	})
+
+	return h.Handler.Handle(ctx, masked)
+}
+
+func (h *maskingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := []slog.Attr{}
+	for _, a := range attrs {
+		masked = append(masked, h.maskAttr(a))
+	}
+
+	return &maskingHandler{Handler: h.Handler.WithAttrs(masked), m: h.m}
+}
+
+func (h *maskingHandler) WithGroup(name string) slog.Handler {
+	return &maskingHandler{Handler: h.Handler.WithGroup(name), m: h.m}
+}
+
+func (h *maskingHandler) maskAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.m.mask(v.String()))
+	case slog.KindAny:
+		return slog.String(a.Key, h.m.mask(fmt.Sprint(v.Any())))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, ga := range v.Group() {
+			attrs = append(attrs, h.maskAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	default:
+		return slog.Attr{Key: a.Key, Value: v}
+	}
+}
+
+// directory of the files written with WithSecretFile
+const SecretsDir = "/run/secrets"
+
+// registers the secrets in variables for masking, and with the CI provider
+func (j *JobImpl) collectSecrets(variables map[string]interface{}) {
+	for _, v := range j.masker.collect(variables) {
+		j.provider.AddMask(v)
+	}
+}
+
+// mounts a tmpfs for secret files, if the job declares any, and limits the container to the requested resources
+func (j *JobImpl) containerOptions() dockerutils.ContainerOptions {
+	opts := dockerutils.ContainerOptions{
+		CPUs:   j.Resources.CPUs,
+		Memory: j.Resources.Memory,
+	}
+	if len(j.SecretFiles) > 0 {
+		opts.Tmpfs = map[string]string{SecretsDir: "rw,noexec,nosuid,size=1m,mode=1777"}
+	}
+
+	return opts
+}
+
+// injects the secrets declared on the job into its container
+func (j *JobImpl) injectSecrets(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+	for envKey, name := range j.SecretEnv {
+		value, err := secretValue(variables, name)
+		if err != nil {
+			return err
+		}
+		j.addSecret(value)
+		c.AddEnv(envKey, value)
+	}
+
+	for file, name := range j.SecretFiles {
+		value, err := secretValue(variables, name)
+		if err != nil {
+			return err
+		}
+		j.addSecret(value)
+
+		// the value is passed through the environment of this exec only, so it never shows up in the command
+		c.AddEnv("ANYPIPE_SECRET_VALUE", value)
+		_, stderr, ec, err := du.Exec(c, fmt.Sprintf(`umask 077 && printf '%%s' "$ANYPIPE_SECRET_VALUE" > %s/%s`, SecretsDir, file))
+		c.RemoveEnv("ANYPIPE_SECRET_VALUE")
+		if err != nil {
+			return err
+		}
+		if ec != 0 {
+			return fmt.Errorf("failed to write secret file %s: %s", file, stderr)
+		}
+	}
+
+	return nil
+}
+
+func (j *JobImpl) addSecret(value string) {
+	for _, v := range j.masker.add(value) {
+		j.provider.AddMask(v)
+	}
+}
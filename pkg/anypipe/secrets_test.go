@@ -0,0 +1,240 @@
+package anypipe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSecretFormatting(t *testing.T) {
+	s := NewSecret("hunter2")
+
+	assert.Equal(t, "hunter2", s.Value())
+	assert.Equal(t, "***", fmt.Sprint(s))
+	assert.Equal(t, "***", fmt.Sprintf("%v %+v %#v", s, s, s)[:3])
+	assert.NotContains(t, fmt.Sprintf("%v %+v %#v %s", s, s, s, s), "hunter2")
+
+	res, err := json.Marshal(map[string]interface{}{"token": s})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"token":"***"}`, string(res))
+
+	buf := bytes.NewBuffer([]byte{})
+	slog.New(slog.NewTextHandler(buf, nil)).Info("msg", "token", s)
+	assert.Contains(t, buf.String(), "token=***")
+}
+
+func TestSecretValue(t *testing.T) {
+	s := NewSecret("a")
+	variables := map[string]interface{}{
+		"secret":  s,
+		"pointer": &s,
+		"plain":   "b",
+		"number":  3,
+	}
+
+	type testcase struct {
+		name          string
+		expectedValue string
+		expectedError bool
+	}
+
+	testcases := []testcase{
+		{name: "secret", expectedValue: "a"},
+		{name: "pointer", expectedValue: "a"},
+		{name: "plain", expectedValue: "b"},
+		{name: "number", expectedError: true},
+		{name: "missing", expectedError: true},
+	}
+
+	for _, tc := range testcases {
+		value, err := secretValue(variables, tc.name)
+		if tc.expectedError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedValue, value)
+	}
+}
+
+func TestMasker(t *testing.T) {
+	m := newMasker("token")
+
+	assert.Equal(t, []string{"abc"}, m.add("abc", "", "token"))
+	assert.Equal(t, []string{"tokenvalue"}, m.collect(map[string]interface{}{"a": NewSecret("tokenvalue"), "b": "plain"}))
+
+	assert.Equal(t, "*** and *** and *** and plain", m.mask("tokenvalue and token and abc and plain"))
+
+	assert.Nil(t, m.maskError(nil))
+
+	plain := errors.New("nothing to hide")
+	assert.Equal(t, plain, m.maskError(plain))
+
+	stepErr := &StepError{Err: errors.New("bad token"), File: "main.go"}
+	masked := m.maskError(stepErr)
+	assert.Equal(t, "bad ***", masked.Error())
+	assert.ErrorAs(t, masked, &stepErr)
+}
+
+func TestMaskingHandler(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	m := newMasker("s3cr3t")
+	log := maskedLogger(slog.New(slog.NewTextHandler(buf, nil)), m)
+
+	assert.Equal(t, log, maskedLogger(log, m))
+
+	log.With("cmd", "echo s3cr3t").WithGroup("g").Info("value is s3cr3t", "err", errors.New("s3cr3t failed"), "n", 1, slog.Group("inner", "v", "s3cr3t"))
+
+	assert.NotContains(t, buf.String(), "s3cr3t")
+	assert.Contains(t, buf.String(), `msg="value is ***"`)
+	assert.Contains(t, buf.String(), `cmd="echo ***"`)
+	assert.Contains(t, buf.String(), `g.err="*** failed"`)
+	assert.Contains(t, buf.String(), `g.n=1`)
+	assert.Contains(t, buf.String(), `g.inner.v=***`)
+}
+
+func TestJobSecrets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	logs := bytes.NewBuffer([]byte{})
+	testLogger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	out := bytes.NewBuffer([]byte{})
+
+	c := &dockerutils.Container{}
+	leak := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		stdout, _, _, err := du.Exec(c, "echo $TOKEN")
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("unexpected output %s", stdout)
+	}
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{
+		Tmpfs: map[string]string{SecretsDir: "rw,noexec,nosuid,size=1m,mode=1777"},
+	}).Times(1).Return(c, nil)
+	du.EXPECT().Exec(c, `umask 077 && printf '%s' "$ANYPIPE_SECRET_VALUE" > /run/secrets/deploy_key`).Times(1).
+		DoAndReturn(func(c *dockerutils.Container, cmd string) (string, string, int, error) {
+			assert.Contains(t, c.Env(), "ANYPIPE_SECRET_VALUE=-----KEY-----")
+			return "", "", 0, nil
+		})
+	du.EXPECT().Exec(c, "echo $TOKEN").Times(1).Return("hunter2\n", "", 0, nil)
+
+	events := []Event{}
+	job := NewJobImpl("job", "testimage:latest").
+		WithStep("step1", leak).
+		WithSecretEnv("TOKEN", "token").
+		WithSecretFile("deploy_key", "key").
+		WithCIProvider(ci.NewGitHub(map[string]string{}, out)).
+		WithEventHandler(func(e Event) { events = append(events, e) })
+
+	variables := map[string]interface{}{
+		"token": NewSecret("hunter2"),
+		"key":   "-----KEY-----",
+	}
+
+	err := job.Run(context.Background(), testLogger, du, variables)
+	assert.Error(t, err)
+
+	assert.Contains(t, c.Env(), "TOKEN=hunter2")
+	assert.NotContains(t, c.Env(), "ANYPIPE_SECRET_VALUE=-----KEY-----")
+
+	for _, e := range events {
+		assert.NotContains(t, fmt.Sprintf("%+v", e), "hunter2")
+		if e.Type == EventOutput {
+			assert.Equal(t, "***\n", e.Stdout)
+		}
+	}
+
+	metrics := job.GetMetrics()
+	assert.Equal(t, "unexpected output ***\n", metrics[0].Result.Error())
+
+	assert.Contains(t, out.String(), "::add-mask::hunter2\n")
+	assert.Contains(t, out.String(), "::add-mask::-----KEY-----\n")
+	assert.Contains(t, out.String(), "::error title=job / step1::unexpected output ***%0A\n")
+	assert.NotContains(t, logs.String(), "hunter2")
+}
+
+func TestJobMissingSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	job := NewJobImpl("job", "testimage:latest").
+		WithSecretEnv("TOKEN", "token").
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	err := job.Run(context.Background(), testLogger, du, map[string]interface{}{})
+	assert.EqualError(t, err, "secret variable token is not set")
+}
+
+func TestPipelineSecretSetByEarlierJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	login := &dockerutils.Container{}
+	deploy := &dockerutils.Container{}
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(login, nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(deploy, nil)
+
+	setToken := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		variables["token"] = NewSecret("hunter2")
+		return nil
+	}
+	var token string
+	readToken := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		token, _ = c.LookupEnv("TOKEN")
+		return nil
+	}
+
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "test pipeline").
+		WithSequentialJobs(NewJobImpl("login", "testimage:latest").WithStep("login", setToken).WithSetsVariables("token")).
+		WithSequentialJobs(NewJobImpl("deploy", "testimage:latest").WithStep("deploy", readToken).WithSecretEnv("TOKEN", "token")).
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	variables := map[string]interface{}{}
+	assert.NoError(t, pipeline.Validate(variables))
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(du, variables))
+	assert.Equal(t, "hunter2", token)
+}
+
+func TestPipelineMasksLogs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	logs := bytes.NewBuffer([]byte{})
+	testLogger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(&dockerutils.Container{}, nil)
+
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "test pipeline").
+		WithSequentialJobs(
+			NewJobImpl("deploy hunter2 s3cr3t", "testimage:latest"),
+		).
+		WithMaskedValues("s3cr3t").
+		WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+	assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{"token": NewSecret("hunter2")}))
+	assert.NotContains(t, logs.String(), "s3cr3t")
+	assert.NotContains(t, logs.String(), "hunter2")
+	assert.Contains(t, logs.String(), "starting job deploy *** ***")
+}
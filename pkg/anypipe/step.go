@@ -1,22 +1,97 @@
 package anypipe
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// StepError can be returned by a step to attach a source location to its failure,
+// which is added to the annotation emitted by the CI provider
+type StepError struct {
+	Err  error
+	File string
+	Line int
+	// emit the annotation as a warning instead of an error
+	Warning bool
+}
+
+func (e *StepError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is the result of a step that panicked
+type PanicError struct {
+	// the value passed to panic
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("step panicked: %v", e.Value)
+}
+
+// the error passed to panic, if any, e.g. a *runtime.TypeAssertionError
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// the file and line that panicked: the first frame below the call to panic that isn't in the runtime
+func (e *PanicError) Location() (string, int) {
+	lines := strings.Split(string(e.Stack), "\n")
+	panicked := false
+	for i := 0; i+1 < len(lines); i++ {
+		if !panicked {
+			panicked = strings.HasPrefix(lines[i], "panic(")
+			continue
+		}
+
+		// frames are a function line followed by a tab-indented "file:line +offset" line
+		loc, ok := strings.CutPrefix(lines[i], "\t")
+		if !ok {
+			continue
+		}
+		loc, _, _ = strings.Cut(loc, " ")
+		file, lineNo, ok := strings.Cut(loc[strings.LastIndex(loc, "/")+1:], ":")
+		if !ok || strings.Contains(loc, "/src/runtime/") {
+			continue
+		}
+		n, err := strconv.Atoi(lineNo)
+		if err != nil {
+			continue
+		}
+		return loc[:strings.LastIndex(loc, "/")+1] + file, n
+	}
+
+	return "", 0
+}
+
 type StepFunc func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error
 
 type Step interface {
-	Run(log *slog.Logger, du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error
+	Run(ctx context.Context, log *slog.Logger, du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error
+	WithEnv(key, value string) Step
 	GetName() string
 }
 
 type StepImpl struct {
 	Name string
 	Impl StepFunc
+	// inputs and outputs of the step, if it can be skipped when they are unchanged
+	Cache *StepCache
+	// env variables set in the container while the step runs, over those of the job
+	Env map[string]string
 }
 
 func NewStepImpl(name string, impl StepFunc) Step {
@@ -26,13 +101,35 @@ func NewStepImpl(name string, impl StepFunc) Step {
 	}
 }
 
-func (s *StepImpl) Run(log *slog.Logger,
+func (s *StepImpl) Run(ctx context.Context,
+	log *slog.Logger,
 	du dockerutils.DockerUtils,
 	c *dockerutils.Container,
-	variables map[string]interface{}) error {
+	variables map[string]interface{}) (err error) {
+
+	ctx, span := startSpan(ctx, fmt.Sprintf("step %s", s.Name), attribute.String("step", s.Name))
+	// a panic fails the step like an error would, so the job's failure handling and cleanup still run
+	defer func() {
+		if v := recover(); v != nil {
+			pe := &PanicError{Value: v, Stack: debug.Stack()}
+			log.Error(fmt.Sprintf("step %s panicked: %v\n%s", s.Name, v, pe.Stack))
+			err = pe
+		}
+		endSpan(ctx, span, err)
+	}()
 
 	log.Info(fmt.Sprintf("running step %s", s.Name))
-	return s.Impl(du, c, variables)
+	return s.Impl(traced(ctx, du), c, variables)
+}
+
+// sets an env variable in the container while the step runs. value may reference variables, see Interpolate
+func (s *StepImpl) WithEnv(key, value string) Step {
+	if s.Env == nil {
+		s.Env = map[string]string{}
+	}
+	s.Env[key] = value
+
+	return s
 }
 
 func (s *StepImpl) GetName() string {
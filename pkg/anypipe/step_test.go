@@ -1,9 +1,14 @@
 package anypipe
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
@@ -35,6 +40,93 @@ func TestStep(t *testing.T) {
 
 	step := NewStepImpl("test step", f1)
 
-	err := step.Run(testLogger, du, &c, map[string]interface{}{"TESTVAR": "TESTVALUE"})
+	err := step.Run(context.Background(), testLogger, du, &c, map[string]interface{}{"TESTVAR": "TESTVALUE"})
 	assert.NoError(t, err)
 }
+
+func TestStepPanic(t *testing.T) {
+	type testcase struct {
+		name    string
+		impl    StepFunc
+		value   string
+		runtime bool
+	}
+
+	testcases := []testcase{
+		{
+			name: "panic with a value",
+			impl: func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				panic("boom")
+			},
+			value: "boom",
+		},
+		{
+			name: "failed type assertion",
+			impl: func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				_ = variables["TESTVAR"].(string)
+				return nil
+			},
+			value:   "interface conversion",
+			runtime: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			testLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			err := NewStepImpl("test step", tc.impl).Run(context.Background(), testLogger, du, &dockerutils.Container{}, map[string]interface{}{"TESTVAR": 1})
+
+			var panicErr *PanicError
+			assert.ErrorAs(t, err, &panicErr)
+			assert.Contains(t, err.Error(), tc.value)
+			assert.Contains(t, string(panicErr.Stack), "step_test.go")
+
+			var runtimeErr runtime.Error
+			assert.Equal(t, tc.runtime, errors.As(err, &runtimeErr))
+
+			file, line := panicErr.Location()
+			assert.True(t, strings.HasSuffix(file, "step_test.go"), file)
+			assert.Greater(t, line, 0)
+		})
+	}
+}
+
+func TestStepPanicFailsJob(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().CreateContainerWithOptions(gomock.Any(), gomock.Any()).Times(1).Return(&dockerutils.Container{}, nil)
+
+	panicking := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		panic("boom")
+	}
+
+	job := NewJobImpl("a", "testimage:latest").WithStep("panic", panicking).WithStep("next", passingStep)
+	pipeline := testPipeline()
+	pipeline.WithSequentialJobs(job).WithSequentialJobs(
+		NewJobImpl("b", "testimage:latest").WithStep("step", passingStep),
+	)
+
+	assert.Error(t, pipeline.run(du, map[string]interface{}{}))
+	assert.Equal(t, []JobResult{
+		{Job: "a", Result: ResultFail, Reason: "job failed"},
+		{Job: "b", Result: ResultNotRun, Reason: "job a failed (finish-running)"},
+	}, pipeline.Results)
+
+	metrics := job.GetMetrics()
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "FAIL", resultOf(metrics[0]))
+	assert.Equal(t, "SKIP", resultOf(metrics[1]))
+
+	suites := junitSuites([]Job{job})
+	assert.Len(t, suites, 1)
+	assert.Equal(t, "step panicked: boom", suites[0].TestCases[0].Failure.Message)
+	assert.Contains(t, suites[0].TestCases[0].Failure.Body, "goroutine")
+	assert.Contains(t, suites[0].TestCases[0].Failure.Body, "step_test.go")
+}
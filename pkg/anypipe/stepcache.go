@@ -0,0 +1,197 @@
+package anypipe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/notmiguelalves/anypipe/pkg/utils"
+)
+
+// StepCache declares what a step reads and produces. when a previous successful run had the same inputs,
+// image and command, its outputs are restored and the step is reported as CACHED instead of running
+type StepCache struct {
+	// what the step does, e.g. the command it executes. changing it invalidates the cache
+	Command string
+	// globs of host files read by the step, relative to the working directory
+	HostInputs []string
+	// container files or directories read by the step
+	ContainerInputs []string
+	// container files or directories produced by the step, relative ones are in the working directory of Exec
+	Outputs []string
+}
+
+// returns the cache declaration of the step, if any
+func stepCacheOf(step Step) *StepCache {
+	if s, ok := step.(*StepImpl); ok {
+		return s.Cache
+	}
+
+	return nil
+}
+
+// hashes the step's inputs together with the job's image digest and the step's command
+func (j *JobImpl) stepCacheKey(du dockerutils.DockerUtils, c *dockerutils.Container, sc *StepCache) (string, error) {
+	digest, err := du.ImageDigest(j.image)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "image %s\ncommand %s\noutputs %s\n", digest, strconv.Quote(sc.Command), strings.Join(sc.Outputs, " "))
+
+	if err := hashHostFiles(h, sc.HostInputs); err != nil {
+		return "", err
+	}
+
+	if len(sc.ContainerInputs) > 0 {
+		paths := []string{}
+		for _, p := range sc.ContainerInputs {
+			paths = append(paths, ShellQuote(p))
+		}
+
+		// checked upfront, since the exit code of find is lost in the pipe
+		stdout, stderr, ec, err := du.Exec(c, fmt.Sprintf(`for p in %[1]s; do [ -e "$p" ] || { echo "$p does not exist" >&2; exit 1; }; done; find %[1]s -type f -exec sha256sum {} + | sort`, strings.Join(paths, " ")))
+		if err != nil {
+			return "", err
+		}
+		if ec != 0 {
+			return "", fmt.Errorf("failed to hash container inputs: %s", stderr)
+		}
+		fmt.Fprintf(h, "container\n%s", stdout)
+	}
+
+	return "step-" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writes the path and content hash of the host files matching the globs to h, in a stable order.
+// directories are walked
+func hashHostFiles(h io.Writer, globs []string) error {
+	files := map[string]bool{}
+	for _, g := range globs {
+		matches, err := filepath.Glob(g)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("step input %s does not match any file", g)
+		}
+
+		for _, m := range matches {
+			err := filepath.WalkDir(m, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && d.Type().IsRegular() {
+					files[p] = true
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sorted := []string{}
+	for f := range files {
+		sorted = append(sorted, f)
+	}
+	sort.Strings(sorted)
+
+	for _, f := range sorted {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "host %s %x\n", filepath.ToSlash(f), sha256.Sum256(content))
+	}
+
+	return nil
+}
+
+// restores the outputs recorded under key into the container. returns false if there are none
+func (j *JobImpl) restoreStepOutputs(ctx context.Context, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container, key string, sc *StepCache) (bool, error) {
+	rc, err := s.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	dir, err := os.MkdirTemp("", "anypipe-step-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := utils.Untar(rc, dir); err != nil {
+		return false, err
+	}
+
+	// each output is stored in a directory named after its index, see saveStepOutputs
+	for i, out := range sc.Outputs {
+		parent := path.Dir(containerPath(out))
+		_, stderr, ec, err := du.Exec(c, fmt.Sprintf("mkdir -p %s", ShellQuote(parent)))
+		if err != nil {
+			return false, err
+		}
+		if ec != 0 {
+			return false, fmt.Errorf("failed to create %s: %s", parent, stderr)
+		}
+
+		if err := du.CopyTo(c, filepath.Join(dir, strconv.Itoa(i)), parent); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// returns p as an absolute container path, relative paths are resolved against the working directory of Exec
+// like the commands of the step resolve them
+func containerPath(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(dockerutils.WorkingDir, p)
+	}
+
+	return path.Clean(p)
+}
+
+// records the step's outputs under key
+func (j *JobImpl) saveStepOutputs(ctx context.Context, s cache.Store, du dockerutils.DockerUtils, c *dockerutils.Container, key string, sc *StepCache) error {
+	dir, err := os.MkdirTemp("", "anypipe-step-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for i, out := range sc.Outputs {
+		dst := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+
+		if err := du.CopyFrom(c, containerPath(out), dst); err != nil {
+			return err
+		}
+	}
+
+	buf, err := utils.Tar(dir)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, key, buf)
+}
@@ -0,0 +1,153 @@
+package anypipe
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/cache"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCachedStep(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	docs := filepath.Join(t.TempDir(), "docs")
+	assert.NoError(t, os.MkdirAll(docs, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(docs, "index.md"), []byte("# v1"), 0644))
+
+	store := cache.NewLocalStore(t.TempDir())
+	c := &dockerutils.Container{}
+	hashCmd := `for p in '/src/api.proto'; do [ -e "$p" ] || { echo "$p does not exist" >&2; exit 1; }; done; find '/src/api.proto' -type f -exec sha256sum {} + | sort`
+
+	// runs the pipeline, returns whether the step ran and its metrics
+	runPipeline := func(du *dockerutils.MockDockerUtils) (bool, StepMetrics) {
+		du.EXPECT().CreateContainerWithOptions("builder:latest", dockerutils.ContainerOptions{}).Times(1).Return(c, nil)
+		du.EXPECT().ImageDigest("builder:latest").Times(1).Return("builder@sha256:abc", nil)
+		du.EXPECT().Exec(c, hashCmd).Times(1).Return("123  /src/api.proto\n", "", 0, nil)
+
+		ran := false
+		job := NewJobImpl("docs", "builder:latest").
+			WithCachedStep("generate", StepCache{
+				Command:         "make docs",
+				HostInputs:      []string{docs},
+				ContainerInputs: []string{"/src/api.proto"},
+				Outputs:         []string{"/out/site"},
+			}, func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				ran = true
+				return nil
+			})
+
+		pipeline := NewPipelineImpl(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), "test pipeline").
+			WithSequentialJobs(job).
+			WithCacheStore(store).
+			WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard))
+
+		assert.NoError(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+		return ran, job.GetMetrics()[0]
+	}
+
+	expectSave := func(du *dockerutils.MockDockerUtils) {
+		du.EXPECT().CopyFrom(c, "/out/site", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			assert.NoError(t, os.MkdirAll(filepath.Join(dst, "site"), 0755))
+			return os.WriteFile(filepath.Join(dst, "site", "index.html"), []byte("<h1>v1</h1>"), 0644)
+		})
+	}
+
+	t.Run("first run executes the step", func(t *testing.T) {
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		expectSave(du)
+
+		ran, m := runPipeline(du)
+		assert.True(t, ran)
+		assert.Equal(t, "PASS", resultOf(m))
+	})
+
+	t.Run("unchanged inputs restore the outputs", func(t *testing.T) {
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		du.EXPECT().Exec(c, "mkdir -p '/out'").Times(1).Return("", "", 0, nil)
+		du.EXPECT().CopyTo(c, gomock.Any(), "/out").Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			content, err := os.ReadFile(filepath.Join(src, "site", "index.html"))
+			assert.NoError(t, err)
+			assert.Equal(t, "<h1>v1</h1>", string(content))
+			return nil
+		})
+
+		ran, m := runPipeline(du)
+		assert.False(t, ran)
+		assert.True(t, m.Cached)
+		assert.Equal(t, "CACHED", resultOf(m))
+	})
+
+	t.Run("changed host input executes the step", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(docs, "index.md"), []byte("# v2"), 0644))
+		du := dockerutils.NewMockDockerUtils(ctrl)
+		expectSave(du)
+
+		ran, m := runPipeline(du)
+		assert.True(t, ran)
+		assert.Equal(t, "PASS", resultOf(m))
+	})
+}
+
+func TestStepCacheKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	job := NewJobImpl("docs", "builder:latest").(*JobImpl)
+
+	du.EXPECT().ImageDigest("builder:latest").AnyTimes().Return("builder@sha256:abc", nil)
+
+	key, err := job.stepCacheKey(du, nil, &StepCache{Command: "make docs"})
+	assert.NoError(t, err)
+	assert.Regexp(t, "^step-[0-9a-f]{64}$", key)
+
+	other, err := job.stepCacheKey(du, nil, &StepCache{Command: "make site"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	_, err = job.stepCacheKey(du, nil, &StepCache{HostInputs: []string{filepath.Join(t.TempDir(), "missing")}})
+	assert.ErrorContains(t, err, "does not match any file")
+}
+
+func TestStepOutputsRelativePaths(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	store := cache.NewLocalStore(t.TempDir())
+	job := NewJobImpl("build", "builder:latest").(*JobImpl)
+	c := &dockerutils.Container{}
+	sc := &StepCache{Outputs: []string{"bin/app", "/out/site"}}
+
+	// relative outputs are in the working directory of Exec, not in /
+	du.EXPECT().CopyFrom(c, "/home/bin/app", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+		return os.WriteFile(filepath.Join(dst, "app"), []byte("binary"), 0644)
+	})
+	du.EXPECT().CopyFrom(c, "/out/site", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+		return os.MkdirAll(filepath.Join(dst, "site"), 0755)
+	})
+	assert.NoError(t, job.saveStepOutputs(context.Background(), store, du, c, "step-key", sc))
+
+	du.EXPECT().Exec(c, "mkdir -p '/home/bin'").Times(1).Return("", "", 0, nil)
+	du.EXPECT().CopyTo(c, gomock.Any(), "/home/bin").Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+		content, err := os.ReadFile(filepath.Join(src, "app"))
+		assert.NoError(t, err)
+		assert.Equal(t, "binary", string(content))
+		return nil
+	})
+	du.EXPECT().Exec(c, "mkdir -p '/out'").Times(1).Return("", "", 0, nil)
+	du.EXPECT().CopyTo(c, gomock.Any(), "/out").Times(1).Return(nil)
+
+	hit, err := job.restoreStepOutputs(context.Background(), store, du, c, "step-key", sc)
+	assert.NoError(t, err)
+	assert.True(t, hit)
+}
@@ -0,0 +1,15 @@
+package anypipe
+
+// JobTemplate builds a job from typed parameters, so near-identical jobs can be shared between
+// pipelines, e.g. published as a Go package
+type JobTemplate[P any] func(params P) Job
+
+// returns a job for each set of parameters, to be added with WithSequentialJobs or WithParallelJobs
+func (t JobTemplate[P]) Jobs(params ...P) []Job {
+	jobs := []Job{}
+	for _, p := range params {
+		jobs = append(jobs, t(p))
+	}
+
+	return jobs
+}
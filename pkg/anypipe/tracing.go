@@ -0,0 +1,124 @@
+package anypipe
+
+import (
+	"context"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/notmiguelalves/anypipe"
+
+// starts a span as a child of the span in ctx, using the same tracer provider.
+// when ctx carries no span this yields a no-op span
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// ends the span, recording err as its status. secrets of the run in ctx are masked in err, as spans are exported
+// as they are
+func endSpan(ctx context.Context, span trace.Span, err error) {
+	if err != nil {
+		err = runFrom(ctx).masker.maskError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// wraps du so its operations are traced as children of the span in ctx, if that span is being recorded
+func traced(ctx context.Context, du dockerutils.DockerUtils) dockerutils.DockerUtils {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return du
+	}
+
+	return &tracedDockerUtils{DockerUtils: du, ctx: ctx}
+}
+
+// tracedDockerUtils records a span for each Docker operation, as a child of the span in ctx
+type tracedDockerUtils struct {
+	dockerutils.DockerUtils
+	ctx context.Context
+}
+
+func (t *tracedDockerUtils) PullImage(image string) error {
+	_, span := startSpan(t.ctx, "docker.pull", attribute.String("image", image))
+	err := t.DockerUtils.PullImage(image)
+	endSpan(t.ctx, span, err)
+
+	return err
+}
+
+// the image is pulled first, so pulling and creating show up as separate spans
+func (t *tracedDockerUtils) CreateContainer(image string) (*dockerutils.Container, error) {
+	if err := t.PullImage(image); err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(t.ctx, "docker.create", attribute.String("image", image))
+	c, err := t.DockerUtils.CreateContainer(image)
+	endSpan(t.ctx, span, err)
+
+	return c, err
+}
+
+func (t *tracedDockerUtils) CreateContainerWithOptions(image string, opts dockerutils.ContainerOptions) (*dockerutils.Container, error) {
+	if err := t.PullImage(image); err != nil {
+		return nil, err
+	}
+
+	_, span := startSpan(t.ctx, "docker.create", attribute.String("image", image))
+	c, err := t.DockerUtils.CreateContainerWithOptions(image, opts)
+	endSpan(t.ctx, span, err)
+
+	return c, err
+}
+
+// the span context is propagated into the command through the TRACEPARENT env variable, which is only set
+// while it runs. secrets are masked in the command recorded with the span
+func (t *tracedDockerUtils) Exec(c *dockerutils.Container, cmd string) (stdout, stderr string, exitcode int, err error) {
+	ctx, span := startSpan(t.ctx, "docker.exec", attribute.String("command", runFrom(t.ctx).masker.mask(cmd)))
+
+	if span.SpanContext().IsValid() {
+		carrier := propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(ctx, carrier)
+		env := map[string]string{"TRACEPARENT": carrier.Get("traceparent")}
+		previous := applyEnv(c, env)
+		defer restoreEnv(c, env, previous)
+	}
+
+	stdout, stderr, exitcode, err = t.DockerUtils.Exec(c, cmd)
+
+	span.SetAttributes(attribute.Int("exit_code", exitcode))
+	endSpan(t.ctx, span, err)
+
+	return
+}
+
+func (t *tracedDockerUtils) CopyTo(c *dockerutils.Container, srcPath, dstPath string) error {
+	_, span := startSpan(t.ctx, "docker.copy_to", attribute.String("src", srcPath), attribute.String("dst", dstPath))
+	err := t.DockerUtils.CopyTo(c, srcPath, dstPath)
+	endSpan(t.ctx, span, err)
+
+	return err
+}
+
+func (t *tracedDockerUtils) CopyFrom(c *dockerutils.Container, srcPath, dstPath string) error {
+	_, span := startSpan(t.ctx, "docker.copy_from", attribute.String("src", srcPath), attribute.String("dst", dstPath))
+	err := t.DockerUtils.CopyFrom(c, srcPath, dstPath)
+	endSpan(t.ctx, span, err)
+
+	return err
+}
+
+func (t *tracedDockerUtils) CopyBetweenContainers(srcContainer, destContainer *dockerutils.Container, srcPath, dstPath string) error {
+	_, span := startSpan(t.ctx, "docker.copy_between", attribute.String("src", srcPath), attribute.String("dst", dstPath))
+	err := t.DockerUtils.CopyBetweenContainers(srcContainer, destContainer, srcPath, dstPath)
+	endSpan(t.ctx, span, err)
+
+	return err
+}
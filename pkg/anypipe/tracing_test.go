@@ -0,0 +1,164 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPipelineTracing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	c := &dockerutils.Container{}
+	f1 := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		_, _, _, err := du.Exec(c, "echo hello")
+		return err
+	}
+	f2 := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		return errors.New("some error")
+	}
+
+	du.EXPECT().PullImage("testimage:latest").Times(1).Return(nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(c, nil)
+	var execEnv []string
+	du.EXPECT().Exec(c, "echo hello").Times(1).DoAndReturn(func(c *dockerutils.Container, cmd string) (string, string, int, error) {
+		execEnv = c.Env()
+		return "hello", "", 0, nil
+	})
+
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "traced").
+		WithSequentialJobs(
+			NewJobImpl("job", "testimage:latest").
+				WithStep("step1", f1).
+				WithStep("step2", f2),
+		).
+		WithTracerProvider(tp)
+
+	assert.Error(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{}))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+
+	assert.Len(t, spans, 7)
+	pipelineSpan := spans["pipeline traced"]
+	jobSpan := spans["job job"]
+	assert.Equal(t, pipelineSpan.SpanContext().SpanID(), jobSpan.Parent().SpanID())
+	assert.Equal(t, jobSpan.SpanContext().SpanID(), spans["docker.pull"].Parent().SpanID())
+	assert.Equal(t, jobSpan.SpanContext().SpanID(), spans["docker.create"].Parent().SpanID())
+	assert.Equal(t, jobSpan.SpanContext().SpanID(), spans["step step1"].Parent().SpanID())
+	assert.Equal(t, spans["step step1"].SpanContext().SpanID(), spans["docker.exec"].Parent().SpanID())
+
+	assert.Equal(t, codes.Error, spans["step step2"].Status().Code)
+	assert.Equal(t, codes.Error, jobSpan.Status().Code)
+	assert.Equal(t, codes.Error, pipelineSpan.Status().Code)
+
+	attrs := map[string]string{}
+	for _, kv := range spans["docker.exec"].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, "echo hello", attrs["command"])
+	assert.Equal(t, "0", attrs["exit_code"])
+
+	execSpan := spans["docker.exec"].SpanContext()
+	assert.Contains(t, execEnv, "TRACEPARENT=00-"+execSpan.TraceID().String()+"-"+execSpan.SpanID().String()+"-01")
+	// only the command it was set for sees it
+	_, set := c.LookupEnv("TRACEPARENT")
+	assert.False(t, set)
+}
+
+func TestNoTracingByDefault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	assert.Equal(t, du, traced(context.Background(), du))
+
+	c := &dockerutils.Container{}
+	s := NewStepImpl("step", func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		_, _, _, err := du.Exec(c, "echo hello")
+		return err
+	})
+
+	du.EXPECT().Exec(c, "echo hello").Times(1).Return("hello", "", 0, nil)
+
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	assert.NoError(t, s.Run(context.Background(), testLogger, du, c, map[string]interface{}{}))
+
+	for _, e := range c.Env() {
+		assert.False(t, strings.HasPrefix(e, "TRACEPARENT="))
+	}
+}
+
+func TestTracingMasksSecrets(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	c := &dockerutils.Container{}
+	f := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		cmd, err := Interpolate("login --token ${{ secrets.token }}", variables)
+		if err != nil {
+			return err
+		}
+		_, _, _, err = du.Exec(c, cmd)
+		return err
+	}
+
+	du.EXPECT().PullImage("testimage:latest").Times(1).Return(nil)
+	du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Times(1).Return(c, nil)
+	du.EXPECT().Exec(c, "login --token hunter2").Times(1).Return("", "", 1, errors.New("login with hunter2 failed"))
+
+	pipeline := NewPipelineImpl(context.Background(), testLogger, "traced").
+		WithSequentialJobs(NewJobImpl("job", "testimage:latest").WithStep("login", f)).
+		WithTracerProvider(tp)
+
+	assert.Error(t, pipeline.(*AnypipeImpl).run(du, map[string]interface{}{"token": NewSecret("hunter2")}))
+
+	spans := exporter.GetSpans()
+	assert.NotEmpty(t, spans)
+	for _, s := range spans {
+		for _, kv := range s.Attributes {
+			assert.NotContains(t, kv.Value.Emit(), "hunter2", "span %s", s.Name)
+		}
+		assert.NotContains(t, s.Status.Description, "hunter2", "span %s", s.Name)
+		for _, e := range s.Events {
+			for _, kv := range e.Attributes {
+				assert.NotContains(t, kv.Value.Emit(), "hunter2", "span %s", s.Name)
+			}
+		}
+	}
+
+	attrs := map[string]string{}
+	for _, s := range spans {
+		if s.Name == "docker.exec" {
+			for _, kv := range s.Attributes {
+				attrs[string(kv.Key)] = kv.Value.Emit()
+			}
+		}
+	}
+	assert.Equal(t, "login --token ***", attrs["command"])
+}
@@ -0,0 +1,442 @@
+package anypipe
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// ValidationError lists every problem Validate found in a pipeline
+type ValidationError struct {
+	Pipeline string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("pipeline %s is invalid:\n  %s", e.Pipeline, strings.Join(e.Problems, "\n  "))
+}
+
+// validator is implemented by jobs that can be checked before the pipeline runs
+type validator interface {
+	validate(v *validation) []string
+}
+
+// validation holds what references are checked against
+type validation struct {
+	variables map[string]interface{}
+	// jobs that run in an earlier stage than the job being checked
+	earlier map[string]bool
+	// variables that aren't passed to Run but declared to be set by earlier jobs, see JobImpl.WithSetsVariables
+	set map[string]bool
+}
+
+// returns a copy of v where the variables are also declared to be set
+func (v *validation) with(names []string) *validation {
+	set := maps.Clone(v.set)
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return &validation{variables: v.variables, earlier: v.earlier, set: set}
+}
+
+// whether the variable is only set once the pipeline runs
+func (v *validation) setLater(name string) bool {
+	return v.variables[name] == nil && v.set[name]
+}
+
+// checks the references in s, see Interpolate. values that are only known once the pipeline runs, the outputs
+// of jobs and variables or secrets declared to be set by earlier jobs, are replaced with a placeholder in the
+// returned string
+func (v *validation) references(what, s string) (string, []string) {
+	problems := []string{}
+	expanded := referenceRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		expr := strings.TrimSpace(referenceRegex.FindStringSubmatch(ref)[1])
+		namespace, name, _ := strings.Cut(expr, ".")
+		if (namespace == "vars" || namespace == "secrets") && v.setLater(name) {
+			return "variable"
+		}
+		if namespace == "jobs" {
+			job, output, ok := strings.Cut(name, ".outputs.")
+			switch {
+			case !ok || len(output) == 0:
+				problems = append(problems, fmt.Sprintf("%s: invalid reference %s, expected jobs.<job>.outputs.<name>", what, expr))
+			case !v.earlier[job]:
+				problems = append(problems, fmt.Sprintf("%s: references outputs of job %s, which does not run in an earlier stage", what, job))
+			}
+			return "output"
+		}
+
+		resolved, err := resolveReference(expr, v.variables)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", what, err.Error()))
+			return ref
+		}
+		return resolved
+	})
+
+	if strings.Contains(referenceRegex.ReplaceAllString(s, ""), "${{") {
+		problems = append(problems, fmt.Sprintf("%s: unterminated reference in %q", what, s))
+	}
+
+	return expanded, problems
+}
+
+// checks the secret variables of WithSecretEnv and WithSecretFile are set, or declared to be set by earlier jobs
+func (v *validation) secrets(what string, secrets ...map[string]string) []string {
+	problems := []string{}
+	for _, s := range secrets {
+		for _, variable := range sortedValues(s) {
+			if v.setLater(variable) {
+				continue
+			}
+			if _, err := secretValue(v.variables, variable); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", what, err.Error()))
+			}
+		}
+	}
+
+	return problems
+}
+
+// checks the env files can be loaded and the references of the env values
+func (v *validation) env(what string, e Env) []string {
+	problems := []string{}
+	for _, f := range e.Files {
+		if _, err := LoadEnvFile(f); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", what, err.Error()))
+		}
+	}
+
+	keys := []string{}
+	for k := range e.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, p := v.references(fmt.Sprintf("%s env variable %s", what, k), e.Vars[k])
+		problems = append(problems, p...)
+	}
+
+	return problems
+}
+
+// Validate checks the pipeline can run with the variables, without running anything: that job and step names
+// are unique, images refs are valid, artifacts are produced before they're needed, jobs fit the resource budget,
+// referenced secrets are set, and so on. it returns a *ValidationError listing every problem found.
+//
+// Run validates the pipeline before it starts. variables set by a job are only known once it ran, later stages
+// reference them as ${{ jobs.<job>.outputs.<name> }}, or as ${{ vars.<name> }} if the job declares it sets
+// them with WithSetsVariables. variables the pipeline can't run without are declared with WithInputs.
+//
+// jobs and steps have no conditions, every job runs unless a failure stops the pipeline, so there are no
+// unreachable conditions to check. the jobs that can never run are those that don't fit the resource budget or
+// need a semaphore without capacity, which are reported
+func (p *AnypipeImpl) Validate(variables map[string]interface{}) error {
+	problems := p.validate(variables, map[string]bool{})
+	if len(problems) > 0 {
+		return &ValidationError{Pipeline: p.Name, Problems: problems}
+	}
+
+	return nil
+}
+
+// set are the variables declared to be set by the jobs that run before the pipeline, if it is nested
+func (p *AnypipeImpl) validate(variables map[string]interface{}, set map[string]bool) []string {
+	problems := []string{}
+	if len(p.Jobs) == 0 {
+		problems = append(problems, "pipeline has no jobs")
+	}
+	if p.MaxConcurrency < 0 {
+		problems = append(problems, fmt.Sprintf("max concurrency %d must not be negative", p.MaxConcurrency))
+	}
+	if !validPolicy(p.FailurePolicy) {
+		problems = append(problems, fmt.Sprintf("unknown failure policy %s", p.FailurePolicy))
+	}
+	if p.PullConcurrency < 0 {
+		problems = append(problems, fmt.Sprintf("image pre-pull concurrency %d must not be negative", p.PullConcurrency))
+	}
+
+	v := &validation{variables: variables, earlier: map[string]bool{}, set: maps.Clone(set)}
+	for _, name := range p.Inputs {
+		if v.variables[name] == nil && !v.set[name] {
+			problems = append(problems, fmt.Sprintf("required input %s is not set", name))
+		}
+	}
+	problems = append(problems, v.env("pipeline", p.Env)...)
+
+	// the stage of each job, to check what it depends on runs before it
+	stages := map[string]int{}
+	for i, stage := range p.Stages {
+		for _, job := range stage {
+			name := job.GetName()
+			if len(name) == 0 {
+				problems = append(problems, fmt.Sprintf("job %d of stage %d has no name", len(stages)+1, i+1))
+				continue
+			}
+			if _, ok := stages[name]; ok {
+				problems = append(problems, fmt.Sprintf("job name %s is used more than once", name))
+				continue
+			}
+			stages[name] = i
+		}
+	}
+
+	for _, stage := range p.Stages {
+		for _, job := range stage {
+			problems = append(problems, p.validateScheduling(job)...)
+			if jv, ok := job.(validator); ok {
+				problems = append(problems, jv.validate(v)...)
+			}
+		}
+		// jobs of the same stage run concurrently, only those of earlier stages are visible
+		for _, job := range stage {
+			v.earlier[job.GetName()] = true
+			for _, name := range job.GetSetVariables() {
+				v.set[name] = true
+			}
+		}
+	}
+
+	return append(problems, p.validateArtifacts(stages)...)
+}
+
+// checks the job can ever be scheduled
+func (p *AnypipeImpl) validateScheduling(job Job) []string {
+	problems := []string{}
+	if !job.GetResources().fits(p.Budget) {
+		problems = append(problems, fmt.Sprintf("job %s requests %s, more than the budget of %s, it can never run", job.GetName(), job.GetResources(), p.Budget))
+	}
+	for _, name := range job.GetSemaphores() {
+		if c, ok := p.Semaphores[name]; ok && c < 1 {
+			problems = append(problems, fmt.Sprintf("job %s needs semaphore %s, which has no capacity, it can never run", job.GetName(), name))
+		}
+	}
+
+	return problems
+}
+
+// checks every artifact is produced by a single job, before the jobs that need it, and that jobs don't depend on
+// each other through artifacts
+func (p *AnypipeImpl) validateArtifacts(stages map[string]int) []string {
+	problems := []string{}
+	producers := map[string]string{}
+	for _, job := range p.Jobs {
+		for _, a := range job.GetArtifactOutputs() {
+			if other, ok := producers[a]; ok {
+				problems = append(problems, fmt.Sprintf("artifact %s is produced by both job %s and job %s", a, other, job.GetName()))
+				continue
+			}
+			producers[a] = job.GetName()
+		}
+	}
+
+	// jobs each job needs an artifact of
+	deps := map[string][]string{}
+	for _, job := range p.Jobs {
+		for _, a := range job.GetArtifactInputs() {
+			producer, ok := producers[a]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("job %s needs artifact %s, which no job produces", job.GetName(), a))
+				continue
+			}
+			deps[job.GetName()] = append(deps[job.GetName()], producer)
+		}
+	}
+
+	inCycle := map[string]bool{}
+	for _, cycle := range dependencyCycles(p.Jobs, deps) {
+		problems = append(problems, fmt.Sprintf("dependency cycle: %s", strings.Join(cycle, " -> ")))
+		for _, job := range cycle {
+			inCycle[job] = true
+		}
+	}
+
+	for _, job := range p.Jobs {
+		name := job.GetName()
+		for _, producer := range deps[name] {
+			if stages[producer] < stages[name] || (inCycle[name] && inCycle[producer]) {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("job %s needs an artifact of job %s, which does not run in an earlier stage", name, producer))
+		}
+	}
+
+	return problems
+}
+
+// returns the cycles of the dependency graph, each starting and ending with the same job
+func dependencyCycles(jobs []Job, deps map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	cycles := [][]string{}
+	state := map[string]int{}
+	path := []string{}
+	var visit func(job string)
+	visit = func(job string) {
+		state[job] = visiting
+		path = append(path, job)
+		for _, dep := range deps[job] {
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				for i, j := range path {
+					if j == dep {
+						cycles = append(cycles, append(append([]string{}, path[i:]...), dep))
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[job] = visited
+	}
+
+	for _, job := range jobs {
+		if state[job.GetName()] == unvisited {
+			visit(job.GetName())
+		}
+	}
+
+	return cycles
+}
+
+func validPolicy(policy FailurePolicy) bool {
+	switch policy {
+	case FailFast, FinishRunning, RunAll:
+		return true
+	}
+
+	return false
+}
+
+func (j *JobImpl) validate(v *validation) []string {
+	problems := []string{}
+	what := fmt.Sprintf("job %s", j.Name)
+
+	if len(strings.TrimSpace(j.ImageRef)) == 0 {
+		problems = append(problems, fmt.Sprintf("%s has no image", what))
+	} else {
+		image, p := v.references(what+" image", j.ImageRef)
+		problems = append(problems, p...)
+		if len(p) == 0 {
+			if _, err := reference.ParseNormalizedNamed(image); err != nil {
+				problems = append(problems, fmt.Sprintf("%s has an invalid image ref %q: %s", what, j.ImageRef, err.Error()))
+			}
+		}
+	}
+
+	if len(j.Steps) == 0 {
+		problems = append(problems, fmt.Sprintf("%s has no steps", what))
+	}
+	// steps and the artifacts collected after them also see the variables set by earlier steps
+	afterSteps := v.with(j.SetsVariables)
+	names := map[string]bool{}
+	for i, s := range j.Steps {
+		switch {
+		case len(s.GetName()) == 0:
+			problems = append(problems, fmt.Sprintf("step %d of %s has no name", i+1, what))
+		case names[s.GetName()]:
+			problems = append(problems, fmt.Sprintf("step name %s is used more than once in %s", s.GetName(), what))
+		}
+		names[s.GetName()] = true
+
+		if si, ok := s.(*StepImpl); ok {
+			problems = append(problems, afterSteps.env(fmt.Sprintf("step %s of %s", s.GetName(), what), Env{Vars: si.Env})...)
+		}
+	}
+
+	if !validPolicy(j.FailurePolicy) {
+		problems = append(problems, fmt.Sprintf("%s has an unknown failure policy %s", what, j.FailurePolicy))
+	}
+	problems = append(problems, v.env(what, j.Env)...)
+
+	problems = append(problems, v.secrets(what, j.SecretEnv, j.SecretFiles)...)
+
+	for _, name := range sortedKeys(j.ArtifactPaths) {
+		for _, glob := range j.ArtifactPaths[name] {
+			_, p := afterSteps.references(fmt.Sprintf("%s artifact %s", what, name), glob)
+			problems = append(problems, p...)
+		}
+	}
+	for _, name := range sortedKeys(j.ArtifactInputs) {
+		_, p := v.references(fmt.Sprintf("%s artifact input %s", what, name), j.ArtifactInputs[name])
+		problems = append(problems, p...)
+	}
+
+	if j.Workspace != nil && len(j.Workspace.HostDir) == 0 {
+		problems = append(problems, fmt.Sprintf("%s has a workspace without a host directory", what))
+	}
+
+	return problems
+}
+
+// the nested pipeline is checked with the variables it will see, its problems are prefixed with its name
+func (pj *PipelineJob) validate(v *validation) []string {
+	problems := []string{}
+	if pj.err != nil {
+		problems = append(problems, pj.err.Error())
+	}
+
+	scoped := pj.scopeVariables(v.variables)
+	set := maps.Clone(v.set)
+	for name := range v.set {
+		if unprefixed, ok := strings.CutPrefix(name, pj.Prefix); ok && len(pj.Prefix) > 0 {
+			set[unprefixed] = true
+		}
+	}
+	for _, p := range pj.pipeline.validate(scoped, set) {
+		problems = append(problems, fmt.Sprintf("%s: %s", pj.GetName(), p))
+	}
+
+	// set on the nested jobs only once they run
+	nested := &validation{variables: scoped, earlier: map[string]bool{}, set: set}
+	problems = append(problems, nested.secrets(pj.GetName(), pj.SecretEnv, pj.SecretFiles)...)
+	if pj.Workspace != nil && len(pj.Workspace.HostDir) == 0 {
+		problems = append(problems, fmt.Sprintf("nested pipeline %s has a workspace without a host directory", pj.GetName()))
+	}
+
+	return problems
+}
+
+func (g *ApprovalGate) validate(v *validation) []string {
+	problems := []string{}
+	if g.err != nil {
+		problems = append(problems, g.err.Error())
+	}
+	if g.Approver == nil {
+		problems = append(problems, fmt.Sprintf("approval gate %s has no approver", g.Name))
+	}
+	if g.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("approval gate %s has a negative timeout", g.Name))
+	}
+
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedValues(m map[string]string) []string {
+	values := []string{}
+	for _, k := range sortedKeys(m) {
+		values = append(values, m[k])
+	}
+
+	return values
+}
@@ -0,0 +1,290 @@
+package anypipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	t.Setenv("ANYPIPE_TEST_REGISTRY", "registry.example.com")
+
+	type testcase struct {
+		name      string
+		pipeline  func() *AnypipeImpl
+		variables map[string]interface{}
+		expected  []string
+	}
+
+	testcases := []testcase{
+		{
+			name: "valid pipeline",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithSemaphore("deploy", 1).
+					WithSequentialJobs(NewJobImpl("build", "golang:1.22").
+						WithStep("compile", passingStep).
+						WithArtifacts("bin", "dist/*").
+						WithEnv("VERSION", "${{ vars.version }}").
+						WithSecretEnv("TOKEN", "token")).
+					WithSequentialJobs(NewJobImpl("deploy", "${{ env.ANYPIPE_TEST_REGISTRY }}/app:${{ jobs.build.outputs.version }}").
+						WithStep("deploy", passingStep).
+						WithArtifactInput("bin", "/app").
+						WithSemaphores("deploy"))
+				return p
+			},
+			variables: map[string]interface{}{"version": "1.0.0", "token": NewSecret("hunter2")},
+		},
+		{
+			name: "no jobs",
+			pipeline: func() *AnypipeImpl {
+				return testPipeline()
+			},
+			expected: []string{"pipeline has no jobs"},
+		},
+		{
+			name: "duplicate names",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithParallelJobs(
+					NewJobImpl("test", "golang:1.22").WithStep("unit", passingStep).WithStep("unit", passingStep),
+					NewJobImpl("test", "golang:1.22").WithStep("unit", passingStep),
+				)
+				return p
+			},
+			expected: []string{
+				"job name test is used more than once",
+				"step name unit is used more than once in job test",
+			},
+		},
+		{
+			name: "images and steps",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithParallelJobs(
+					NewJobImpl("empty", "").WithStep("step", passingStep),
+					NewJobImpl("invalid", "Golang:1.22").WithStep("step", passingStep),
+					NewJobImpl("nosteps", "golang:1.22"),
+				)
+				return p
+			},
+			expected: []string{
+				"job empty has no image",
+				`job invalid has an invalid image ref "Golang:1.22"`,
+				"job nosteps has no steps",
+			},
+		},
+		{
+			name: "undefined references and inputs",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithInputs("registry").
+					WithEnv("CI", "${{ vars.ci").
+					WithSequentialJobs(NewJobImpl("build", "golang:${{ vars.go_version }}").
+						WithStep("compile", passingStep).
+						WithSecretEnv("TOKEN", "token").
+						WithArtifacts("bin", "${{ secrets.path }}").
+						WithEnvFile("/does/not/exist.env")).
+					WithSequentialJobs(NewJobImpl("test", "golang:${{ jobs.lint.outputs.version }}").
+						WithSteps(NewStepImpl("unit", passingStep).WithEnv("FLAGS", "${{ foo.bar }}")))
+				return p
+			},
+			expected: []string{
+				"required input registry is not set",
+				`pipeline env variable CI: unterminated reference in "${{ vars.ci"`,
+				"job build image: variable go_version is not set",
+				"job build: open /does/not/exist.env",
+				"job build: secret variable token is not set",
+				"job build artifact bin: secret path is not set",
+				"job test image: references outputs of job lint, which does not run in an earlier stage",
+				"step unit of job test env variable FLAGS: unknown reference foo.bar",
+			},
+		},
+		{
+			name: "variables set at runtime",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithInputs("go_version").
+					WithParallelJobs(
+						NewJobImpl("build", "golang:${{ vars.go_version }}").
+							WithSteps(
+								NewStepImpl("version", passingStep),
+								NewStepImpl("package", passingStep).WithEnv("VERSION", "${{ vars.version }}"),
+							).
+							WithArtifacts("bin", "dist/app-${{ vars.version }}").
+							WithSetsVariables("version"),
+						// runs alongside build, the version isn't set yet
+						NewJobImpl("lint", "golang:${{ vars.go_version }}").
+							WithStep("vet", passingStep).
+							WithEnv("VERSION", "${{ vars.version }}"),
+					).
+					WithSequentialJobs(NewJobImpl("release", "alpine:${{ vars.version }}").
+						WithStep("publish", passingStep).
+						WithEnv("VERSION", "${{ vars.version }}").
+						WithEnv("BUILD", "${{ vars.build_id }}").
+						WithEnv("TOKEN", "${{ vars.token }}"))
+				return p
+			},
+			variables: map[string]interface{}{"go_version": "1.22", "token": NewSecret("t0k3n")},
+			expected: []string{
+				"job lint env variable VERSION: variable version is not set",
+				"job release env variable BUILD: variable build_id is not set",
+				"job release env variable TOKEN: variable token is a secret, reference it as secrets.token",
+			},
+		},
+		{
+			name: "secrets set at runtime",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithParallelJobs(
+					NewJobImpl("login", "alpine").WithStep("login", passingStep).WithSetsVariables("token"),
+					// runs alongside login, the secret isn't set yet
+					NewJobImpl("lint", "alpine").WithStep("lint", passingStep).WithSecretEnv("TOKEN", "token"),
+				).
+					WithSequentialJobs(NewJobImpl("deploy", "alpine").
+						WithStep("deploy", passingStep).
+						WithSecretEnv("TOKEN", "token").
+						WithSecretFile("key", "key").
+						WithEnv("AUTH", "Bearer ${{ secrets.token }}"))
+				return p
+			},
+			expected: []string{
+				"job lint: secret variable token is not set",
+				"job deploy: secret variable key is not set",
+			},
+		},
+		{
+			name: "variables set across nested pipelines",
+			pipeline: func() *AnypipeImpl {
+				inner := testPipeline()
+				inner.Name = "inner"
+				inner.WithInputs("version").
+					WithSequentialJobs(NewJobImpl("package", "alpine").
+						WithStep("package", passingStep).
+						WithEnv("VERSION", "${{ vars.version }}").
+						WithSetsVariables("digest"))
+
+				p := testPipeline()
+				p.WithSequentialJobs(NewJobImpl("version", "alpine").WithStep("version", passingStep).WithSetsVariables("inner.version")).
+					WithSequentialJobs(NewPipelineJob(inner, "inner.").WithSetsVariables("digest")).
+					WithSequentialJobs(NewJobImpl("deploy", "alpine").
+						WithStep("deploy", passingStep).
+						WithEnv("DIGEST", "${{ vars.inner.digest }}"))
+				return p
+			},
+			expected: []string{
+				"WithSetsVariables is not supported by nested pipeline inner",
+			},
+		},
+		{
+			name: "artifacts",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithParallelJobs(
+					NewJobImpl("a", "alpine").WithStep("step", passingStep).WithArtifacts("x", "x").WithArtifactInput("y", "/y"),
+					NewJobImpl("b", "alpine").WithStep("step", passingStep).WithArtifacts("y", "y").WithArtifactInput("x", "/x"),
+					NewJobImpl("c", "alpine").WithStep("step", passingStep).WithArtifacts("x", "x").WithArtifactInput("z", "/z"),
+				).WithSequentialJobs(
+					NewJobImpl("d", "alpine").WithStep("step", passingStep).WithArtifacts("w", "w"),
+				)
+				p.Jobs[0].WithArtifactInput("w", "/w")
+				return p
+			},
+			expected: []string{
+				"artifact x is produced by both job a and job c",
+				"job c needs artifact z, which no job produces",
+				"dependency cycle: a -> b -> a",
+				"job a needs an artifact of job d, which does not run in an earlier stage",
+			},
+		},
+		{
+			name: "never scheduled",
+			pipeline: func() *AnypipeImpl {
+				p := testPipeline()
+				p.WithResourceBudget(2, 0).WithSemaphore("deploy", 0).WithFailurePolicy("sometimes").
+					WithSequentialJobs(
+						NewJobImpl("big", "alpine").WithStep("step", passingStep).WithResources(4, 0),
+						NewJobImpl("deploy", "alpine").WithStep("step", passingStep).WithSemaphores("deploy"),
+					)
+				return p
+			},
+			expected: []string{
+				"unknown failure policy sometimes",
+				"job big requests 4 CPUs, 0 B memory, more than the budget of 2 CPUs, 0 B memory, it can never run",
+				"job deploy needs semaphore deploy, which has no capacity, it can never run",
+			},
+		},
+		{
+			name: "nested pipelines and approval gates",
+			pipeline: func() *AnypipeImpl {
+				inner := testPipeline()
+				inner.Name = "inner"
+				inner.WithSequentialJobs(NewJobImpl("build", "${{ vars.registry }}/golang:${{ vars.go_version }}").WithStep("step", passingStep))
+
+				p := testPipeline()
+				p.WithSequentialJobs(
+					NewPipelineJob(inner, "inner.").WithStep("step", passingStep).WithSecretEnv("TOKEN", "token"),
+					NewApprovalGate("approve", "ship it?", nil, 0),
+				)
+				return p
+			},
+			variables: map[string]interface{}{"go_version": "1.22"},
+			expected: []string{
+				"WithStep is not supported by nested pipeline inner",
+				"inner: job build image: variable registry is not set",
+				"inner: secret variable token is not set",
+				"approval gate approve has no approver",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			variables := tc.variables
+			if variables == nil {
+				variables = map[string]interface{}{}
+			}
+
+			err := tc.pipeline().Validate(variables)
+			if len(tc.expected) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var ve *ValidationError
+			assert.ErrorAs(t, err, &ve)
+			assert.Equal(t, "test pipeline", ve.Pipeline)
+			assert.Len(t, ve.Problems, len(tc.expected), ve.Problems)
+			for i, expected := range tc.expected {
+				if i < len(ve.Problems) {
+					assert.Contains(t, ve.Problems[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestRunValidatesFirst(t *testing.T) {
+	p := testPipeline()
+	p.WithSequentialJobs(NewJobImpl("build", "").WithStep("compile", passingStep))
+
+	// fails before connecting to docker
+	err := p.Run(map[string]interface{}{})
+
+	var ve *ValidationError
+	assert.True(t, errors.As(err, &ve))
+	assert.Equal(t, "pipeline test pipeline is invalid:\n  job build has no image", err.Error())
+	assert.Empty(t, p.Results)
+}
+
+func TestValidateApprovalGate(t *testing.T) {
+	p := testPipeline()
+	p.WithSequentialJobs(NewApprovalGate("approve", "ship it?", approval.ApproverFunc(func(ctx context.Context, r approval.Request) (approval.Decision, error) {
+		return approval.Decision{Approved: true}, nil
+	}), 0))
+
+	assert.NoError(t, p.Validate(map[string]interface{}{}))
+}
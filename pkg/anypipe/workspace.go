@@ -0,0 +1,224 @@
+package anypipe
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// DefaultWorkspacePath is where the workspace is mounted when Workspace.Path is empty
+const DefaultWorkspacePath = "/workspace"
+
+type WorkspaceMode string
+
+const (
+	// bind-mount the workspace, copying it instead if the container can't be created with the mount,
+	// e.g. because bind mounts are forbidden by policy
+	WorkspaceAuto WorkspaceMode = "auto"
+	// always bind-mount the workspace
+	WorkspaceBind WorkspaceMode = "bind"
+	// copy the workspace into the container before the steps, and back to the host after them unless read-only
+	WorkspaceCopy WorkspaceMode = "copy"
+)
+
+// Workspace is a host directory shared with a job's container
+type Workspace struct {
+	// directory on the host, relative paths are resolved from the working directory
+	HostDir string
+	// path in the container, defaults to DefaultWorkspacePath
+	Path     string
+	ReadOnly bool
+	// defaults to WorkspaceAuto
+	Mode WorkspaceMode
+}
+
+func (ws Workspace) path() string {
+	if len(ws.Path) == 0 {
+		return DefaultWorkspacePath
+	}
+
+	return ws.Path
+}
+
+// creates the job's container, with the workspace bind-mounted or copied in. returns whether it was copied
+func (j *JobImpl) createContainer(log *slog.Logger, du dockerutils.DockerUtils) (*dockerutils.Container, bool, error) {
+	opts := j.containerOptions()
+	ws := j.Workspace
+	if ws == nil {
+		c, err := du.CreateContainerWithOptions(j.image, opts)
+		return c, false, err
+	}
+
+	hostDir, err := filepath.Abs(ws.HostDir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var bindErr error
+	if ws.Mode != WorkspaceCopy {
+		bindOpts := opts
+		bindOpts.Binds = append([]dockerutils.Bind{}, opts.Binds...)
+		bindOpts.Binds = append(bindOpts.Binds, dockerutils.Bind{Source: hostDir, Target: ws.path(), ReadOnly: ws.ReadOnly})
+
+		c, err := du.CreateContainerWithOptions(j.image, bindOpts)
+		if err == nil || ws.Mode == WorkspaceBind {
+			return c, false, err
+		}
+		bindErr = err
+	}
+
+	// when this fails too, the bind mount wasn't the problem, e.g. the image doesn't exist
+	c, err := du.CreateContainerWithOptions(j.image, opts)
+	if err != nil {
+		return c, true, err
+	}
+	if bindErr != nil {
+		log.Warn(fmt.Sprintf("failed to bind-mount workspace %s, copying it instead: %s", hostDir, bindErr.Error()))
+	}
+
+	_, stderr, ec, err := du.Exec(c, fmt.Sprintf("mkdir -p %s", ShellQuote(ws.path())))
+	if err != nil {
+		return c, true, err
+	}
+	if ec != 0 {
+		return c, true, fmt.Errorf("failed to create workspace %s: %s", ws.path(), stderr)
+	}
+	if err := du.CopyTo(c, hostDir, ws.path()); err != nil {
+		return c, true, fmt.Errorf("failed to copy workspace %s: %w", hostDir, err)
+	}
+
+	return c, true, nil
+}
+
+// the user owning the files the job creates in a bind-mounted workspace
+var hostUser = func() (uid, gid int) {
+	return os.Getuid(), os.Getgid()
+}
+
+// paths given to chown at once, keeping the command well below the argument size limit
+const chownBatch = 256
+
+// lists the paths in the host directory of a writable workspace, relative to it and slash-separated, so files
+// the job creates can be told apart from those that were there before. nil if there is no such workspace
+func (j *JobImpl) workspacePaths() (map[string]bool, error) {
+	ws := j.Workspace
+	if ws == nil || ws.ReadOnly {
+		return nil, nil
+	}
+
+	hostDir, err := filepath.Abs(ws.HostDir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	err = filepath.WalkDir(hostDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// unreadable directories are left as they are
+			if d != nil && d.IsDir() && p != hostDir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		rel, err := filepath.Rel(hostDir, p)
+		if err != nil {
+			return err
+		}
+		paths[filepath.ToSlash(rel)] = true
+		return nil
+	})
+
+	return paths, err
+}
+
+// returns the container paths of the files and directories created in the workspace since existing was listed.
+// directories are listed without their contents, which are new as well
+func (j *JobImpl) createdWorkspacePaths(existing map[string]bool) ([]string, error) {
+	hostDir, err := filepath.Abs(j.Workspace.HostDir)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []string{}
+	err = filepath.WalkDir(hostDir, func(p string, d fs.DirEntry, err error) error {
+		rel, rerr := filepath.Rel(hostDir, p)
+		if rerr != nil {
+			return rerr
+		}
+		rel = filepath.ToSlash(rel)
+		if !existing[rel] {
+			created = append(created, path.Join(j.Workspace.path(), rel))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			if d != nil && d.IsDir() && p != hostDir {
+				return fs.SkipDir
+			}
+			return err
+		}
+		return nil
+	})
+
+	return created, err
+}
+
+// makes the changes of the steps to a writable workspace visible on the host. a copied workspace is copied
+// back, and the files the job created in a bind-mounted one are given to the host user, as the container usually
+// runs as root. files that were there before keep their owner
+func (j *JobImpl) syncWorkspace(log *slog.Logger, du dockerutils.DockerUtils, c *dockerutils.Container, copied bool, existing map[string]bool) error {
+	ws := j.Workspace
+	if ws == nil || ws.ReadOnly {
+		return nil
+	}
+
+	if copied {
+		hostDir, err := filepath.Abs(ws.HostDir)
+		if err != nil {
+			return err
+		}
+
+		// the trailing /. copies the contents of the directory rather than the directory itself
+		if err := du.CopyFrom(c, strings.TrimSuffix(ws.path(), "/")+"/.", hostDir); err != nil {
+			return fmt.Errorf("failed to copy workspace back to %s: %w", hostDir, err)
+		}
+		return nil
+	}
+
+	// uid and gid are -1 on windows, where docker desktop maps ownership itself
+	uid, gid := hostUser()
+	if uid <= 0 || existing == nil {
+		return nil
+	}
+
+	created, err := j.createdWorkspacePaths(existing)
+	for len(created) > 0 && err == nil {
+		batch := created[:min(chownBatch, len(created))]
+		created = created[len(batch):]
+
+		quoted := []string{}
+		for _, p := range batch {
+			quoted = append(quoted, ShellQuote(p))
+		}
+		var stderr string
+		var ec int
+		_, stderr, ec, err = du.Exec(c, fmt.Sprintf("chown -R %d:%d -- %s", uid, gid, strings.Join(quoted, " ")))
+		if err == nil && ec != 0 {
+			err = errors.New(stderr)
+		}
+	}
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to give the files created in workspace %s to the host user: %s", ws.HostDir, err.Error()))
+	}
+
+	return nil
+}
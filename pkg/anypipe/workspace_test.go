@@ -0,0 +1,138 @@
+package anypipe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestWorkspace(t *testing.T) {
+	type testcase struct {
+		name        string
+		ws          Workspace
+		bindErr     error
+		createErr   error
+		expectCopy  bool
+		expectSync  bool
+		expectedErr bool
+	}
+
+	testcases := []testcase{
+		{
+			name:       "bind-mounts the workspace",
+			ws:         Workspace{HostDir: "."},
+			expectSync: true,
+		},
+		{
+			name:       "bind-mounts a workspace with a custom path",
+			ws:         Workspace{HostDir: ".", Path: "/src/it's here"},
+			expectSync: true,
+		},
+		{
+			name: "bind-mounts a read-only workspace",
+			ws:   Workspace{HostDir: ".", Path: "/src", ReadOnly: true},
+		},
+		{
+			name:       "falls back to copying the workspace",
+			ws:         Workspace{HostDir: "."},
+			bindErr:    errors.New("bind mounts are not allowed"),
+			expectCopy: true,
+			expectSync: true,
+		},
+		{
+			name:        "does not blame the bind mount for other errors",
+			ws:          Workspace{HostDir: "."},
+			bindErr:     errors.New("no such image"),
+			createErr:   errors.New("no such image"),
+			expectedErr: true,
+		},
+		{
+			name:        "fails when bind mounts are required",
+			ws:          Workspace{HostDir: ".", Mode: WorkspaceBind},
+			bindErr:     errors.New("bind mounts are not allowed"),
+			expectedErr: true,
+		},
+		{
+			name:       "copies a read-only workspace in only",
+			ws:         Workspace{HostDir: ".", Mode: WorkspaceCopy, ReadOnly: true},
+			expectCopy: true,
+		},
+	}
+
+	defer func(f func() (int, int)) { hostUser = f }(hostUser)
+	hostUser = func() (int, int) { return 1000, 1000 }
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// the host directory has files of its own, the step adds some through the bind mount
+			hostDir := t.TempDir()
+			assert.NoError(t, os.MkdirAll(filepath.Join(hostDir, "src"), 0755))
+			assert.NoError(t, os.WriteFile(filepath.Join(hostDir, "src", "main.go"), []byte("package main"), 0644))
+			tc.ws.HostDir = hostDir
+			path := tc.ws.path()
+
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			if tc.ws.Mode != WorkspaceCopy {
+				du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{
+					Binds: []dockerutils.Bind{{Source: hostDir, Target: path, ReadOnly: tc.ws.ReadOnly}},
+				}).Return(c, tc.bindErr)
+			}
+			if tc.createErr != nil {
+				du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Return(nil, tc.createErr)
+			}
+			if tc.expectCopy {
+				du.EXPECT().CreateContainerWithOptions("testimage:latest", dockerutils.ContainerOptions{}).Return(c, nil)
+				du.EXPECT().Exec(c, fmt.Sprintf("mkdir -p '%s'", path)).Return("", "", 0, nil)
+				du.EXPECT().CopyTo(c, hostDir, path).Return(nil)
+			}
+			if tc.expectSync && tc.expectCopy {
+				du.EXPECT().CopyFrom(c, path+"/.", hostDir).Return(nil)
+			}
+			if tc.expectSync && !tc.expectCopy {
+				du.EXPECT().Exec(c, fmt.Sprintf("chown -R 1000:1000 -- %s %s %s",
+					ShellQuote(path+"/bin"), ShellQuote(path+"/src/gen.go"), ShellQuote(path+"/version.txt"))).Return("", "", 0, nil)
+			}
+
+			writes := func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+				if tc.ws.ReadOnly || tc.expectCopy {
+					return nil
+				}
+				assert.NoError(t, os.MkdirAll(filepath.Join(hostDir, "bin", "linux"), 0755))
+				assert.NoError(t, os.WriteFile(filepath.Join(hostDir, "bin", "linux", "app"), []byte("binary"), 0755))
+				assert.NoError(t, os.WriteFile(filepath.Join(hostDir, "src", "gen.go"), []byte("package main"), 0644))
+				assert.NoError(t, os.WriteFile(filepath.Join(hostDir, "src", "main.go"), []byte("package main // changed"), 0644))
+				return os.WriteFile(filepath.Join(hostDir, "version.txt"), []byte("1.2.3"), 0644)
+			}
+
+			logs := &bytes.Buffer{}
+			pipeline := testPipeline()
+			pipeline.log = slog.New(slog.NewTextHandler(logs, nil))
+			pipeline.WithSequentialJobs(NewJobImpl("job", "testimage:latest").WithWorkspace(tc.ws).WithStep("step", writes))
+
+			err := pipeline.run(du, map[string]interface{}{})
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			// only a failed bind mount is reported as such
+			if tc.bindErr != nil && tc.expectCopy {
+				assert.Contains(t, logs.String(), "failed to bind-mount workspace")
+			} else {
+				assert.NotContains(t, logs.String(), "failed to bind-mount workspace")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package approval provides the approvers deciding whether a pipeline may continue past an approval gate
+package approval
+
+import (
+	"context"
+	"errors"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// ErrNoTerminal is returned by the TTY approver when stdin is not a terminal
+var ErrNoTerminal = errors.New("stdin is not a terminal")
+
+// Request describes what is waiting for approval
+type Request struct {
+	Job     string
+	Message string
+}
+
+// Decision is the outcome of a request
+type Decision struct {
+	Approved bool
+	// who approved or rejected the request
+	Approver string
+	Comment  string
+	Time     time.Time
+}
+
+// Approver waits for a request to be approved or rejected. Await returns an error if no decision was made,
+// e.g. because ctx is done
+type Approver interface {
+	Await(ctx context.Context, req Request) (Decision, error)
+}
+
+// the name of the user running the pipeline
+func currentUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+
+	return u.Username
+}
+
+// turns a job name into a file name, e.g. "deploy prod" into "deploy-prod"
+func safeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// ApproverFunc adapts a function to the Approver interface
+type ApproverFunc func(ctx context.Context, req Request) (Decision, error)
+
+func (f ApproverFunc) Await(ctx context.Context, req Request) (Decision, error) {
+	return f(ctx, req)
+}
@@ -0,0 +1,236 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTYApprover(t *testing.T) {
+	type testcase struct {
+		name             string
+		input            string
+		expectedApproved bool
+		expectedComment  string
+	}
+
+	testcases := []testcase{
+		{name: "approves on y", input: "y\n", expectedApproved: true},
+		{name: "approves on yes with a comment", input: "YES looks good\n", expectedApproved: true, expectedComment: "looks good"},
+		{name: "rejects on n", input: "n\n"},
+		{name: "rejects on an empty answer", input: "\n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			a := &TTYApprover{In: strings.NewReader(tc.input), Out: out}
+
+			d, err := a.Await(context.Background(), Request{Job: "deploy", Message: "deploying v1.2.3"})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedApproved, d.Approved)
+			assert.Equal(t, tc.expectedComment, d.Comment)
+			assert.NotEmpty(t, d.Approver)
+			assert.Equal(t, "deploying v1.2.3\napprove deploy? [y/N] ", out.String())
+		})
+	}
+
+	t.Run("stops waiting once ctx is done", func(t *testing.T) {
+		r, w := io.Pipe()
+		defer w.Close()
+		a := &TTYApprover{In: r, Out: io.Discard}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := a.Await(ctx, Request{Job: "deploy"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("requires a terminal", func(t *testing.T) {
+		f, err := os.CreateTemp(t.TempDir(), "stdin")
+		assert.NoError(t, err)
+		defer f.Close()
+
+		a := &TTYApprover{In: f, Out: io.Discard, requireTerminal: true}
+		_, err = a.Await(context.Background(), Request{Job: "deploy"})
+		assert.ErrorIs(t, err, ErrNoTerminal)
+	})
+}
+
+func TestFileApprover(t *testing.T) {
+	type testcase struct {
+		name             string
+		markers          map[string]string
+		expectedApproved bool
+		expectedApprover string
+		expectedComment  string
+	}
+
+	testcases := []testcase{
+		{
+			name:             "approves on an approve marker",
+			markers:          map[string]string{"deploy-prod.approve": "alice\nship it\n"},
+			expectedApproved: true,
+			expectedApprover: "alice",
+			expectedComment:  "ship it",
+		},
+		{
+			name:             "rejects on a reject marker",
+			markers:          map[string]string{"deploy-prod.reject": "bob"},
+			expectedApprover: "bob",
+		},
+		{
+			name:             "a rejection wins",
+			markers:          map[string]string{"deploy-prod.approve": "alice", "deploy-prod.reject": "bob"},
+			expectedApprover: "bob",
+		},
+		{
+			name:             "an empty marker has an unknown approver",
+			markers:          map[string]string{"deploy-prod.approve": ""},
+			expectedApproved: true,
+			expectedApprover: "unknown",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := &FileApprover{Dir: dir, PollInterval: 5 * time.Millisecond}
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				for name, content := range tc.markers {
+					_ = os.WriteFile(filepath.Join(dir, name+".tmp"), []byte(content), 0o644)
+				}
+				// renamed so the approver never sees a partially written marker
+				for name := range tc.markers {
+					_ = os.Rename(filepath.Join(dir, name+".tmp"), filepath.Join(dir, name))
+				}
+			}()
+
+			d, err := a.Await(context.Background(), Request{Job: "deploy prod"})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedApproved, d.Approved)
+			assert.Equal(t, tc.expectedApprover, d.Approver)
+			assert.Equal(t, tc.expectedComment, d.Comment)
+			if d.Approved {
+				assert.NoFileExists(t, a.ApprovePath("deploy prod"))
+			} else {
+				assert.NoFileExists(t, a.RejectPath("deploy prod"))
+			}
+		})
+	}
+
+	t.Run("stops waiting once ctx is done", func(t *testing.T) {
+		a := &FileApprover{Dir: t.TempDir(), PollInterval: 5 * time.Millisecond}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := a.Await(ctx, Request{Job: "deploy"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestHTTPApprover(t *testing.T) {
+	type testcase struct {
+		name             string
+		path             string
+		form             url.Values
+		basicAuth        string
+		token            string
+		expectedStatus   int
+		expectedApproved bool
+		expectedApprover string
+	}
+
+	testcases := []testcase{
+		{
+			name:             "approves",
+			path:             "/approve",
+			form:             url.Values{"approver": {"alice"}, "comment": {"ship it"}},
+			expectedStatus:   http.StatusOK,
+			expectedApproved: true,
+			expectedApprover: "alice",
+		},
+		{
+			name:             "rejects with the basic auth user as approver",
+			path:             "/reject",
+			basicAuth:        "bob",
+			expectedStatus:   http.StatusOK,
+			expectedApprover: "bob",
+		},
+		{
+			name:           "requires an approver",
+			path:           "/approve",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "requires the token",
+			path:           "/approve",
+			form:           url.Values{"approver": {"alice"}},
+			token:          "secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			urls := make(chan string, 1)
+			a := &HTTPApprover{Addr: "127.0.0.1:0", Token: tc.token, Listening: func(url string) { urls <- url }}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			type result struct {
+				d   Decision
+				err error
+			}
+			results := make(chan result, 1)
+			go func() {
+				d, err := a.Await(ctx, Request{Job: "deploy", Message: "deploying v1.2.3"})
+				results <- result{d, err}
+			}()
+			base := <-urls
+
+			resp, err := http.Get(base + "/")
+			assert.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if len(tc.token) == 0 {
+				assert.JSONEq(t, `{"Job":"deploy","Message":"deploying v1.2.3"}`, string(body))
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, base+tc.path, strings.NewReader(tc.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if len(tc.basicAuth) > 0 {
+				req.SetBasicAuth(tc.basicAuth, "")
+			}
+			resp, err = http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if resp.StatusCode != http.StatusOK {
+				cancel()
+				r := <-results
+				assert.True(t, errors.Is(r.err, context.Canceled))
+				return
+			}
+
+			r := <-results
+			assert.NoError(t, r.err)
+			assert.Equal(t, tc.expectedApproved, r.d.Approved)
+			assert.Equal(t, tc.expectedApprover, r.d.Approver)
+		})
+	}
+}
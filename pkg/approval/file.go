@@ -0,0 +1,93 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	approveExt = ".approve"
+	rejectExt  = ".reject"
+)
+
+// FileApprover waits for a marker file to appear in a directory: <job>.approve or <job>.reject, with the job
+// name's special characters replaced by dashes. the first line of the file names the approver, and the
+// rest is kept as comment. markers are removed once read, so the next run waits again
+type FileApprover struct {
+	Dir string
+	// how often the directory is checked, defaults to a second
+	PollInterval time.Duration
+}
+
+func NewFileApprover(dir string) *FileApprover {
+	return &FileApprover{Dir: dir, PollInterval: time.Second}
+}
+
+// path of the marker approving the job
+func (a *FileApprover) ApprovePath(job string) string {
+	return filepath.Join(a.Dir, safeName(job)+approveExt)
+}
+
+// path of the marker rejecting the job
+func (a *FileApprover) RejectPath(job string) string {
+	return filepath.Join(a.Dir, safeName(job)+rejectExt)
+}
+
+func (a *FileApprover) Await(ctx context.Context, req Request) (Decision, error) {
+	interval := a.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a rejection wins over an approval
+		for _, marker := range []struct {
+			path     string
+			approved bool
+		}{{a.RejectPath(req.Job), false}, {a.ApprovePath(req.Job), true}} {
+			d, found, err := readMarker(marker.path)
+			if err != nil {
+				return Decision{}, err
+			}
+			if found {
+				d.Approved = marker.approved
+				return d, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return Decision{}, context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+func readMarker(path string) (Decision, bool, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Decision{}, false, nil
+	}
+	if err != nil {
+		return Decision{}, false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return Decision{}, false, err
+	}
+
+	approver, comment, _ := strings.Cut(strings.TrimSpace(string(content)), "\n")
+	approver = strings.TrimSpace(approver)
+	if len(approver) == 0 {
+		approver = "unknown"
+	}
+
+	return Decision{Approver: approver, Comment: strings.TrimSpace(comment), Time: time.Now()}, true, nil
+}
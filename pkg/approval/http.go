@@ -0,0 +1,114 @@
+package approval
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPApprover serves a local endpoint while waiting for a decision:
+//
+//	GET  /        returns the pending request as JSON
+//	POST /approve approves it
+//	POST /reject  rejects it
+//
+// the approver is taken from the "approver" form value, or else from the basic auth user name, and the
+// comment from the "comment" form value
+type HTTPApprover struct {
+	// address to listen on, e.g. 127.0.0.1:8080. a random port is picked when the port is 0
+	Addr string
+	// if set, requests must carry it as bearer token
+	Token string
+	// called with the URL of the endpoint once it is listening, e.g. to print where to approve
+	Listening func(url string)
+}
+
+func NewHTTPApprover(addr string) *HTTPApprover {
+	return &HTTPApprover{Addr: addr}
+}
+
+func (a *HTTPApprover) Await(ctx context.Context, req Request) (Decision, error) {
+	ln, err := net.Listen("tcp", a.Addr)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decisions := make(chan Decision, 1)
+	srv := &http.Server{Handler: a.handler(req, decisions), ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	if a.Listening != nil {
+		a.Listening(fmt.Sprintf("http://%s", ln.Addr().String()))
+	}
+
+	select {
+	case <-ctx.Done():
+		return Decision{}, context.Cause(ctx)
+	case d := <-decisions:
+		return d, nil
+	}
+}
+
+func (a *HTTPApprover) authorized(r *http.Request) bool {
+	if len(a.Token) == 0 {
+		return true
+	}
+
+	expected := []byte("Bearer " + a.Token)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
+
+func (a *HTTPApprover) handler(req Request, decisions chan<- Decision) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(req)
+	})
+
+	decide := func(approved bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !a.authorized(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			d := Decision{Approved: approved, Approver: r.FormValue("approver"), Comment: r.FormValue("comment"), Time: time.Now()}
+			if len(d.Approver) == 0 {
+				d.Approver, _, _ = r.BasicAuth()
+			}
+			if len(d.Approver) == 0 {
+				http.Error(w, "missing approver", http.StatusBadRequest)
+				return
+			}
+
+			select {
+			case decisions <- d:
+				fmt.Fprintf(w, "%s %s\n", req.Job, verdict(approved))
+			default:
+				http.Error(w, "already decided", http.StatusConflict)
+			}
+		}
+	}
+	mux.HandleFunc("POST /approve", decide(true))
+	mux.HandleFunc("POST /reject", decide(false))
+
+	return mux
+}
+
+func verdict(approved bool) string {
+	if approved {
+		return "approved"
+	}
+
+	return "rejected"
+}
@@ -0,0 +1,71 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TTYApprover asks for a decision on an interactive prompt, the approver is the user running the pipeline
+type TTYApprover struct {
+	In  io.Reader
+	Out io.Writer
+	// checks In is a terminal, set for os.Stdin by NewTTYApprover
+	requireTerminal bool
+}
+
+// prompts on stdin and stderr, failing if stdin is not a terminal, e.g. in CI
+func NewTTYApprover() *TTYApprover {
+	return &TTYApprover{In: os.Stdin, Out: os.Stderr, requireTerminal: true}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// anything but y or yes rejects the request, the rest of the answer after a space is kept as comment
+func (a *TTYApprover) Await(ctx context.Context, req Request) (Decision, error) {
+	if f, ok := a.In.(*os.File); ok && a.requireTerminal && !isTerminal(f) {
+		return Decision{}, ErrNoTerminal
+	}
+
+	if len(req.Message) > 0 {
+		fmt.Fprintln(a.Out, req.Message)
+	}
+	fmt.Fprintf(a.Out, "approve %s? [y/N] ", req.Job)
+
+	answers := make(chan string, 1)
+	errs := make(chan error, 1)
+	// the read is not interruptible, it is abandoned if ctx is done first
+	go func() {
+		answer, err := bufio.NewReader(a.In).ReadString('\n')
+		if err != nil && len(answer) == 0 {
+			errs <- err
+			return
+		}
+		answers <- answer
+	}()
+
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(a.Out)
+		return Decision{}, context.Cause(ctx)
+	case err := <-errs:
+		return Decision{}, fmt.Errorf("failed to read answer: %w", err)
+	case answer := <-answers:
+		verdict, comment, _ := strings.Cut(strings.TrimSpace(answer), " ")
+		verdict = strings.ToLower(verdict)
+
+		return Decision{
+			Approved: verdict == "y" || verdict == "yes",
+			Approver: currentUser(),
+			Comment:  strings.TrimSpace(comment),
+			Time:     time.Now(),
+		}, nil
+	}
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Get when no cache entry is stored under the key
+var ErrNotFound = errors.New("cache entry not found")
+
+// Store persists cache entries, tar archives of a cached directory, between runs
+type Store interface {
+	// returns the archive stored under key, or ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// stores the archive read from r under key, replacing any existing entry
+	Put(ctx context.Context, key string, r io.Reader) error
+	// lists the entries whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Entry, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Entry describes a stored cache entry
+type Entry struct {
+	Key  string
+	Size int64
+	// when the entry was last saved, or restored if the store keeps track of it
+	LastUsed time.Time
+}
+
+// returns the key to restore: key itself if it is stored, otherwise the most recently used entry matching
+// the first restore key prefix that matches anything. exact is true when key itself was found
+func Lookup(ctx context.Context, s Store, key string, restoreKeys ...string) (found string, exact bool, err error) {
+	for i, prefix := range append([]string{key}, restoreKeys...) {
+		entries, err := s.List(ctx, prefix)
+		if err != nil {
+			return "", false, err
+		}
+
+		if i == 0 {
+			for _, e := range entries {
+				if e.Key == sanitizeKey(key) {
+					return e.Key, true, nil
+				}
+			}
+			continue
+		}
+
+		if len(entries) > 0 {
+			sortByLastUsed(entries)
+			return entries[0].Key, false, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// Policy limits what a store keeps, zero values mean no limit
+type Policy struct {
+	// entries not used for longer than MaxAge are removed
+	MaxAge time.Duration
+	// the most recently used entries are kept up to a total size of MaxSize bytes
+	MaxSize int64
+	// the most recently used MaxEntries entries are kept
+	MaxEntries int
+}
+
+// removes the entries of s not allowed by the policy, least recently used first. returns the removed entries
+func Evict(ctx context.Context, s Store, p Policy, now time.Time) ([]Entry, error) {
+	entries, err := s.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	sortByLastUsed(entries)
+
+	removed := []Entry{}
+	var size int64
+	kept := 0
+	for _, e := range entries {
+		expired := p.MaxAge > 0 && now.Sub(e.LastUsed) > p.MaxAge
+		tooBig := p.MaxSize > 0 && size+e.Size > p.MaxSize
+		tooMany := p.MaxEntries > 0 && kept >= p.MaxEntries
+
+		if !expired && !tooBig && !tooMany {
+			size += e.Size
+			kept++
+			continue
+		}
+
+		if err := s.Delete(ctx, e.Key); err != nil {
+			return removed, err
+		}
+		removed = append(removed, e)
+	}
+
+	return removed, nil
+}
+
+// most recently used first
+func sortByLastUsed(entries []Entry) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+}
+
+// returns a hex encoded hash of the contents of the files matching the globs, in a stable order.
+// returns an empty string when no file matches
+func HashFiles(globs ...string) (string, error) {
+	files := []string{}
+	for _, g := range globs {
+		matches, err := filepath.Glob(g)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	hashed := 0
+	for i, file := range files {
+		if i > 0 && files[i-1] == file {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		hashed++
+	}
+
+	if hashed == 0 {
+		return "", nil
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replaces characters that are not safe in file and object names. stores apply it to all keys, so
+// the keys they list may differ from the ones entries were saved with
+func sanitizeKey(key string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_", " ", "_").Replace(key)
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stores an entry in s with the given content, and sets when it was last used
+func putEntry(t *testing.T, s *LocalStore, key, content string, lastUsed time.Time) {
+	assert.NoError(t, s.Put(context.Background(), key, strings.NewReader(content)))
+	assert.NoError(t, os.Chtimes(s.path(key), lastUsed, lastUsed))
+}
+
+func TestLookup(t *testing.T) {
+	s := NewLocalStore(t.TempDir())
+	now := time.Now()
+
+	putEntry(t, s, "go-mod-linux-aaa", "a", now.Add(-2*time.Hour))
+	putEntry(t, s, "go-mod-linux-bbb", "b", now.Add(-time.Hour))
+	putEntry(t, s, "npm-linux-ccc", "c", now)
+
+	type testcase struct {
+		name          string
+		key           string
+		restoreKeys   []string
+		expectedKey   string
+		expectedExact bool
+	}
+
+	testcases := []testcase{
+		{name: "exact match", key: "go-mod-linux-aaa", restoreKeys: []string{"go-mod-"}, expectedKey: "go-mod-linux-aaa", expectedExact: true},
+		{name: "most recent entry of the first matching prefix", key: "go-mod-linux-zzz", restoreKeys: []string{"go-mod-darwin-", "go-mod-"}, expectedKey: "go-mod-linux-bbb"},
+		{name: "key is not used as prefix", key: "go-mod-linux", expectedKey: ""},
+		{name: "nothing matches", key: "pip-xyz", restoreKeys: []string{"pip-"}, expectedKey: ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, exact, err := Lookup(context.Background(), s, tc.key, tc.restoreKeys...)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedKey, key)
+			assert.Equal(t, tc.expectedExact, exact)
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+
+	type testcase struct {
+		name     string
+		policy   Policy
+		expected []string
+	}
+
+	testcases := []testcase{
+		{name: "no limits", policy: Policy{}, expected: []string{"a", "b", "c", "d"}},
+		{name: "max age", policy: Policy{MaxAge: 90 * time.Minute}, expected: []string{"a", "b"}},
+		{name: "max entries", policy: Policy{MaxEntries: 3}, expected: []string{"a", "b", "c"}},
+		{name: "max size", policy: Policy{MaxSize: 6}, expected: []string{"a", "b", "c"}},
+		{name: "max size skips large entries", policy: Policy{MaxSize: 4}, expected: []string{"a", "b", "d"}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewLocalStore(t.TempDir())
+			putEntry(t, s, "a", "1", now)
+			putEntry(t, s, "b", "22", now.Add(-time.Hour))
+			putEntry(t, s, "c", "333", now.Add(-2*time.Hour))
+			putEntry(t, s, "d", "4", now.Add(-3*time.Hour))
+
+			_, err := Evict(context.Background(), s, tc.policy, now)
+			assert.NoError(t, err)
+
+			entries, err := s.List(context.Background(), "")
+			assert.NoError(t, err)
+
+			keys := []string{}
+			for _, e := range entries {
+				keys = append(keys, e.Key)
+			}
+			assert.ElementsMatch(t, tc.expected, keys)
+		})
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.sum"), []byte("sum"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "go.sum"), []byte("other"), 0644))
+
+	single, err := HashFiles(filepath.Join(dir, "go.sum"))
+	assert.NoError(t, err)
+	assert.Len(t, single, 64)
+
+	both, err := HashFiles(filepath.Join(dir, "*", "go.sum"), filepath.Join(dir, "go.sum"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, single, both)
+
+	// the order of the globs and duplicate matches don't matter
+	again, err := HashFiles(filepath.Join(dir, "go.sum"), filepath.Join(dir, "*", "go.sum"), filepath.Join(dir, "go.sum"))
+	assert.NoError(t, err)
+	assert.Equal(t, both, again)
+
+	none, err := HashFiles(filepath.Join(dir, "package-lock.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", none)
+}
+
+func TestLocalStore(t *testing.T) {
+	s := NewLocalStore(filepath.Join(t.TempDir(), "cache"))
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entries, err := s.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.NoError(t, s.Put(ctx, "go/mod key", strings.NewReader("archive")))
+
+	rc, err := s.Get(ctx, "go/mod key")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, "archive", string(content))
+
+	entries, err = s.List(ctx, "go/")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "go_mod_key", entries[0].Key)
+	assert.Equal(t, int64(7), entries[0].Size)
+
+	assert.NoError(t, s.Delete(ctx, "go/mod key"))
+	assert.NoError(t, s.Delete(ctx, "go/mod key"))
+	_, err = s.Get(ctx, "go/mod key")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const archiveExt = ".tar"
+
+// LocalStore keeps cache entries as tar archives in a directory on the host
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{Dir: dir}
+}
+
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.Dir, archiveName(key))
+}
+
+// the modification time of the archive is updated, so eviction keeps entries that are still restored
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(s.path(key), now, now)
+
+	return f, nil
+}
+
+// the archive is written to a temporary file first, so a failed save never leaves a partial entry
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]Entry, error) {
+	files, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, f := range files {
+		key, ok := strings.CutSuffix(f.Name(), archiveExt)
+		if !ok || f.IsDir() || !strings.HasPrefix(key, sanitizeKey(prefix)) {
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{
+			Key:      key,
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	return entries, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// payload hash used for requests whose body is not signed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Store keeps cache entries in a bucket of an S3-compatible object storage (AWS S3, MinIO, ...),
+// using path-style requests signed with AWS Signature Version 4
+type S3Store struct {
+	// e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// prepended to the object name of every entry
+	Prefix string
+	Client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Store(endpoint, bucket, accessKey, secretKey string) *S3Store {
+	return &S3Store{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    "us-east-1",
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    http.DefaultClient,
+		now:       time.Now,
+	}
+}
+
+func (s *S3Store) object(key string) string {
+	return s.Prefix + archiveName(key)
+}
+
+// builds a signed request for the object (or the bucket, if object is empty)
+func (s *S3Store) request(ctx context.Context, method, object string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/%s", s.Endpoint, s.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if len(object) > 0 {
+		u.Path += "/" + object
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, s.now().UTC())
+
+	return req, nil
+}
+
+// adds the AWS Signature Version 4 authorization header to req
+func (s *S3Store) sign(req *http.Request, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, unsignedPayload, amzDate),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// encodes everything but unreserved characters, and '/' unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// the query string sorted by key, with keys and values encoded as required by the signature
+func canonicalQuery(query url.Values) string {
+	keys := []string{}
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+// sends the request, returning an error for unexpected status codes
+func (s *S3Store) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, s.object(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	return resp.Body, nil
+}
+
+// the archive is buffered in a temporary file, since the object storage needs to know its size upfront
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	tmp, err := os.CreateTemp("", "anypipe-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := s.request(ctx, http.MethodPut, s.object(key), nil, io.NopCloser(tmp))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("bucket %s not found", s.Bucket)
+	}
+
+	return nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// entries are listed with ListObjectsV2. object storages don't track reads, so LastUsed is when the entry was saved
+func (s *S3Store) List(ctx context.Context, prefix string) ([]Entry, error) {
+	entries := []Entry{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + sanitizeKey(prefix)}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := s.request(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, fmt.Errorf("bucket %s not found", s.Bucket)
+		}
+
+		result := listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			key, ok := strings.CutSuffix(strings.TrimPrefix(c.Key, s.Prefix), archiveExt)
+			if !ok {
+				continue
+			}
+			entries = append(entries, Entry{Key: key, Size: c.Size, LastUsed: c.LastModified})
+		}
+
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			return entries, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, s.object(key), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
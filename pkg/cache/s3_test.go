@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// minio is an in-memory stand-in for an S3-compatible server, verifying request signatures
+type minio struct {
+	t       *testing.T
+	store   *S3Store
+	mu      sync.Mutex
+	objects map[string][]byte
+	// objects listed per page
+	pageSize int
+}
+
+func (m *minio) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.validSignature(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+		return
+	}
+
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "cache" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(object) == 0:
+		m.list(w, r)
+	case r.Method == http.MethodGet:
+		content, ok := m.objects[object]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		assert.Equal(m.t, r.ContentLength, int64(len(content)))
+		m.objects[object] = content
+	case r.Method == http.MethodDelete:
+		delete(m.objects, object)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// recomputes the signature of the request with the expected credentials
+func (m *minio) validSignature(r *http.Request) bool {
+	t, err := time.Parse("20060102T150405Z", r.Header.Get("x-amz-date"))
+	if err != nil {
+		return false
+	}
+
+	expected, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	m.store.sign(expected, t)
+
+	return r.Header.Get("Authorization") == expected.Header.Get("Authorization")
+}
+
+func (m *minio) list(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(m.t, "2", r.URL.Query().Get("list-type"))
+
+	keys := []string{}
+	for k := range m.objects {
+		if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []struct {
+			Key          string
+			Size         int
+			LastModified string
+		}
+	}{}
+
+	for i, k := range keys {
+		if i == m.pageSize {
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[i-1]
+			break
+		}
+		result.Contents = append(result.Contents, struct {
+			Key          string
+			Size         int
+			LastModified string
+		}{Key: k, Size: len(m.objects[k]), LastModified: "2024-05-01T10:00:00.000Z"})
+	}
+
+	assert.NoError(m.t, xml.NewEncoder(w).Encode(result))
+}
+
+func TestS3Store(t *testing.T) {
+	s := NewS3Store("", "cache", "minioadmin", "minio secret")
+	s.Prefix = "anypipe/"
+
+	m := &minio{t: t, store: s, objects: map[string][]byte{"anypipe/not-an-archive": []byte("x")}, pageSize: 1}
+	server := httptest.NewServer(m)
+	defer server.Close()
+	s.Endpoint = server.URL
+
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "go-mod-aaa")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, s.Put(ctx, "go-mod-aaa", strings.NewReader("first")))
+	assert.NoError(t, s.Put(ctx, "go-mod-bbb", strings.NewReader("second")))
+	assert.NoError(t, s.Put(ctx, "npm-ccc", strings.NewReader("third")))
+	assert.Contains(t, m.objects, "anypipe/go-mod-aaa.tar")
+
+	rc, err := s.Get(ctx, "go-mod-bbb")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, "second", string(content))
+
+	// listed one object per page
+	entries, err := s.List(ctx, "go-mod-")
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Key: "go-mod-aaa", Size: 5, LastUsed: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{Key: "go-mod-bbb", Size: 6, LastUsed: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+	}, entries)
+
+	entries, err = s.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	assert.NoError(t, s.Delete(ctx, "go-mod-aaa"))
+	assert.NotContains(t, m.objects, "anypipe/go-mod-aaa.tar")
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		other := NewS3Store(server.URL, "cache", "minioadmin", "wrong")
+		_, err := other.Get(ctx, "go-mod-bbb")
+		assert.ErrorContains(t, err, "status 403")
+	})
+
+	t.Run("missing bucket", func(t *testing.T) {
+		other := NewS3Store(server.URL, "other", "minioadmin", "minio secret")
+		m.store = other
+		defer func() { m.store = s }()
+
+		assert.ErrorContains(t, other.Put(ctx, "key", strings.NewReader("x")), "bucket other not found")
+		_, err := other.List(ctx, "")
+		assert.ErrorContains(t, err, "bucket other not found")
+	})
+}
+
+func TestURIEncode(t *testing.T) {
+	assert.Equal(t, "/bucket/a%20b%2Bc~d.tar", uriEncode("/bucket/a b+c~d.tar", false))
+	assert.Equal(t, "a%2Fb", uriEncode("a/b", true))
+	assert.Equal(t, "list-type=2&prefix=go%2Fmod", canonicalQuery(map[string][]string{"prefix": {"go/mod"}, "list-type": {"2"}}))
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+const volumeMountPath = "/cache"
+
+// VolumeStore keeps cache entries as tar archives in a Docker named volume, accessed through a helper container.
+// useful when the Docker daemon is remote, or its host is shared between runners
+type VolumeStore struct {
+	Volume string
+	// image of the helper container, it needs a shell with stat and touch
+	Image  string
+	du     dockerutils.DockerUtils
+	mu     sync.Mutex
+	helper *dockerutils.Container
+}
+
+// creates a store in the given volume. the helper container is removed when du is closed
+func NewVolumeStore(du dockerutils.DockerUtils, volume string) *VolumeStore {
+	return &VolumeStore{
+		Volume: volume,
+		Image:  "alpine:3.20",
+		du:     du,
+	}
+}
+
+// returns the helper container, starting it on first use
+func (s *VolumeStore) container() (*dockerutils.Container, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.helper != nil {
+		return s.helper, nil
+	}
+
+	c, err := s.du.CreateContainerWithOptions(s.Image, dockerutils.ContainerOptions{
+		Volumes: map[string]string{volumeMountPath: s.Volume},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.helper = c
+
+	return c, nil
+}
+
+// runs cmd in the helper container, failing on a non-zero exit code
+func (s *VolumeStore) exec(cmd string) (string, error) {
+	c, err := s.container()
+	if err != nil {
+		return "", err
+	}
+
+	stdout, stderr, ec, err := s.du.Exec(c, cmd)
+	if err != nil {
+		return "", err
+	}
+	if ec != 0 {
+		return "", fmt.Errorf("'%s' failed with exit code %d: %s", cmd, ec, stderr)
+	}
+
+	return stdout, nil
+}
+
+func archiveName(key string) string {
+	return sanitizeKey(key) + archiveExt
+}
+
+// quotes s for sh, keys may contain anything but the characters sanitizeKey replaces
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// the archive is copied to a temporary directory on the host, which is removed when the reader is closed
+func (s *VolumeStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name := archiveName(key)
+	out, err := s.exec(fmt.Sprintf("if [ -f %[1]s ]; then touch %[1]s && echo found; fi", shellQuote(volumeMountPath+"/"+name)))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(out) != "found" {
+		return nil, ErrNotFound
+	}
+
+	dir, err := os.MkdirTemp("", "anypipe-cache-")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.container()
+	if err == nil {
+		err = s.du.CopyFrom(c, volumeMountPath+"/"+name, dir)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &tempFile{File: f, dir: dir}, nil
+}
+
+func (s *VolumeStore) Put(ctx context.Context, key string, r io.Reader) error {
+	dir, err := os.MkdirTemp("", "anypipe-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := os.Create(filepath.Join(dir, archiveName(key)))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := s.container()
+	if err != nil {
+		return err
+	}
+
+	return s.du.CopyTo(c, dir, volumeMountPath)
+}
+
+func (s *VolumeStore) List(ctx context.Context, prefix string) ([]Entry, error) {
+	out, err := s.exec(fmt.Sprintf(`cd %s && for f in *%s; do [ -f "$f" ] && stat -c '%%s %%Y %%n' "$f"; done; true`, volumeMountPath, archiveExt))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		key := strings.TrimSuffix(fields[2], archiveExt)
+		if !strings.HasPrefix(key, sanitizeKey(prefix)) {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected size in '%s': %w", line, err)
+		}
+		mtime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected modification time in '%s': %w", line, err)
+		}
+
+		entries = append(entries, Entry{
+			Key:      key,
+			Size:     size,
+			LastUsed: time.Unix(mtime, 0),
+		})
+	}
+
+	return entries, nil
+}
+
+func (s *VolumeStore) Delete(ctx context.Context, key string) error {
+	_, err := s.exec(fmt.Sprintf("rm -f %s", shellQuote(volumeMountPath+"/"+archiveName(key))))
+
+	return err
+}
+
+// tempFile removes its directory once closed
+type tempFile struct {
+	*os.File
+	dir string
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+
+	return err
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestVolumeStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	helper := &dockerutils.Container{}
+	s := NewVolumeStore(du, "anypipe-cache")
+	ctx := context.Background()
+
+	// the helper container is only created once
+	du.EXPECT().CreateContainerWithOptions("alpine:3.20", dockerutils.ContainerOptions{
+		Volumes: map[string]string{"/cache": "anypipe-cache"},
+	}).Times(1).Return(helper, nil)
+
+	t.Run("get", func(t *testing.T) {
+		du.EXPECT().Exec(helper, "if [ -f '/cache/go-mod-aaa.tar' ]; then touch '/cache/go-mod-aaa.tar' && echo found; fi").Times(1).Return("found\n", "", 0, nil)
+		du.EXPECT().CopyFrom(helper, "/cache/go-mod-aaa.tar", gomock.Any()).Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			return os.WriteFile(filepath.Join(dst, "go-mod-aaa.tar"), []byte("archive"), 0644)
+		})
+
+		rc, err := s.Get(ctx, "go-mod-aaa")
+		assert.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, "archive", string(content))
+
+		assert.NoError(t, rc.Close())
+		_, err = os.Stat(rc.(*tempFile).dir)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("get missing entry", func(t *testing.T) {
+		du.EXPECT().Exec(helper, gomock.Any()).Times(1).Return("", "", 0, nil)
+
+		_, err := s.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("put", func(t *testing.T) {
+		du.EXPECT().CopyTo(helper, gomock.Any(), "/cache").Times(1).DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+			content, err := os.ReadFile(filepath.Join(src, "npm-bbb.tar"))
+			assert.NoError(t, err)
+			assert.Equal(t, "archive", string(content))
+			return nil
+		})
+
+		assert.NoError(t, s.Put(ctx, "npm-bbb", strings.NewReader("archive")))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		du.EXPECT().Exec(helper, `cd /cache && for f in *.tar; do [ -f "$f" ] && stat -c '%s %Y %n' "$f"; done; true`).Times(1).
+			Return("7 1714557600 go-mod-aaa.tar\n12 1714561200 npm-bbb.tar\n", "", 0, nil)
+
+		entries, err := s.List(ctx, "go-")
+		assert.NoError(t, err)
+		assert.Equal(t, []Entry{{Key: "go-mod-aaa", Size: 7, LastUsed: time.Unix(1714557600, 0)}}, entries)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		du.EXPECT().Exec(helper, "rm -f '/cache/npm-bbb.tar'").Times(1).Return("", "", 0, nil)
+
+		assert.NoError(t, s.Delete(ctx, "npm-bbb"))
+	})
+
+	t.Run("keys are quoted", func(t *testing.T) {
+		du.EXPECT().Exec(helper, `if [ -f '/cache/it'\''s;_rm_-rf__.tar' ]; then touch '/cache/it'\''s;_rm_-rf__.tar' && echo found; fi`).Times(1).Return("", "", 0, nil)
+		du.EXPECT().Exec(helper, `rm -f '/cache/it'\''s;_rm_-rf__.tar'`).Times(1).Return("", "", 0, nil)
+
+		_, err := s.Get(ctx, "it's; rm -rf /")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, s.Delete(ctx, "it's; rm -rf /"))
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		du.EXPECT().Exec(helper, "rm -f '/cache/npm-bbb.tar'").Times(1).Return("", "read-only file system", 1, nil)
+
+		assert.ErrorContains(t, s.Delete(ctx, "npm-bbb"), "read-only file system")
+	})
+}
@@ -0,0 +1,83 @@
+package ci
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// run metadata exposed by the CI provider
+type Metadata struct {
+	Provider  string
+	CommitSHA string
+	Branch    string
+	PRNumber  string
+	BuildURL  string
+}
+
+// an error or warning reported against a pipeline step
+type Annotation struct {
+	Title   string
+	Message string
+	File    string
+	Line    int
+	Warning bool
+}
+
+// Provider emits provider-native output for the CI system the pipeline runs on
+type Provider interface {
+	Name() string
+	Metadata() Metadata
+	// starts a collapsible log section
+	StartGroup(name string)
+	// ends the log section started with the same name
+	EndGroup(name string)
+	// registers a value that must not appear in the CI logs
+	AddMask(value string)
+	Annotate(a Annotation)
+	// appends a markdown summary to the run page, where supported
+	WriteSummary(render func(w io.Writer)) error
+	// makes the selected values available to later steps of the CI job, where supported
+	Export(outputs, env map[string]string) error
+	// writes a JUnit report where the provider can pick it up, where supported
+	WriteJUnit(suites []TestSuite) error
+}
+
+// converts a list of KEY=VALUE pairs (as returned by os.Environ) into a map
+func EnvMap(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = value
+	}
+
+	return env
+}
+
+// detects the CI provider from the given environment. Output is written to out
+func Detect(env map[string]string, out io.Writer) Provider {
+	switch {
+	case len(env["GITHUB_ACTIONS"]) > 0:
+		return NewGitHub(env, out)
+	case len(env["GITLAB_CI"]) > 0:
+		return NewGitLab(env, out)
+	case len(env["JENKINS_URL"]) > 0:
+		return NewJenkins(env, out)
+	default:
+		return NewGeneric(env, out)
+	}
+}
+
+// detects the CI provider from the process environment, writing to stdout
+func Current() Provider {
+	return Detect(EnvMap(os.Environ()), os.Stdout)
+}
+
+// returns the path the JUnit report should be written to
+func junitPath(env map[string]string) string {
+	if path := env["ANYPIPE_JUNIT_REPORT"]; len(path) > 0 {
+		return path
+	}
+
+	return "anypipe-junit.xml"
+}
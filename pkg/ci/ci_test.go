@@ -0,0 +1,49 @@
+package ci
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvMap(t *testing.T) {
+	res := EnvMap([]string{"A=1", "B=x=y", "C=", "D"})
+	assert.Equal(t, map[string]string{"A": "1", "B": "x=y", "C": "", "D": ""}, res)
+}
+
+func TestDetect(t *testing.T) {
+	type testcase struct {
+		env          map[string]string
+		expectedName string
+	}
+
+	testcases := []testcase{
+		{
+			env:          map[string]string{"GITHUB_ACTIONS": "true", "CI": "true"},
+			expectedName: "github",
+		},
+		{
+			env:          map[string]string{"GITLAB_CI": "true", "CI": "true"},
+			expectedName: "gitlab",
+		},
+		{
+			env:          map[string]string{"JENKINS_URL": "https://jenkins.local/"},
+			expectedName: "jenkins",
+		},
+		{
+			env:          map[string]string{"CI": "true"},
+			expectedName: "generic",
+		},
+		{
+			env:          map[string]string{},
+			expectedName: "local",
+		},
+	}
+
+	for _, tc := range testcases {
+		p := Detect(tc.env, io.Discard)
+		assert.Equal(t, tc.expectedName, p.Name())
+		assert.Equal(t, tc.expectedName, p.Metadata().Provider)
+	}
+}
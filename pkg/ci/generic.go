@@ -0,0 +1,60 @@
+package ci
+
+import (
+	"io"
+)
+
+// Generic is used when no known CI provider is detected (including local runs). It adds nothing to the logs
+type Generic struct {
+	env map[string]string
+	out io.Writer
+}
+
+func NewGeneric(env map[string]string, out io.Writer) *Generic {
+	return &Generic{
+		env: env,
+		out: out,
+	}
+}
+
+func (g *Generic) Name() string {
+	if len(g.env["CI"]) > 0 {
+		return "generic"
+	}
+
+	return "local"
+}
+
+func (g *Generic) Metadata() Metadata {
+	return Metadata{
+		Provider:  g.Name(),
+		CommitSHA: g.env["GIT_COMMIT"],
+		Branch:    g.env["GIT_BRANCH"],
+		BuildURL:  g.env["BUILD_URL"],
+	}
+}
+
+func (g *Generic) StartGroup(name string) {}
+
+func (g *Generic) EndGroup(name string) {}
+
+func (g *Generic) AddMask(value string) {}
+
+func (g *Generic) Annotate(a Annotation) {}
+
+func (g *Generic) WriteSummary(render func(w io.Writer)) error {
+	return nil
+}
+
+func (g *Generic) Export(outputs, env map[string]string) error {
+	return nil
+}
+
+// only writes a JUnit report when ANYPIPE_JUNIT_REPORT is set explicitly
+func (g *Generic) WriteJUnit(suites []TestSuite) error {
+	if len(g.env["ANYPIPE_JUNIT_REPORT"]) == 0 {
+		return nil
+	}
+
+	return writeJUnitFile(junitPath(g.env), suites)
+}
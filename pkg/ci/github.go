@@ -0,0 +1,165 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GitHub emits workflow commands understood by the GitHub Actions runner
+type GitHub struct {
+	env map[string]string
+	out io.Writer
+}
+
+func NewGitHub(env map[string]string, out io.Writer) *GitHub {
+	return &GitHub{
+		env: env,
+		out: out,
+	}
+}
+
+func (gh *GitHub) Name() string {
+	return "github"
+}
+
+func (gh *GitHub) Metadata() Metadata {
+	m := Metadata{
+		Provider:  gh.Name(),
+		CommitSHA: gh.env["GITHUB_SHA"],
+		Branch:    gh.env["GITHUB_REF_NAME"],
+	}
+
+	// pull request refs look like refs/pull/<number>/merge
+	if ref := gh.env["GITHUB_REF"]; strings.HasPrefix(ref, "refs/pull/") {
+		m.PRNumber = strings.Split(strings.TrimPrefix(ref, "refs/pull/"), "/")[0]
+		m.Branch = gh.env["GITHUB_HEAD_REF"]
+	}
+
+	if len(gh.env["GITHUB_RUN_ID"]) > 0 {
+		m.BuildURL = fmt.Sprintf("%s/%s/actions/runs/%s", gh.env["GITHUB_SERVER_URL"], gh.env["GITHUB_REPOSITORY"], gh.env["GITHUB_RUN_ID"])
+	}
+
+	return m
+}
+
+// escapes the message part of a workflow command
+func escapeData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	return strings.ReplaceAll(s, "\n", "%0A")
+}
+
+// escapes a property value of a workflow command
+func escapeProperty(s string) string {
+	s = escapeData(s)
+	s = strings.ReplaceAll(s, ":", "%3A")
+	return strings.ReplaceAll(s, ",", "%2C")
+}
+
+func (gh *GitHub) StartGroup(name string) {
+	fmt.Fprintf(gh.out, "::group::%s\n", escapeData(name))
+}
+
+// GitHub does not support nested groups, so the name is not needed to close one
+func (gh *GitHub) EndGroup(name string) {
+	fmt.Fprintln(gh.out, "::endgroup::")
+}
+
+func (gh *GitHub) AddMask(value string) {
+	if len(value) == 0 {
+		return
+	}
+	fmt.Fprintf(gh.out, "::add-mask::%s\n", escapeData(value))
+}
+
+func (gh *GitHub) Annotate(a Annotation) {
+	level := "error"
+	if a.Warning {
+		level = "warning"
+	}
+
+	props := []string{fmt.Sprintf("title=%s", escapeProperty(a.Title))}
+	if len(a.File) > 0 {
+		props = append(props, fmt.Sprintf("file=%s", escapeProperty(a.File)))
+		if a.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", a.Line))
+		}
+	}
+
+	fmt.Fprintf(gh.out, "::%s %s::%s\n", level, strings.Join(props, ","), escapeData(a.Message))
+}
+
+// appends the output of render to the job summary file
+func (gh *GitHub) WriteSummary(render func(w io.Writer)) error {
+	return gh.appendFile("GITHUB_STEP_SUMMARY", func(w io.Writer) error {
+		render(w)
+		return nil
+	})
+}
+
+// writes outputs to $GITHUB_OUTPUT and env to $GITHUB_ENV
+func (gh *GitHub) Export(outputs, env map[string]string) error {
+	if err := gh.writeValues("GITHUB_OUTPUT", outputs); err != nil {
+		return err
+	}
+
+	return gh.writeValues("GITHUB_ENV", env)
+}
+
+// GitHub has no native JUnit support, the step summary is used instead
+func (gh *GitHub) WriteJUnit(suites []TestSuite) error {
+	return nil
+}
+
+func (gh *GitHub) writeValues(fileEnv string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := []string{}
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return gh.appendFile(fileEnv, func(w io.Writer) error {
+		for _, k := range keys {
+			if _, err := io.WriteString(w, formatKeyValue(k, values[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (gh *GitHub) appendFile(fileEnv string, write func(w io.Writer) error) error {
+	path := gh.env[fileEnv]
+	if len(path) == 0 {
+		return fmt.Errorf("%s is not set", fileEnv)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return write(f)
+}
+
+// formats a key/value pair for $GITHUB_OUTPUT or $GITHUB_ENV, using the heredoc syntax for multiline values
+func formatKeyValue(key, value string) string {
+	if !strings.ContainsAny(value, "\r\n") {
+		return fmt.Sprintf("%s=%s\n", key, value)
+	}
+
+	delimiter := "ANYPIPE_EOF"
+	for strings.Contains(value, delimiter) {
+		delimiter += "_"
+	}
+
+	return fmt.Sprintf("%s<<%s\n%s\n%s\n", key, delimiter, value, delimiter)
+}
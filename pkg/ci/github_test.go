@@ -0,0 +1,153 @@
+package ci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testGitHubEnv(t *testing.T) map[string]string {
+	dir := t.TempDir()
+	return map[string]string{
+		"GITHUB_ACTIONS":      "true",
+		"GITHUB_OUTPUT":       filepath.Join(dir, "output"),
+		"GITHUB_ENV":          filepath.Join(dir, "env"),
+		"GITHUB_STEP_SUMMARY": filepath.Join(dir, "summary"),
+	}
+}
+
+func TestGitHubMetadata(t *testing.T) {
+	type testcase struct {
+		env            map[string]string
+		expectedOutput Metadata
+	}
+
+	testcases := []testcase{
+		{
+			env: map[string]string{
+				"GITHUB_SHA":        "abc123",
+				"GITHUB_REF":        "refs/heads/main",
+				"GITHUB_REF_NAME":   "main",
+				"GITHUB_SERVER_URL": "https://github.com",
+				"GITHUB_REPOSITORY": "owner/repo",
+				"GITHUB_RUN_ID":     "42",
+			},
+			expectedOutput: Metadata{
+				Provider:  "github",
+				CommitSHA: "abc123",
+				Branch:    "main",
+				BuildURL:  "https://github.com/owner/repo/actions/runs/42",
+			},
+		},
+		{
+			env: map[string]string{
+				"GITHUB_SHA":      "abc123",
+				"GITHUB_REF":      "refs/pull/7/merge",
+				"GITHUB_REF_NAME": "7/merge",
+				"GITHUB_HEAD_REF": "feature",
+			},
+			expectedOutput: Metadata{
+				Provider:  "github",
+				CommitSHA: "abc123",
+				Branch:    "feature",
+				PRNumber:  "7",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expectedOutput, NewGitHub(tc.env, io.Discard).Metadata())
+	}
+}
+
+func TestGitHubCommands(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	gh := NewGitHub(testGitHubEnv(t), out)
+
+	gh.StartGroup("job / step")
+	gh.EndGroup("job / step")
+	gh.AddMask("s3cr3t")
+	gh.AddMask("")
+
+	assert.Equal(t, "::group::job / step\n::endgroup::\n::add-mask::s3cr3t\n", out.String())
+}
+
+func TestGitHubAnnotate(t *testing.T) {
+	type testcase struct {
+		input          Annotation
+		expectedOutput string
+	}
+
+	testcases := []testcase{
+		{
+			input:          Annotation{Title: "job / step", Message: "some error"},
+			expectedOutput: "::error title=job / step::some error\n",
+		},
+		{
+			input:          Annotation{Title: "job / step", Message: "multi\nline 100%"},
+			expectedOutput: "::error title=job / step::multi%0Aline 100%25\n",
+		},
+		{
+			input:          Annotation{Title: "job / step", Message: "lint failed", File: "main.go", Line: 12},
+			expectedOutput: "::error title=job / step,file=main.go,line=12::lint failed\n",
+		},
+		{
+			input:          Annotation{Title: "job / step", Message: "deprecated", File: "a,b.go", Warning: true},
+			expectedOutput: "::warning title=job / step,file=a%2Cb.go::deprecated\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		out := bytes.NewBuffer([]byte{})
+		NewGitHub(map[string]string{}, out).Annotate(tc.input)
+		assert.Equal(t, tc.expectedOutput, out.String())
+	}
+}
+
+func TestGitHubExport(t *testing.T) {
+	env := testGitHubEnv(t)
+	gh := NewGitHub(env, io.Discard)
+
+	outputs := map[string]string{
+		"version": "1.2.3",
+		"notes":   "line1\nline2",
+	}
+
+	assert.NoError(t, gh.Export(outputs, map[string]string{"count": "3"}))
+
+	res, err := os.ReadFile(env["GITHUB_OUTPUT"])
+	assert.NoError(t, err)
+	assert.Equal(t, "notes<<ANYPIPE_EOF\nline1\nline2\nANYPIPE_EOF\nversion=1.2.3\n", string(res))
+
+	res, err = os.ReadFile(env["GITHUB_ENV"])
+	assert.NoError(t, err)
+	assert.Equal(t, "count=3\n", string(res))
+}
+
+func TestGitHubExportMissingFile(t *testing.T) {
+	gh := NewGitHub(map[string]string{"GITHUB_ACTIONS": "true"}, io.Discard)
+
+	assert.NoError(t, gh.Export(map[string]string{}, map[string]string{}))
+	assert.Error(t, gh.Export(map[string]string{"a": "b"}, map[string]string{}))
+}
+
+func TestGitHubWriteSummary(t *testing.T) {
+	env := testGitHubEnv(t)
+	gh := NewGitHub(env, io.Discard)
+
+	assert.NoError(t, gh.WriteSummary(func(w io.Writer) { _, _ = io.WriteString(w, "# summary\n") }))
+	assert.NoError(t, gh.WriteSummary(func(w io.Writer) { _, _ = io.WriteString(w, "# more\n") }))
+
+	res, err := os.ReadFile(env["GITHUB_STEP_SUMMARY"])
+	assert.NoError(t, err)
+	assert.Equal(t, "# summary\n# more\n", string(res))
+}
+
+func TestFormatKeyValue(t *testing.T) {
+	assert.Equal(t, "a=b\n", formatKeyValue("a", "b"))
+	assert.Equal(t, "a<<ANYPIPE_EOF_\nANYPIPE_EOF\n\nANYPIPE_EOF_\n", formatKeyValue("a", "ANYPIPE_EOF\n"))
+}
@@ -0,0 +1,144 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var invalidSectionChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// GitLab emits collapsible log sections, JUnit reports and dotenv reports understood by GitLab CI
+type GitLab struct {
+	env map[string]string
+	out io.Writer
+	now func() time.Time
+}
+
+func NewGitLab(env map[string]string, out io.Writer) *GitLab {
+	return &GitLab{
+		env: env,
+		out: out,
+		now: time.Now,
+	}
+}
+
+func (gl *GitLab) Name() string {
+	return "gitlab"
+}
+
+func (gl *GitLab) Metadata() Metadata {
+	return Metadata{
+		Provider:  gl.Name(),
+		CommitSHA: gl.env["CI_COMMIT_SHA"],
+		Branch:    gl.env["CI_COMMIT_REF_NAME"],
+		PRNumber:  gl.env["CI_MERGE_REQUEST_IID"],
+		BuildURL:  gl.env["CI_JOB_URL"],
+	}
+}
+
+// section names may only contain letters, digits, '_', '.' and '-'
+func sectionName(name string) string {
+	return invalidSectionChars.ReplaceAllString(name, "_")
+}
+
+func (gl *GitLab) StartGroup(name string) {
+	fmt.Fprintf(gl.out, "\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", gl.now().Unix(), sectionName(name), name)
+}
+
+func (gl *GitLab) EndGroup(name string) {
+	fmt.Fprintf(gl.out, "\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", gl.now().Unix(), sectionName(name))
+}
+
+// GitLab masks variables through the project settings, there is no runtime equivalent
+func (gl *GitLab) AddMask(value string) {}
+
+func (gl *GitLab) Annotate(a Annotation) {
+	fmt.Fprintln(gl.out, plainAnnotation(a))
+}
+
+func (gl *GitLab) WriteSummary(render func(w io.Writer)) error {
+	return nil
+}
+
+// writes the values to a dotenv report, which GitLab exposes to later jobs through `artifacts:reports:dotenv`.
+// values already in the report are kept unless exported again, each key is written once
+func (gl *GitLab) Export(outputs, env map[string]string) error {
+	values := map[string]string{}
+	for k, v := range outputs {
+		values[k] = v
+	}
+	for k, v := range env {
+		values[k] = v
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	for k, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("dotenv reports do not support multiline values (%s)", k)
+		}
+	}
+
+	path := gl.env["ANYPIPE_DOTENV_REPORT"]
+	if len(path) == 0 {
+		path = "anypipe.env"
+	}
+
+	// the report may have been written by an earlier export, the last value of a key wins
+	report := map[string]string{}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			report[k] = v
+		}
+	}
+	for k, v := range values {
+		report[k] = v
+	}
+
+	keys := []string{}
+	for k := range report {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	content := ""
+	for _, k := range keys {
+		content += fmt.Sprintf("%s=%s\n", k, report[k])
+	}
+
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+// writes the JUnit report, to be collected through `artifacts:reports:junit`
+func (gl *GitLab) WriteJUnit(suites []TestSuite) error {
+	return writeJUnitFile(junitPath(gl.env), suites)
+}
+
+// formats an annotation as a single plain log line
+func plainAnnotation(a Annotation) string {
+	level := "ERROR"
+	if a.Warning {
+		level = "WARNING"
+	}
+
+	location := ""
+	if len(a.File) > 0 {
+		location = fmt.Sprintf(" (%s)", a.File)
+		if a.Line > 0 {
+			location = fmt.Sprintf(" (%s:%d)", a.File, a.Line)
+		}
+	}
+
+	return fmt.Sprintf("%s: %s: %s%s", level, a.Title, a.Message, location)
+}
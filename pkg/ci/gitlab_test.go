@@ -0,0 +1,83 @@
+package ci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitLabMetadata(t *testing.T) {
+	env := map[string]string{
+		"GITLAB_CI":            "true",
+		"CI_COMMIT_SHA":        "abc123",
+		"CI_COMMIT_REF_NAME":   "feature",
+		"CI_MERGE_REQUEST_IID": "12",
+		"CI_JOB_URL":           "https://gitlab.local/group/repo/-/jobs/99",
+	}
+
+	assert.Equal(t, Metadata{
+		Provider:  "gitlab",
+		CommitSHA: "abc123",
+		Branch:    "feature",
+		PRNumber:  "12",
+		BuildURL:  "https://gitlab.local/group/repo/-/jobs/99",
+	}, NewGitLab(env, io.Discard).Metadata())
+}
+
+func TestGitLabSections(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	gl := NewGitLab(map[string]string{}, out)
+	gl.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	gl.StartGroup("my job / step 1")
+	gl.EndGroup("my job / step 1")
+
+	assert.Equal(t, "\x1b[0Ksection_start:1700000000:my_job___step_1[collapsed=true]\r\x1b[0Kmy job / step 1\n"+
+		"\x1b[0Ksection_end:1700000000:my_job___step_1\r\x1b[0K\n", out.String())
+}
+
+func TestGitLabAnnotate(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	gl := NewGitLab(map[string]string{}, out)
+
+	gl.Annotate(Annotation{Title: "job / step", Message: "some error", File: "main.go", Line: 3})
+	gl.Annotate(Annotation{Title: "job / step", Message: "careful", Warning: true})
+
+	assert.Equal(t, "ERROR: job / step: some error (main.go:3)\nWARNING: job / step: careful\n", out.String())
+}
+
+func TestGitLabExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.env")
+	gl := NewGitLab(map[string]string{"ANYPIPE_DOTENV_REPORT": path}, io.Discard)
+
+	assert.NoError(t, gl.Export(map[string]string{"VERSION": "1.2.3"}, map[string]string{"COUNT": "3"}))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "COUNT=3\nVERSION=1.2.3\n", string(res))
+
+	// exporting again keeps each key once, with its latest value
+	assert.NoError(t, gl.Export(map[string]string{"VERSION": "1.2.4"}, map[string]string{"TAG": "v1.2.4"}))
+
+	res, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "COUNT=3\nTAG=v1.2.4\nVERSION=1.2.4\n", string(res))
+
+	assert.Error(t, gl.Export(map[string]string{"NOTES": "a\nb"}, map[string]string{}))
+}
+
+func TestGitLabWriteJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports", "junit.xml")
+	gl := NewGitLab(map[string]string{"ANYPIPE_JUNIT_REPORT": path}, io.Discard)
+
+	assert.NoError(t, gl.WriteJUnit([]TestSuite{{Name: "job", Tests: 1, TestCases: []TestCase{{Name: "step"}}}}))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), `<testsuite name="job" tests="1"`)
+}
@@ -0,0 +1,66 @@
+package ci
+
+import (
+	"fmt"
+	"io"
+)
+
+// Jenkins emits plain log markers, since the console does not interpret escape sequences, and JUnit reports
+type Jenkins struct {
+	env map[string]string
+	out io.Writer
+}
+
+func NewJenkins(env map[string]string, out io.Writer) *Jenkins {
+	return &Jenkins{
+		env: env,
+		out: out,
+	}
+}
+
+func (j *Jenkins) Name() string {
+	return "jenkins"
+}
+
+func (j *Jenkins) Metadata() Metadata {
+	branch := j.env["BRANCH_NAME"]
+	if len(branch) == 0 {
+		branch = j.env["GIT_BRANCH"]
+	}
+
+	return Metadata{
+		Provider:  j.Name(),
+		CommitSHA: j.env["GIT_COMMIT"],
+		Branch:    branch,
+		PRNumber:  j.env["CHANGE_ID"],
+		BuildURL:  j.env["BUILD_URL"],
+	}
+}
+
+func (j *Jenkins) StartGroup(name string) {
+	fmt.Fprintf(j.out, "==> %s\n", name)
+}
+
+func (j *Jenkins) EndGroup(name string) {
+	fmt.Fprintf(j.out, "<== %s\n", name)
+}
+
+// Jenkins masks credentials bound through the credentials plugin, there is no runtime equivalent
+func (j *Jenkins) AddMask(value string) {}
+
+func (j *Jenkins) Annotate(a Annotation) {
+	fmt.Fprintln(j.out, plainAnnotation(a))
+}
+
+func (j *Jenkins) WriteSummary(render func(w io.Writer)) error {
+	return nil
+}
+
+func (j *Jenkins) Export(outputs, env map[string]string) error {
+	return nil
+}
+
+// writes the JUnit report, to be collected with the `junit` pipeline step
+func (j *Jenkins) WriteJUnit(suites []TestSuite) error {
+	return writeJUnitFile(junitPath(j.env), suites)
+}
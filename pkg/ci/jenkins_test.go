@@ -0,0 +1,75 @@
+package ci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJenkinsMetadata(t *testing.T) {
+	type testcase struct {
+		env            map[string]string
+		expectedOutput Metadata
+	}
+
+	testcases := []testcase{
+		{
+			env: map[string]string{
+				"JENKINS_URL": "https://jenkins.local/",
+				"GIT_COMMIT":  "abc123",
+				"BRANCH_NAME": "PR-5",
+				"CHANGE_ID":   "5",
+				"BUILD_URL":   "https://jenkins.local/job/repo/5/",
+			},
+			expectedOutput: Metadata{
+				Provider:  "jenkins",
+				CommitSHA: "abc123",
+				Branch:    "PR-5",
+				PRNumber:  "5",
+				BuildURL:  "https://jenkins.local/job/repo/5/",
+			},
+		},
+		{
+			env: map[string]string{
+				"JENKINS_URL": "https://jenkins.local/",
+				"GIT_BRANCH":  "origin/main",
+			},
+			expectedOutput: Metadata{
+				Provider: "jenkins",
+				Branch:   "origin/main",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expectedOutput, NewJenkins(tc.env, io.Discard).Metadata())
+	}
+}
+
+func TestJenkinsPlainLogs(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	j := NewJenkins(map[string]string{}, out)
+
+	j.StartGroup("job / step")
+	j.AddMask("secret")
+	j.Annotate(Annotation{Title: "job / step", Message: "some error"})
+	j.EndGroup("job / step")
+
+	assert.Equal(t, "==> job / step\nERROR: job / step: some error\n<== job / step\n", out.String())
+	assert.NotContains(t, out.String(), "\x1b")
+}
+
+func TestJenkinsWriteJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "junit.xml")
+
+	j := NewJenkins(map[string]string{"ANYPIPE_JUNIT_REPORT": path}, io.Discard)
+	assert.NoError(t, j.WriteJUnit([]TestSuite{}))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), "<testsuites></testsuites>")
+}
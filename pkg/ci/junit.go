@@ -0,0 +1,88 @@
+package ci
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type TestSuites struct {
+	XMLName xml.Name    `xml:"testsuites"`
+	Suites  []TestSuite `xml:"testsuite"`
+}
+
+// a JUnit test suite, one per pipeline job
+type TestSuite struct {
+	Name      string     `xml:"name,attr"`
+	Tests     int        `xml:"tests,attr"`
+	Failures  int        `xml:"failures,attr"`
+	Skipped   int        `xml:"skipped,attr"`
+	Time      string     `xml:"time,attr"`
+	TestCases []TestCase `xml:"testcase"`
+}
+
+// a JUnit test case, one per pipeline step
+type TestCase struct {
+	Name       string      `xml:"name,attr"`
+	ClassName  string      `xml:"classname,attr"`
+	Time       string      `xml:"time,attr"`
+	Properties *Properties `xml:"properties,omitempty"`
+	Failure    *Failure    `xml:"failure,omitempty"`
+	Skipped    *Skipped    `xml:"skipped,omitempty"`
+}
+
+type Properties struct {
+	Properties []Property `xml:"property"`
+}
+
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type Failure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type Skipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// formats a duration the way JUnit consumers expect it (seconds)
+func JUnitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// encodes the suites as a JUnit XML document
+func EncodeJUnit(w io.Writer, suites []TestSuite) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(TestSuites{Suites: suites}); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeJUnitFile(path string, suites []TestSuite) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return EncodeJUnit(f, suites)
+}
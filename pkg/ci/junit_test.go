@@ -0,0 +1,44 @@
+package ci
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeJUnit(t *testing.T) {
+	suites := []TestSuite{
+		{
+			Name:     "job",
+			Tests:    3,
+			Failures: 1,
+			Skipped:  1,
+			Time:     JUnitTime(1500 * time.Millisecond),
+			TestCases: []TestCase{
+				{Name: "step1", ClassName: "job", Time: JUnitTime(time.Second)},
+				{Name: "step2", ClassName: "job", Time: JUnitTime(500 * time.Millisecond), Failure: &Failure{Message: "boom", Body: "boom"}},
+				{Name: "step3", ClassName: "job", Time: JUnitTime(0), Skipped: &Skipped{}},
+			},
+		},
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	assert.NoError(t, EncodeJUnit(buf, suites))
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="job" tests="3" failures="1" skipped="1" time="1.500">
+    <testcase name="step1" classname="job" time="1.000"></testcase>
+    <testcase name="step2" classname="job" time="0.500">
+      <failure message="boom">boom</failure>
+    </testcase>
+    <testcase name="step3" classname="job" time="0.000">
+      <skipped></skipped>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	assert.Equal(t, expected, buf.String())
+}
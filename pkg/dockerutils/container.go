@@ -2,9 +2,76 @@ package dockerutils
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 )
 
+// options applied when creating a container
+type ContainerOptions struct {
+	// tmpfs mounts, keyed by path in the container, with mount options as value (e.g. "size=1m,mode=0700")
+	Tmpfs map[string]string
+	// named volumes, keyed by path in the container, with the volume name as value. volumes are created if missing
+	Volumes map[string]string
+	// host directories bind-mounted into the container
+	Binds []Bind
+	// CPU limit in number of CPUs, 0 for no limit
+	CPUs float64
+	// memory limit in bytes, 0 for no limit
+	Memory int64
+}
+
+// a host directory mounted into a container
+type Bind struct {
+	// absolute path on the host
+	Source string
+	// path in the container
+	Target   string
+	ReadOnly bool
+}
+
+// returns the host config for the options, nil if there is nothing to configure
+func (o ContainerOptions) hostConfig() *container.HostConfig {
+	if len(o.Tmpfs) == 0 && len(o.Volumes) == 0 && len(o.Binds) == 0 && o.CPUs == 0 && o.Memory == 0 {
+		return nil
+	}
+
+	hc := &container.HostConfig{
+		Tmpfs: o.Tmpfs,
+		Resources: container.Resources{
+			NanoCPUs: int64(o.CPUs * 1e9),
+			Memory:   o.Memory,
+		},
+	}
+
+	targets := []string{}
+	for target := range o.Volumes {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		hc.Mounts = append(hc.Mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: o.Volumes[target],
+			Target: target,
+		})
+	}
+
+	for _, b := range o.Binds {
+		hc.Mounts = append(hc.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   b.Source,
+			Target:   b.Target,
+			ReadOnly: b.ReadOnly,
+		})
+	}
+
+	return hc
+}
+
 type Container struct {
 	id  string
 	env map[string]string
@@ -14,11 +81,13 @@ func sanitizeEnvKey(key string) string {
 	return strings.TrimSpace(strings.ReplaceAll(key, " ", ""))
 }
 
-// returns a list of KEY=VALUE environment variable bindings for the container
+// returns a list of KEY=VALUE environment variable bindings for the container.
+// docker sets them on the exec'd process as-is, no shell parses them, so values are not quoted: a quoted
+// value would reach the process with its quotes, and a value containing a quote could not be represented
 func (c *Container) Env() []string {
 	env := []string{}
 	for key, value := range c.env {
-		env = append(env, fmt.Sprintf("%s='%s'", key, value))
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
 	return env
@@ -26,9 +95,18 @@ func (c *Container) Env() []string {
 
 // adds and environment variable with KEY and VALUE to container
 func (c *Container) AddEnv(key, value string) {
+	if c.env == nil {
+		c.env = map[string]string{}
+	}
 	c.env[sanitizeEnvKey(key)] = value
 }
 
+// returns the value of environment variable KEY, and whether it is set
+func (c *Container) LookupEnv(key string) (string, bool) {
+	v, ok := c.env[sanitizeEnvKey(key)]
+	return v, ok
+}
+
 // removes environment variable with KEY if it exists in container
 func (c *Container) RemoveEnv(key string) {
 	delete(c.env, sanitizeEnvKey(key))
@@ -56,7 +56,7 @@ func TestEnv(t *testing.T) {
 					"SOMEKEY": "SOMEVALUE",
 				},
 			},
-			expectedOutput: []string{"SOMEKEY='SOMEVALUE'"},
+			expectedOutput: []string{"SOMEKEY=SOMEVALUE"},
 		},
 		{
 			input: Container{
@@ -65,7 +65,7 @@ func TestEnv(t *testing.T) {
 					"ANOTHERKEY": "ANOTHERVALUE",
 				},
 			},
-			expectedOutput: []string{"SOMEKEY='SOMEVALUE'", "ANOTHERKEY='ANOTHERVALUE'"},
+			expectedOutput: []string{"SOMEKEY=SOMEVALUE", "ANOTHERKEY=ANOTHERVALUE"},
 		},
 		{
 			input: Container{
@@ -73,7 +73,15 @@ func TestEnv(t *testing.T) {
 					"SOMEKEY": "SOME VALUE WITH SPACES",
 				},
 			},
-			expectedOutput: []string{"SOMEKEY='SOME VALUE WITH SPACES'"},
+			expectedOutput: []string{"SOMEKEY=SOME VALUE WITH SPACES"},
+		},
+		{
+			input: Container{
+				env: map[string]string{
+					"SOMEKEY": `it's "quoted"`,
+				},
+			},
+			expectedOutput: []string{`SOMEKEY=it's "quoted"`},
 		},
 	}
 
@@ -98,6 +106,10 @@ func TestAddEnv(t *testing.T) {
 	assert.Equal(t, "newvalue", c.env["key"])
 
 	assert.Len(t, c.env, 2)
+
+	var empty Container
+	empty.AddEnv("key", "value")
+	assert.Equal(t, "value", empty.env["key"])
 }
 
 func TestRemoveEnv(t *testing.T) {
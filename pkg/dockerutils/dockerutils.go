@@ -7,11 +7,14 @@ import (
 	"io"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/notmiguelalves/anypipe/pkg/metrics"
 	"github.com/notmiguelalves/anypipe/pkg/utils"
 	"github.com/notmiguelalves/anypipe/pkg/wrapper"
 )
@@ -19,17 +22,30 @@ import (
 //go:generate mockgen -destination=dockerutils_mock.go -package=dockerutils -source=dockerutils.go DockerUtils
 type DockerUtils interface {
 	Close() error
+	PullImage(image string) error
+	ImageDigest(image string) (string, error)
 	CreateContainer(image string) (*Container, error)
+	CreateContainerWithOptions(image string, opts ContainerOptions) (*Container, error)
 	Exec(c *Container, cmd string) (stdout, stderr string, exitcode int, err error)
 	CopyTo(c *Container, srcPath, dstPath string) error
 	CopyFrom(c *Container, srcPath, dstPath string) error
 	CopyBetweenContainers(srcContainer, destContainer *Container, srcPath, dstPath string) error
 }
 
+// DockerUtilsImpl is safe for use by concurrently running jobs
 type DockerUtilsImpl struct {
+	mu                sync.Mutex
 	logger            *slog.Logger
 	dockerClient      wrapper.DockerClient
 	spawnedContainers []*Container
+	pulls             map[string]*imagePull
+	metrics           *dockerMetrics
+}
+
+// imagePull is a pull of an image, in progress or done. done is closed once it finished
+type imagePull struct {
+	done chan struct{}
+	err  error
 }
 
 // initializes a DockerUtils client - make sure to defer a call to Close() the client on exit
@@ -43,6 +59,8 @@ func New(ctx context.Context, logger *slog.Logger) (*DockerUtilsImpl, error) {
 	return &DockerUtilsImpl{
 		dockerClient: cli,
 		logger:       logger,
+		pulls:        map[string]*imagePull{},
+		metrics:      newDockerMetrics(metrics.NewRegistry()),
 	}, nil
 }
 
@@ -51,13 +69,25 @@ func NewWithClient(logger *slog.Logger, cli wrapper.DockerClient) *DockerUtilsIm
 	return &DockerUtilsImpl{
 		dockerClient: cli,
 		logger:       logger,
+		pulls:        map[string]*imagePull{},
+		metrics:      newDockerMetrics(metrics.NewRegistry()),
 	}
 }
 
+// records image pull times, exec counts and copied bytes in the given registry
+func (du *DockerUtilsImpl) WithMetrics(r *metrics.Registry) *DockerUtilsImpl {
+	du.metrics = newDockerMetrics(r)
+
+	return du
+}
+
 // closes the DockerUtils client, and removes all containers created by the client during program execution
 func (du *DockerUtilsImpl) Close() error {
 	du.logger.Debug("cleaning up spawned containers")
 
+	du.mu.Lock()
+	defer du.mu.Unlock()
+
 	for _, c := range du.spawnedContainers {
 		du.logger.Debug(fmt.Sprintf("going to cleanup %s", c.id))
 
@@ -75,8 +105,33 @@ func (du *DockerUtilsImpl) Close() error {
 	return du.dockerClient.Close()
 }
 
-// pull an image by ref. returns 'nil' if succeeds or if image is already present
+// pull an image by ref. returns 'nil' if succeeds or if image is already present.
+// an image is only pulled once during the lifetime of the client, concurrent calls for the same image wait for
+// the same pull. failed pulls are retried by later calls
+func (du *DockerUtilsImpl) PullImage(img string) error {
+	du.mu.Lock()
+	if p, ok := du.pulls[img]; ok {
+		du.mu.Unlock()
+		<-p.done
+		return p.err
+	}
+	p := &imagePull{done: make(chan struct{})}
+	du.pulls[img] = p
+	du.mu.Unlock()
+
+	p.err = du.pullImage(img)
+	if p.err != nil {
+		du.mu.Lock()
+		delete(du.pulls, img)
+		du.mu.Unlock()
+	}
+	close(p.done)
+
+	return p.err
+}
+
 func (du *DockerUtilsImpl) pullImage(img string) error {
+	startTime := time.Now()
 	rc, err := du.dockerClient.ImagePull(img, image.PullOptions{})
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to pull image %s : %s", img, err.Error()))
@@ -95,12 +150,33 @@ func (du *DockerUtilsImpl) pullImage(img string) error {
 		du.logger.Debug(strings.ReplaceAll(l, "\"", "'"))
 	}
 
+	du.metrics.pullDuration.Observe(time.Since(startTime).Seconds(), img)
 	return nil
 }
 
+// returns the repo digest of a pulled image (e.g. alpine@sha256:...), or its ID for images built locally
+func (du *DockerUtilsImpl) ImageDigest(img string) (string, error) {
+	inspect, err := du.dockerClient.ImageInspect(img)
+	if err != nil {
+		du.logger.Error(fmt.Sprintf("failed to inspect image %s : %s", img, err.Error()))
+		return "", err
+	}
+
+	if len(inspect.RepoDigests) > 0 {
+		return inspect.RepoDigests[0], nil
+	}
+
+	return inspect.ID, nil
+}
+
 // creates a container with the specified image
 func (du *DockerUtilsImpl) CreateContainer(image string) (*Container, error) {
-	err := du.pullImage(image)
+	return du.CreateContainerWithOptions(image, ContainerOptions{})
+}
+
+// creates a container with the specified image and options
+func (du *DockerUtilsImpl) CreateContainerWithOptions(image string, opts ContainerOptions) (*Container, error) {
+	err := du.PullImage(image)
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to pull image %s : %s", image, err.Error()))
 		return nil, err
@@ -110,7 +186,7 @@ func (du *DockerUtilsImpl) CreateContainer(image string) (*Container, error) {
 		Image: image,
 		Cmd:   []string{"sleep", "infinity"},
 		Tty:   false,
-	})
+	}, opts.hostConfig())
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to create container from '%s' : %s", image, err.Error()))
 		return nil, err
@@ -120,7 +196,9 @@ func (du *DockerUtilsImpl) CreateContainer(image string) (*Container, error) {
 		id:  resp.ID,
 		env: map[string]string{},
 	}
+	du.mu.Lock()
 	du.spawnedContainers = append(du.spawnedContainers, &c)
+	du.mu.Unlock()
 
 	du.logger.Debug(fmt.Sprintf("going to start container %s created from image %s", c.id, image))
 	if err := du.dockerClient.ContainerStart(c.id, container.StartOptions{}); err != nil {
@@ -132,13 +210,17 @@ func (du *DockerUtilsImpl) CreateContainer(image string) (*Container, error) {
 	return &c, nil
 }
 
+// working directory of the commands executed with Exec, relative paths in commands are resolved against it
+const WorkingDir = "/home"
+
 // executes the specified command on the provided container. Note: command will be executed with `sh -c <command>`
 func (du *DockerUtilsImpl) Exec(c *Container, cmd string) (stdout, stderr string, exitcode int, err error) {
 	du.logger.Debug(fmt.Sprintf("going to execute %s on container %s", cmd, c.id))
+	defer func() { du.metrics.execs.Inc(execStatus(exitcode, err)) }()
 
 	shcmd := []string{"sh", "-c", cmd}
 
-	resp, err := du.dockerClient.ContainerExecCreate(c.id, container.ExecOptions{Cmd: shcmd, Env: c.Env(), Detach: false, AttachStderr: true, AttachStdout: true, WorkingDir: "/home"})
+	resp, err := du.dockerClient.ContainerExecCreate(c.id, container.ExecOptions{Cmd: shcmd, Env: c.Env(), Detach: false, AttachStderr: true, AttachStdout: true, WorkingDir: WorkingDir})
 	if err != nil {
 		du.logger.Error("failed to create exec operation on container %s : %s", c.id, err.Error())
 		return
@@ -188,12 +270,14 @@ func (du *DockerUtilsImpl) CopyTo(c *Container, srcPath, dstPath string) error {
 		return err
 	}
 
+	size := buf.Len()
 	err = du.dockerClient.CopyToContainer(c.id, dstPath, buf, container.CopyToContainerOptions{})
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to copy %s to container %s : %s", srcPath, c.id, err.Error()))
 		return err
 	}
 
+	du.metrics.copiedBytes.Add(float64(size), "to")
 	return nil
 }
 
@@ -205,12 +289,14 @@ func (du *DockerUtilsImpl) CopyFrom(c *Container, srcPath, dstPath string) error
 		return err
 	}
 
-	err = utils.Untar(rc, dstPath)
+	cr := &countingReader{ReadCloser: rc}
+	err = utils.Untar(cr, dstPath)
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to untar %s : %s", srcPath, err.Error()))
 		return err
 	}
 
+	du.metrics.copiedBytes.Add(float64(cr.n), "from")
 	return nil
 }
 
@@ -222,11 +308,13 @@ func (du *DockerUtilsImpl) CopyBetweenContainers(srcContainer, destContainer *Co
 		return err
 	}
 
-	err = du.dockerClient.CopyToContainer(destContainer.id, dstPath, rc, container.CopyToContainerOptions{})
+	cr := &countingReader{ReadCloser: rc}
+	err = du.dockerClient.CopyToContainer(destContainer.id, dstPath, cr, container.CopyToContainerOptions{})
 	if err != nil {
 		du.logger.Error(fmt.Sprintf("failed to copy %s to container %s : %s", srcPath, destContainer.id, err.Error()))
 		return err
 	}
 
+	du.metrics.copiedBytes.Add(float64(cr.n), "between")
 	return nil
 }
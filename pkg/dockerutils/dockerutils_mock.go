@@ -109,6 +109,21 @@ func (mr *MockDockerUtilsMockRecorder) CreateContainer(image any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateContainer", reflect.TypeOf((*MockDockerUtils)(nil).CreateContainer), image)
 }
 
+// CreateContainerWithOptions mocks base method.
+func (m *MockDockerUtils) CreateContainerWithOptions(image string, opts ContainerOptions) (*Container, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateContainerWithOptions", image, opts)
+	ret0, _ := ret[0].(*Container)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateContainerWithOptions indicates an expected call of CreateContainerWithOptions.
+func (mr *MockDockerUtilsMockRecorder) CreateContainerWithOptions(image, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateContainerWithOptions", reflect.TypeOf((*MockDockerUtils)(nil).CreateContainerWithOptions), image, opts)
+}
+
 // Exec mocks base method.
 func (m *MockDockerUtils) Exec(c *Container, cmd string) (string, string, int, error) {
 	m.ctrl.T.Helper()
@@ -125,3 +140,32 @@ func (mr *MockDockerUtilsMockRecorder) Exec(c, cmd any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Exec", reflect.TypeOf((*MockDockerUtils)(nil).Exec), c, cmd)
 }
+
+// ImageDigest mocks base method.
+func (m *MockDockerUtils) ImageDigest(image string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageDigest", image)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageDigest indicates an expected call of ImageDigest.
+func (mr *MockDockerUtilsMockRecorder) ImageDigest(image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageDigest", reflect.TypeOf((*MockDockerUtils)(nil).ImageDigest), image)
+}
+
+// PullImage mocks base method.
+func (m *MockDockerUtils) PullImage(image string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PullImage", image)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PullImage indicates an expected call of PullImage.
+func (mr *MockDockerUtilsMockRecorder) PullImage(image any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PullImage", reflect.TypeOf((*MockDockerUtils)(nil).PullImage), image)
+}
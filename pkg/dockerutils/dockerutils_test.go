@@ -9,10 +9,14 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/notmiguelalves/anypipe/pkg/metrics"
 	"github.com/notmiguelalves/anypipe/pkg/wrapper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -82,7 +86,7 @@ func TestPullImage(t *testing.T) {
 
 		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(nil, errors.New("some error"))
 
-		assert.Error(t, du.pullImage("someref"))
+		assert.Error(t, du.PullImage("someref"))
 	})
 
 	t.Run("happy path", func(t *testing.T) {
@@ -91,10 +95,98 @@ func TestPullImage(t *testing.T) {
 
 		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
 
-		assert.NoError(t, du.pullImage("someref"))
+		assert.NoError(t, du.PullImage("someref"))
+	})
+
+	t.Run("only pulled once", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+
+		assert.NoError(t, du.PullImage("someref"))
+		assert.NoError(t, du.PullImage("someref"))
+	})
+
+	t.Run("concurrent pulls of the same image wait for the same pull", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		release := make(chan struct{})
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).DoAndReturn(func(string, image.PullOptions) (io.ReadCloser, error) {
+			<-release
+			return io.NopCloser(strings.NewReader("done")), nil
+		})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- du.PullImage("someref")
+			}()
+		}
+		close(release)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("failed pulls are retried", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(nil, errors.New("some error")),
+			mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil),
+		)
+
+		assert.Error(t, du.PullImage("someref"))
+		assert.NoError(t, du.PullImage("someref"))
+		assert.NoError(t, du.PullImage("someref"))
 	})
 }
 
+func TestImageDigest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	type testcase struct {
+		name           string
+		inspect        types.ImageInspect
+		inspectErr     error
+		expectedDigest string
+		expectedError  bool
+	}
+
+	testcases := []testcase{
+		{name: "failed to inspect", inspectErr: errors.New("some error"), expectedError: true},
+		{name: "pulled image", inspect: types.ImageInspect{ID: "sha256:123", RepoDigests: []string{"someref@sha256:abc"}}, expectedDigest: "someref@sha256:abc"},
+		{name: "local image", inspect: types.ImageInspect{ID: "sha256:123"}, expectedDigest: "sha256:123"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockClient := wrapper.NewMockDockerClient(ctrl)
+			du := NewWithClient(testLogger, mockClient)
+
+			mockClient.EXPECT().ImageInspect("someref").Times(1).Return(tc.inspect, tc.inspectErr)
+
+			digest, err := du.ImageDigest("someref")
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDigest, digest)
+		})
+	}
+}
+
 func TestCreateContainer(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
@@ -118,7 +210,7 @@ func TestCreateContainer(t *testing.T) {
 			Image: "someref",
 			Cmd:   []string{"sleep", "infinity"},
 			Tty:   false,
-		}).Times(1).Return(container.CreateResponse{}, errors.New("some error"))
+		}, nil).Times(1).Return(container.CreateResponse{}, errors.New("some error"))
 
 		_, err := du.CreateContainer("someref")
 		assert.Error(t, err)
@@ -134,7 +226,7 @@ func TestCreateContainer(t *testing.T) {
 			Image: "someref",
 			Cmd:   []string{"sleep", "infinity"},
 			Tty:   false,
-		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+		}, nil).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
 
 		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(errors.New("some error"))
 
@@ -154,7 +246,7 @@ func TestCreateContainer(t *testing.T) {
 			Image: "someref",
 			Cmd:   []string{"sleep", "infinity"},
 			Tty:   false,
-		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+		}, nil).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
 
 		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(nil)
 
@@ -164,6 +256,99 @@ func TestCreateContainer(t *testing.T) {
 		assert.Equal(t, "123", c.id)
 		assert.Len(t, du.spawnedContainers, 1)
 	})
+
+	t.Run("with tmpfs", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+
+		mockClient.EXPECT().ContainerCreate(&container.Config{
+			Image: "someref",
+			Cmd:   []string{"sleep", "infinity"},
+			Tty:   false,
+		}, &container.HostConfig{
+			Tmpfs: map[string]string{"/run/secrets": "size=1m"},
+		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+
+		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(nil)
+
+		_, err := du.CreateContainerWithOptions("someref", ContainerOptions{Tmpfs: map[string]string{"/run/secrets": "size=1m"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("with volumes", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+
+		mockClient.EXPECT().ContainerCreate(&container.Config{
+			Image: "someref",
+			Cmd:   []string{"sleep", "infinity"},
+			Tty:   false,
+		}, &container.HostConfig{
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+				{Type: mount.TypeVolume, Source: "data", Target: "/data"},
+			},
+		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+
+		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(nil)
+
+		_, err := du.CreateContainerWithOptions("someref", ContainerOptions{Volumes: map[string]string{"/data": "data", "/cache": "cache"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("with binds", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+
+		mockClient.EXPECT().ContainerCreate(&container.Config{
+			Image: "someref",
+			Cmd:   []string{"sleep", "infinity"},
+			Tty:   false,
+		}, &container.HostConfig{
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+				{Type: mount.TypeBind, Source: "/src", Target: "/workspace"},
+				{Type: mount.TypeBind, Source: "/etc/app", Target: "/config", ReadOnly: true},
+			},
+		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+
+		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(nil)
+
+		_, err := du.CreateContainerWithOptions("someref", ContainerOptions{
+			Volumes: map[string]string{"/cache": "cache"},
+			Binds: []Bind{
+				{Source: "/src", Target: "/workspace"},
+				{Source: "/etc/app", Target: "/config", ReadOnly: true},
+			},
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("with resource limits", func(t *testing.T) {
+		mockClient := wrapper.NewMockDockerClient(ctrl)
+		du := NewWithClient(testLogger, mockClient)
+
+		mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+
+		mockClient.EXPECT().ContainerCreate(&container.Config{
+			Image: "someref",
+			Cmd:   []string{"sleep", "infinity"},
+			Tty:   false,
+		}, &container.HostConfig{
+			Resources: container.Resources{NanoCPUs: 1500000000, Memory: 512 << 20},
+		}).Times(1).Return(container.CreateResponse{ID: "123"}, nil)
+
+		mockClient.EXPECT().ContainerStart("123", gomock.Any()).Times(1).Return(nil)
+
+		_, err := du.CreateContainerWithOptions("someref", ContainerOptions{CPUs: 1.5, Memory: 512 << 20})
+		assert.NoError(t, err)
+	})
 }
 
 func TestExec(t *testing.T) {
@@ -356,3 +541,36 @@ func TestCopyBetweenContainers(t *testing.T) {
 		assert.NoError(t, du.CopyBetweenContainers(c1, c2, "/home/somefile", "/home"))
 	})
 }
+
+func TestMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	testLogger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	mockClient := wrapper.NewMockDockerClient(ctrl)
+	r := metrics.NewRegistry()
+	du := NewWithClient(testLogger, mockClient).WithMetrics(r)
+	c := &Container{id: "123"}
+
+	mockClient.EXPECT().ImagePull("someref", gomock.Any()).Times(1).Return(io.NopCloser(strings.NewReader("done")), nil)
+	mockClient.EXPECT().CopyToContainer("123", "/dst/tmp.txt", gomock.Any(), container.CopyToContainerOptions{}).Times(1).Return(nil)
+	mockClient.EXPECT().ContainerExecCreate("123", gomock.Any()).Times(1).Return(types.IDResponse{}, errors.New("some error"))
+
+	assert.NoError(t, du.PullImage("someref"))
+	assert.NoError(t, du.CopyTo(c, "../utils/testdata/tmp.txt", "/dst/tmp.txt"))
+	_, _, _, err := du.Exec(c, "echo test")
+	assert.Error(t, err)
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err = r.WriteTo(buf)
+	assert.NoError(t, err)
+
+	assert.Contains(t, buf.String(), `anypipe_docker_image_pull_duration_seconds_count{image="someref"} 1`)
+	assert.Contains(t, buf.String(), `anypipe_docker_copied_bytes_total{direction="to"} 2048`)
+	assert.Contains(t, buf.String(), `anypipe_docker_execs_total{status="error"} 1`)
+}
+
+func TestExecStatus(t *testing.T) {
+	assert.Equal(t, "ok", execStatus(0, nil))
+	assert.Equal(t, "failed", execStatus(2, nil))
+	assert.Equal(t, "error", execStatus(0, errors.New("some error")))
+}
@@ -0,0 +1,47 @@
+package dockerutils
+
+import (
+	"io"
+
+	"github.com/notmiguelalves/anypipe/pkg/metrics"
+)
+
+// metrics recorded by DockerUtilsImpl
+type dockerMetrics struct {
+	pullDuration *metrics.HistogramVec
+	execs        *metrics.CounterVec
+	copiedBytes  *metrics.CounterVec
+}
+
+func newDockerMetrics(r *metrics.Registry) *dockerMetrics {
+	return &dockerMetrics{
+		pullDuration: r.Histogram("anypipe_docker_image_pull_duration_seconds", "Time spent pulling images.", nil, "image"),
+		execs:        r.Counter("anypipe_docker_execs_total", "Commands executed in containers, by status (ok, failed or error).", "status"),
+		copiedBytes:  r.Counter("anypipe_docker_copied_bytes_total", "Bytes copied to, from or between containers.", "direction"),
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+
+	return n, err
+}
+
+// returns the status label of an exec operation
+func execStatus(exitcode int, err error) string {
+	switch {
+	case err != nil:
+		return "error"
+	case exitcode != 0:
+		return "failed"
+	default:
+		return "ok"
+	}
+}
@@ -0,0 +1,216 @@
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// directory runs are recorded in when none is given, relative to the working directory
+const DefaultDir = ".anypipe/history"
+
+// runs are appended to this file of the store's directory, one JSON document per line
+const runsFile = "runs.jsonl"
+
+var ErrNotFound = errors.New("run not found")
+
+// Run is the record of a pipeline run
+type Run struct {
+	ID        string        `json:"id"`
+	Pipeline  string        `json:"pipeline"`
+	Result    string        `json:"result"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	CommitSHA string        `json:"commitSha,omitempty"`
+	Branch    string        `json:"branch,omitempty"`
+	Jobs      []Job         `json:"jobs"`
+}
+
+// Job is the record of a job of a pipeline run
+type Job struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"`
+	Reason   string        `json:"reason,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Steps    []Step        `json:"steps,omitempty"`
+}
+
+// Step is the record of a step of a job
+type Step struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Filter selects runs, zero values select everything
+type Filter struct {
+	Pipeline string
+	// runs that started at or after Since
+	Since time.Time
+	// the most recent runs only
+	Limit int
+}
+
+func (f Filter) matches(r Run) bool {
+	if len(f.Pipeline) > 0 && r.Pipeline != f.Pipeline {
+		return false
+	}
+
+	return !r.Start.Before(f.Since)
+}
+
+// Store keeps the runs in a JSON lines file of a local directory. It is safe for concurrent use,
+// and several processes can record runs in the same directory
+type Store struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+func (s *Store) path() string {
+	return filepath.Join(s.Dir, runsFile)
+}
+
+// appends r to the store, with a new ID unless it has one already
+func (s *Store) Record(r Run) (Run, error) {
+	if len(r.ID) == 0 {
+		id, err := NewID(r.Start)
+		if err != nil {
+			return r, err
+		}
+		r.ID = id
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return r, err
+	}
+
+	// a single write of a whole line, so concurrent writers don't interleave
+	f, err := os.OpenFile(s.path(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return r, err
+	}
+
+	return r, f.Close()
+}
+
+// returns the runs selected by f, most recent first
+func (s *Store) Runs(f Filter) ([]Run, error) {
+	runs, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []Run{}
+	for _, r := range runs {
+		if f.matches(r) {
+			selected = append(selected, r)
+		}
+	}
+
+	sort.SliceStable(selected, func(i, k int) bool { return selected[i].Start.After(selected[k].Start) })
+	if f.Limit > 0 && len(selected) > f.Limit {
+		selected = selected[:f.Limit]
+	}
+
+	return selected, nil
+}
+
+// returns the run with the given ID, or the only run whose ID starts with it
+func (s *Store) Run(id string) (Run, error) {
+	runs, err := s.read()
+	if err != nil {
+		return Run{}, err
+	}
+
+	matches := []Run{}
+	for _, r := range runs {
+		if r.ID == id {
+			return r, nil
+		}
+		if len(id) > 0 && strings.HasPrefix(r.ID, id) {
+			matches = append(matches, r)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return Run{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Run{}, fmt.Errorf("run id %s is ambiguous, it matches %d runs", id, len(matches))
+	}
+}
+
+// returns the statistics of each step over the runs selected by f
+func (s *Store) StepStats(f Filter) ([]StepStats, error) {
+	runs, err := s.Runs(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return Stats(runs), nil
+}
+
+// reads every run of the store. lines that can't be decoded, e.g. a run cut short while it was written, are skipped
+func (s *Store) read() ([]Run, error) {
+	f, err := os.Open(s.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return []Run{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs := []Run{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var r Run
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, scanner.Err()
+}
+
+// returns a new run ID. IDs sort by the time the run started, the random suffix keeps them unique
+func NewID(start time.Time) (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s", start.UTC().Format("20060102T150405"), hex.EncodeToString(b)), nil
+}
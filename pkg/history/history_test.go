@@ -0,0 +1,163 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a run of pipeline started at start, whose test step had the given result and duration
+func testRun(pipeline string, start time.Time, result string, duration time.Duration) Run {
+	return Run{
+		Pipeline: pipeline,
+		Result:   result,
+		Start:    start,
+		Duration: duration,
+		Jobs: []Job{
+			{Name: "build", Result: "PASS", Steps: []Step{{Name: "compile", Result: "PASS", Duration: time.Second}}},
+			{Name: "test", Result: result, Steps: []Step{{Name: "unit", Result: result, Duration: duration}}},
+		},
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "history"))
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+
+	r, err := s.Record(testRun("ci", start, "PASS", time.Minute))
+	assert.NoError(t, err)
+	assert.Regexp(t, `^20240801T100000-[0-9a-f]{6}$`, r.ID)
+
+	got, err := s.Run(r.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, r.ID, got.ID)
+	assert.Equal(t, "ci", got.Pipeline)
+	assert.True(t, start.Equal(got.Start))
+	assert.Equal(t, r.Jobs, got.Jobs)
+
+	// a unique prefix is enough
+	got, err = s.Run(r.ID[:len(r.ID)-2])
+	assert.NoError(t, err)
+	assert.Equal(t, r.ID, got.ID)
+
+	_, err = s.Run("nope")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// runs keep their ID
+	r2, err := s.Record(Run{ID: "custom", Pipeline: "ci", Start: start})
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", r2.ID)
+
+	_, err = s.Record(testRun("ci", start, "FAIL", time.Minute))
+	assert.NoError(t, err)
+	_, err = s.Run("20240801")
+	assert.ErrorContains(t, err, "ambiguous")
+}
+
+func TestRuns(t *testing.T) {
+	s := NewStore(t.TempDir())
+	now := time.Now()
+
+	for i, pipeline := range []string{"ci", "release", "ci", "ci"} {
+		_, err := s.Record(Run{ID: pipeline + string(rune('a'+i)), Pipeline: pipeline, Start: now.Add(time.Duration(i) * time.Hour)})
+		assert.NoError(t, err)
+	}
+
+	// a run cut short while it was written is skipped
+	f, err := os.OpenFile(filepath.Join(s.Dir, runsFile), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"id":"trunc`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	type testcase struct {
+		name     string
+		filter   Filter
+		expected []string
+	}
+
+	testcases := []testcase{
+		{name: "everything, most recent first", filter: Filter{}, expected: []string{"cid", "cic", "releaseb", "cia"}},
+		{name: "by pipeline", filter: Filter{Pipeline: "ci"}, expected: []string{"cid", "cic", "cia"}},
+		{name: "since", filter: Filter{Since: now.Add(90 * time.Minute)}, expected: []string{"cid", "cic"}},
+		{name: "limit", filter: Filter{Pipeline: "ci", Limit: 2}, expected: []string{"cid", "cic"}},
+		{name: "nothing matches", filter: Filter{Pipeline: "deploy"}, expected: []string{}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			runs, err := s.Runs(tc.filter)
+			assert.NoError(t, err)
+
+			ids := []string{}
+			for _, r := range runs {
+				ids = append(ids, r.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestRunsEmptyStore(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "missing"))
+
+	runs, err := s.Runs(Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, runs)
+}
+
+func TestStepStats(t *testing.T) {
+	s := NewStore(t.TempDir())
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+
+	results := []string{"PASS", "FAIL", "PASS", "FAIL", "PASS", "PASS", "PASS", "PASS", "PASS", "PASS"}
+	for i, result := range results {
+		_, err := s.Record(testRun("ci", start.Add(time.Duration(i)*time.Hour), result, time.Duration(i+1)*time.Second))
+		assert.NoError(t, err)
+	}
+	// skipped steps are left out
+	skipped := testRun("ci", start.Add(time.Minute), "SKIP", 0)
+	_, err := s.Record(skipped)
+	assert.NoError(t, err)
+
+	stats, err := s.StepStats(Filter{Pipeline: "ci"})
+	assert.NoError(t, err)
+	assert.Equal(t, []StepStats{
+		{Pipeline: "ci", Job: "build", Step: "compile", Runs: 11, Passed: 11, PassRate: 1, P50: time.Second, P95: time.Second},
+		{Pipeline: "ci", Job: "test", Step: "unit", Runs: 10, Passed: 8, Failed: 2, PassRate: 0.8, P50: 5 * time.Second, P95: 10 * time.Second, Flakiness: 4.0 / 9},
+	}, stats)
+}
+
+func TestFlakiness(t *testing.T) {
+	start := time.Now()
+
+	type testcase struct {
+		name     string
+		results  []string
+		expected float64
+	}
+
+	testcases := []testcase{
+		{name: "single run", results: []string{"FAIL"}, expected: 0},
+		{name: "always passes", results: []string{"PASS", "PASS", "PASS"}, expected: 0},
+		{name: "broken from some point on", results: []string{"PASS", "PASS", "FAIL", "FAIL", "FAIL"}, expected: 0.25},
+		{name: "alternates", results: []string{"PASS", "FAIL", "PASS", "FAIL"}, expected: 1},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			runs := []Run{}
+			// in reverse, executions are ordered by the time the run started
+			for i := len(tc.results) - 1; i >= 0; i-- {
+				runs = append(runs, testRun("ci", start.Add(time.Duration(i)*time.Minute), tc.results[i], time.Second))
+			}
+
+			stats := Stats(runs)
+			assert.Len(t, stats, 2)
+			assert.InDelta(t, tc.expected, stats[1].Flakiness, 1e-9)
+		})
+	}
+}
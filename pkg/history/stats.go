@@ -0,0 +1,109 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StepStats summarises the outcomes of a step over several runs.
+// skipped and cached executions say nothing about the step, so they are left out
+type StepStats struct {
+	Pipeline string
+	Job      string
+	Step     string
+	// executions of the step that passed or failed
+	Runs   int
+	Passed int
+	Failed int
+	// fraction of the runs that passed, between 0 and 1
+	PassRate float64
+	P50      time.Duration
+	P95      time.Duration
+	// how often the result changed from one run to the next, between 0 (never) and 1 (every run).
+	// a step that fails from some point on changes once, a flaky one keeps changing
+	Flakiness float64
+}
+
+type stepKey struct {
+	pipeline, job, step string
+}
+
+type execution struct {
+	start    time.Time
+	passed   bool
+	duration time.Duration
+}
+
+// computes the statistics of each step over runs, sorted by pipeline, job and step
+func Stats(runs []Run) []StepStats {
+	executions := map[stepKey][]execution{}
+	for _, r := range runs {
+		for _, j := range r.Jobs {
+			for _, s := range j.Steps {
+				if s.Result != "PASS" && s.Result != "FAIL" {
+					continue
+				}
+
+				k := stepKey{pipeline: r.Pipeline, job: j.Name, step: s.Name}
+				executions[k] = append(executions[k], execution{start: r.Start, passed: s.Result == "PASS", duration: s.Duration})
+			}
+		}
+	}
+
+	stats := []StepStats{}
+	for k, execs := range executions {
+		sort.SliceStable(execs, func(i, j int) bool { return execs[i].start.Before(execs[j].start) })
+
+		s := StepStats{Pipeline: k.pipeline, Job: k.job, Step: k.step, Runs: len(execs)}
+		durations := []time.Duration{}
+		flips := 0
+		for i, e := range execs {
+			if e.passed {
+				s.Passed++
+			} else {
+				s.Failed++
+			}
+			if i > 0 && e.passed != execs[i-1].passed {
+				flips++
+			}
+			durations = append(durations, e.duration)
+		}
+
+		s.PassRate = float64(s.Passed) / float64(s.Runs)
+		if s.Runs > 1 {
+			s.Flakiness = float64(flips) / float64(s.Runs-1)
+		}
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		s.P50 = percentile(durations, 50)
+		s.P95 = percentile(durations, 95)
+
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Pipeline != stats[j].Pipeline {
+			return stats[i].Pipeline < stats[j].Pipeline
+		}
+		if stats[i].Job != stats[j].Job {
+			return stats[i].Job < stats[j].Job
+		}
+		return stats[i].Step < stats[j].Step
+	})
+
+	return stats
+}
+
+// nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
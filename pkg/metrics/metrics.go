@@ -0,0 +1,262 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// default histogram buckets, in seconds
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	histogramType metricType = "histogram"
+)
+
+type series struct {
+	labelValues []string
+	// counter value, or histogram sum
+	value float64
+	// histogram only: cumulative bucket counts and total count
+	buckets []uint64
+	count   uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// Registry holds counters and histograms, and renders them in the Prometheus text format.
+// It is safe for concurrent use
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: map[string]*family{},
+	}
+}
+
+func (r *Registry) family(name, help string, typ metricType, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+
+	return f
+}
+
+// returns the series for the label values, creating it if needed. must be called with r.mu held
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labelValues: append([]string{}, labelValues...),
+			buckets:     make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+type CounterVec struct {
+	r *Registry
+	f *family
+}
+
+// returns the counter with the given name, registering it if needed
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r: r, f: r.family(name, help, counterType, nil, labels)}
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+
+	c.f.get(labelValues).value += v
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+type HistogramVec struct {
+	r *Registry
+	f *family
+}
+
+// returns the histogram with the given name, registering it if needed. buckets defaults to DefaultBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	return &HistogramVec{r: r, f: r.family(name, help, histogramType, buckets, labels)}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, names[i], escapeLabelValue(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := bytes.NewBuffer([]byte{})
+
+	names := []string{}
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.typ)
+
+		keys := []string{}
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			s := f.series[k]
+			if f.typ == counterType {
+				fmt.Fprintf(buf, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+				continue
+			}
+
+			for i, upper := range f.buckets {
+				fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatFloat(upper)), s.buckets[i])
+			}
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+			fmt.Fprintf(buf, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues), s.count)
+		}
+	}
+
+	return buf.WriteTo(w)
+}
+
+// serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// returns a server exposing the metrics on /metrics, for long-running modes. start it with ListenAndServe()
+func (r *Registry) Server(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}
+
+// writes the metrics to path for the node-exporter textfile collector. the file is replaced atomically,
+// so the collector never reads a partially written file
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
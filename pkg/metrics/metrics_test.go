@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.Counter("test_total", "A counter.", "name")
+	c.Inc("b")
+	c.Add(2.5, "a")
+	c.Inc("b")
+
+	h := r.Histogram("test_seconds", "A histogram.", []float64{1, 5}, "name")
+	h.Observe(0.5, "x")
+	h.Observe(3, "x")
+	h.Observe(10, "x")
+
+	r.Counter("unlabelled_total", "No labels.").Inc()
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err := r.WriteTo(buf)
+	assert.NoError(t, err)
+
+	expected := `# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{name="x",le="1"} 1
+test_seconds_bucket{name="x",le="5"} 2
+test_seconds_bucket{name="x",le="+Inf"} 3
+test_seconds_sum{name="x"} 13.5
+test_seconds_count{name="x"} 3
+# HELP test_total A counter.
+# TYPE test_total counter
+test_total{name="a"} 2.5
+test_total{name="b"} 2
+# HELP unlabelled_total No labels.
+# TYPE unlabelled_total counter
+unlabelled_total 1
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("test_total", "A counter.", "name").Inc("a")
+	r.Counter("test_total", "A counter.", "name").Inc("a")
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err := r.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `test_total{name="a"} 2`)
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "A counter.", "name").Inc("a \"quoted\"\\\nvalue")
+
+	buf := bytes.NewBuffer([]byte{})
+	_, err := r.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `test_total{name="a \"quoted\"\\\nvalue"} 1`)
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	r := NewRegistry()
+	assert.Panics(t, func() { r.Counter("test_total", "A counter.", "name").Inc() })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "A counter.").Inc()
+
+	srv := httptest.NewServer(r.Server("").Handler)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	assert.Contains(t, string(body), "test_total 1")
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "anypipe.prom")
+
+	r := NewRegistry()
+	r.Counter("test_total", "A counter.").Inc()
+
+	assert.NoError(t, r.WriteTextfile(path))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), "test_total 1")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// DefaultSubjectTemplate is the subject of emails when Email.Subject is empty
+const DefaultSubjectTemplate = `[{{ .Result }}] pipeline {{ .Pipeline }}`
+
+// Email sends the rendered template as a plain text email through an SMTP server
+type Email struct {
+	// host:port of the SMTP server
+	Addr string
+	// nil to send without authentication
+	Auth    smtp.Auth
+	From    string
+	To      []string
+	Subject string
+	// DefaultTemplate if empty
+	Template string
+}
+
+func NewEmail(addr string, auth smtp.Auth, from string, to ...string) *Email {
+	return &Email{Addr: addr, Auth: auth, From: from, To: to}
+}
+
+func (e *Email) Notify(ctx context.Context, r Result) error {
+	subjectTmpl := e.Subject
+	if len(subjectTmpl) == 0 {
+		subjectTmpl = DefaultSubjectTemplate
+	}
+	subject, err := render(subjectTmpl, r)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	body, err := render(e.Template, r)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	msg := strings.Builder{}
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	// header values can't span lines
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.Join(strings.Fields(subject), " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	// smtp.SendMail doesn't take a context, the send is abandoned once ctx is done
+	errs := make(chan error, 1)
+	go func() { errs <- smtp.SendMail(e.Addr, e.Auth, e.From, e.To, []byte(msg.String())) }()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errs:
+		return err
+	}
+}
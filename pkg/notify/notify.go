@@ -0,0 +1,171 @@
+// Package notify sends the outcome of a pipeline run to webhooks, chat tools and email
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ResultPass      = "PASS"
+	ResultFail      = "FAIL"
+	ResultCancelled = "CANCELLED"
+)
+
+// DefaultTemplate renders a short summary of the run, listing the jobs that did not pass
+const DefaultTemplate = `pipeline {{ .Pipeline }} {{ .Result }} in {{ .Duration }}
+{{- with .BuildURL }} ({{ . }}){{ end }}
+{{- range .Jobs }}{{ if ne .Result "PASS" }}
+- {{ .Job }}: {{ .Result }}{{ with .Reason }} ({{ . }}){{ end }}{{ end }}{{ end }}`
+
+// Result is the outcome of a pipeline run, as seen by notifiers and their templates
+type Result struct {
+	Pipeline string
+	// one of ResultPass, ResultFail or ResultCancelled
+	Result   string
+	Duration time.Duration
+	Error    string
+	Jobs     []JobResult
+	// from the CI provider, empty when running locally
+	CommitSHA string
+	Branch    string
+	BuildURL  string
+}
+
+type JobResult struct {
+	Job    string
+	Result string
+	Reason string
+}
+
+func (r Result) Failed() bool {
+	return r.Result != ResultPass
+}
+
+// Notifier delivers the result of a run
+type Notifier interface {
+	Notify(ctx context.Context, r Result) error
+}
+
+// When decides which runs a notifier is told about
+type When string
+
+const (
+	Always    When = "always"
+	OnFailure When = "on-failure"
+)
+
+// returns whether a notifier registered for w is told about r
+func (w When) Matches(r Result) bool {
+	return w != OnFailure || r.Failed()
+}
+
+// renders tmpl, DefaultTemplate if empty, over the result
+func render(tmpl string, r Result) (string, error) {
+	if len(tmpl) == 0 {
+		tmpl = DefaultTemplate
+	}
+
+	t, err := template.New("notification").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	b := strings.Builder{}
+	if err := t.Execute(&b, r); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// an error that won't go away by retrying, e.g. a rejected payload
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// posts payload as JSON to url. 4xx responses (except 429) are permanent errors
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+
+	return err
+}
+
+// Retrying retries a notifier with exponential backoff, unless the error is permanent
+type Retrying struct {
+	Notifier Notifier
+	// total number of attempts
+	Attempts int
+	// wait before the first retry, doubled for every following one
+	Backoff time.Duration
+}
+
+func WithRetries(n Notifier, attempts int, backoff time.Duration) *Retrying {
+	return &Retrying{Notifier: n, Attempts: attempts, Backoff: backoff}
+}
+
+func (rn *Retrying) Notify(ctx context.Context, r Result) error {
+	backoff := rn.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = rn.Notifier.Notify(ctx, r)
+		var perr *permanentError
+		if err == nil || errors.As(err, &perr) || attempt >= rn.Attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
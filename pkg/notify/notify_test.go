@@ -0,0 +1,283 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var failedRun = Result{
+	Pipeline: "release",
+	Result:   ResultFail,
+	Duration: 90 * time.Second,
+	Error:    "job test failed",
+	Jobs: []JobResult{
+		{Job: "build", Result: ResultPass},
+		{Job: "test", Result: ResultFail, Reason: "job failed"},
+		{Job: "deploy", Result: "NOT RUN", Reason: "job test failed (finish-running)"},
+	},
+	BuildURL: "https://ci.example.com/builds/42",
+}
+
+func TestRender(t *testing.T) {
+	text, err := render("", failedRun)
+	assert.NoError(t, err)
+	assert.Equal(t, `pipeline release FAIL in 1m30s (https://ci.example.com/builds/42)
+- test: FAIL (job failed)
+- deploy: NOT RUN (job test failed (finish-running))`, text)
+
+	text, err = render("{{ .Pipeline }} {{ len .Jobs }}", failedRun)
+	assert.NoError(t, err)
+	assert.Equal(t, "release 3", text)
+
+	_, err = render("{{ .Missing }}", failedRun)
+	assert.Error(t, err)
+}
+
+func TestWhen(t *testing.T) {
+	passed := Result{Result: ResultPass}
+
+	assert.True(t, Always.Matches(passed))
+	assert.True(t, Always.Matches(failedRun))
+	assert.False(t, OnFailure.Matches(passed))
+	assert.True(t, OnFailure.Matches(failedRun))
+	assert.True(t, OnFailure.Matches(Result{Result: ResultCancelled}))
+}
+
+// records the body and headers of every request, answering with the given status codes in turn
+func recordingServer(t *testing.T, statuses ...int) (*httptest.Server, *[]map[string]interface{}, *[]http.Header) {
+	bodies := []map[string]interface{}{}
+	headers := []http.Header{}
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies = append(bodies, body)
+		headers = append(headers, r.Header)
+
+		status := http.StatusOK
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &bodies, &headers
+}
+
+func TestNotifiers(t *testing.T) {
+	type testcase struct {
+		name     string
+		notifier func(url string) Notifier
+		expected map[string]interface{}
+	}
+
+	testcases := []testcase{
+		{
+			name:     "webhook posts the result",
+			notifier: func(url string) Notifier { return NewWebhook(url) },
+			expected: map[string]interface{}{
+				"Pipeline": "release", "Result": "FAIL", "Duration": float64(90 * time.Second), "Error": "job test failed",
+				"Jobs": []interface{}{
+					map[string]interface{}{"Job": "build", "Result": "PASS", "Reason": ""},
+					map[string]interface{}{"Job": "test", "Result": "FAIL", "Reason": "job failed"},
+					map[string]interface{}{"Job": "deploy", "Result": "NOT RUN", "Reason": "job test failed (finish-running)"},
+				},
+				"CommitSHA": "", "Branch": "", "BuildURL": "https://ci.example.com/builds/42",
+			},
+		},
+		{
+			name: "webhook posts the rendered template",
+			notifier: func(url string) Notifier {
+				w := NewWebhook(url)
+				w.Template = "{{ .Pipeline }}: {{ .Result }}"
+				return w
+			},
+			expected: map[string]interface{}{"text": "release: FAIL"},
+		},
+		{
+			name: "slack",
+			notifier: func(url string) Notifier {
+				s := NewSlack(url)
+				s.Template = "{{ .Error }}"
+				return s
+			},
+			expected: map[string]interface{}{
+				"text": "pipeline release: FAIL",
+				"attachments": []interface{}{
+					map[string]interface{}{"color": "#E01E5A", "text": "job test failed", "fallback": "job test failed"},
+				},
+			},
+		},
+		{
+			name: "teams",
+			notifier: func(url string) Notifier {
+				tn := NewTeams(url)
+				tn.Template = "{{ .Error }}\n{{ .BuildURL }}"
+				return tn
+			},
+			expected: map[string]interface{}{
+				"@type":      "MessageCard",
+				"@context":   "https://schema.org/extensions",
+				"themeColor": "E01E5A",
+				"summary":    "pipeline release: FAIL",
+				"title":      "pipeline release: FAIL",
+				"text":       "job test failed  \nhttps://ci.example.com/builds/42",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, bodies, headers := recordingServer(t)
+
+			assert.NoError(t, tc.notifier(srv.URL).Notify(context.Background(), failedRun))
+			assert.Equal(t, []map[string]interface{}{tc.expected}, *bodies)
+			assert.Equal(t, "application/json", (*headers)[0].Get("Content-Type"))
+		})
+	}
+}
+
+func TestWebhookHeaders(t *testing.T) {
+	srv, _, headers := recordingServer(t)
+
+	w := NewWebhook(srv.URL)
+	w.Headers["Authorization"] = "Bearer token"
+	assert.NoError(t, w.Notify(context.Background(), failedRun))
+	assert.Equal(t, "Bearer token", (*headers)[0].Get("Authorization"))
+}
+
+func TestRetries(t *testing.T) {
+	type testcase struct {
+		name          string
+		statuses      []int
+		attempts      int
+		expectedCalls int
+		expectedErr   bool
+	}
+
+	testcases := []testcase{
+		{
+			name:          "retries server errors",
+			statuses:      []int{http.StatusBadGateway, http.StatusTooManyRequests},
+			attempts:      3,
+			expectedCalls: 3,
+		},
+		{
+			name:          "gives up after the last attempt",
+			statuses:      []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			attempts:      2,
+			expectedCalls: 2,
+			expectedErr:   true,
+		},
+		{
+			name:          "doesn't retry rejected payloads",
+			statuses:      []int{http.StatusBadRequest},
+			attempts:      3,
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, bodies, _ := recordingServer(t, tc.statuses...)
+
+			err := WithRetries(NewWebhook(srv.URL), tc.attempts, time.Millisecond).Notify(context.Background(), failedRun)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, *bodies, tc.expectedCalls)
+		})
+	}
+}
+
+// a minimal SMTP server accepting a single message, without extensions
+func smtpServer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 1)
+	var served atomic.Bool
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil || served.Swap(true) {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ready\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case "DATA":
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				msg := strings.Builder{}
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				messages <- msg.String()
+				fmt.Fprint(conn, "250 ok\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), messages
+}
+
+func TestEmail(t *testing.T) {
+	addr, messages := smtpServer(t)
+
+	e := NewEmail(addr, nil, "ci@example.com", "dev@example.com", "ops@example.com")
+	e.Template = "{{ .Error }}\nsee {{ .BuildURL }}"
+	assert.NoError(t, e.Notify(context.Background(), failedRun))
+
+	msg := <-messages
+	header, body, _ := strings.Cut(msg, "\r\n\r\n")
+	assert.Contains(t, header, "From: ci@example.com\r\n")
+	assert.Contains(t, header, "To: dev@example.com, ops@example.com\r\n")
+	assert.Contains(t, header, "Subject: [FAIL] pipeline release\r\n")
+	assert.Equal(t, "job test failed\r\nsee https://ci.example.com/builds/42\r\n", body)
+}
+
+func TestEmailUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	err = NewEmail(addr, nil, "ci@example.com", "dev@example.com").Notify(context.Background(), failedRun)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Webhook posts the result as JSON, or the rendered template as {"text": ...} when one is set
+type Webhook struct {
+	URL      string
+	Headers  map[string]string
+	Template string
+	Client   *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{URL: url, Headers: map[string]string{}}
+}
+
+func (w *Webhook) Notify(ctx context.Context, r Result) error {
+	if len(w.Template) == 0 {
+		return postJSON(ctx, w.Client, w.URL, w.Headers, r)
+	}
+
+	text, err := render(w.Template, r)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	return postJSON(ctx, w.Client, w.URL, w.Headers, map[string]string{"text": text})
+}
+
+// Slack posts the rendered template to a Slack incoming webhook, or any service accepting its payload
+type Slack struct {
+	WebhookURL string
+	// DefaultTemplate if empty. rendered as Slack mrkdwn
+	Template string
+	Client   *http.Client
+}
+
+func NewSlack(webhookURL string) *Slack {
+	return &Slack{WebhookURL: webhookURL}
+}
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color    string `json:"color"`
+	Text     string `json:"text"`
+	Fallback string `json:"fallback"`
+}
+
+func (s *Slack) Notify(ctx context.Context, r Result) error {
+	text, err := render(s.Template, r)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	payload := slackPayload{
+		Text:        title(r),
+		Attachments: []slackAttachment{{Color: color(r), Text: text, Fallback: text}},
+	}
+
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, payload)
+}
+
+// Teams posts the rendered template as a message card to a Microsoft Teams incoming webhook
+type Teams struct {
+	WebhookURL string
+	// DefaultTemplate if empty. rendered as markdown
+	Template string
+	Client   *http.Client
+}
+
+func NewTeams(webhookURL string) *Teams {
+	return &Teams{WebhookURL: webhookURL}
+}
+
+type teamsCard struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	ThemeColor string `json:"themeColor"`
+	Summary    string `json:"summary"`
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+}
+
+func (t *Teams) Notify(ctx context.Context, r Result) error {
+	text, err := render(t.Template, r)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	card := teamsCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: color(r)[1:],
+		Summary:    title(r),
+		Title:      title(r),
+		// teams collapses single line breaks
+		Text: markdownLines(text),
+	}
+
+	return postJSON(ctx, t.Client, t.WebhookURL, nil, card)
+}
+
+func title(r Result) string {
+	return "pipeline " + r.Pipeline + ": " + r.Result
+}
+
+func color(r Result) string {
+	switch r.Result {
+	case ResultPass:
+		return "#2EB67D"
+	case ResultCancelled:
+		return "#ECB22E"
+	}
+
+	return "#E01E5A"
+}
+
+// ends every line with two spaces, so markdown keeps the line breaks
+func markdownLines(s string) string {
+	return strings.ReplaceAll(s, "\n", "  \n")
+}
@@ -0,0 +1,332 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+)
+
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+type stepState struct {
+	name     string
+	started  time.Time
+	command  string
+	output   []string
+	result   string
+	duration time.Duration
+}
+
+type jobState struct {
+	name    string
+	started time.Time
+	steps   []*stepState
+}
+
+// Renderer draws a live view of the running jobs and steps on a terminal. When the output is
+// not a terminal it stays out of the way and log lines written to it are passed through as-is.
+//
+// Log output should be written through the Renderer (e.g. slog.NewTextHandler(r, nil)) so that
+// log lines are printed above the live view instead of being overwritten by it
+type Renderer struct {
+	mu sync.Mutex
+
+	out io.Writer
+	tty bool
+	// maximum width of a line, longer lines are truncated so they don't wrap
+	width int
+	// number of output lines shown for the running step
+	tail int
+	now  func() time.Time
+
+	jobs  []*jobState
+	frame int
+	// number of lines of the live view currently on screen
+	drawn int
+
+	ticker *time.Ticker
+	done   chan struct{}
+}
+
+// creates a Renderer writing to out. The live view is only enabled if out is a terminal
+func New(out io.Writer) *Renderer {
+	width := 120
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		width = cols
+	}
+
+	return &Renderer{
+		out:   out,
+		tty:   isTerminal(out),
+		width: width,
+		tail:  3,
+		now:   time.Now,
+	}
+}
+
+// returns true if w is a character device, e.g. an interactive terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// writes log output above the live view
+func (r *Renderer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.tty {
+		return r.out.Write(p)
+	}
+
+	r.clear()
+	n, err := r.out.Write(p)
+	r.draw()
+
+	return n, err
+}
+
+// handles pipeline events, meant to be registered with Anypipe.WithEventHandler
+func (r *Renderer) Handle(e anypipe.Event) {
+	if !r.tty {
+		return
+	}
+
+	switch e.Type {
+	case anypipe.EventPipelineStarted:
+		r.start()
+	case anypipe.EventPipelineFinished:
+		r.Stop()
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apply(e)
+	r.clear()
+	if e.Type == anypipe.EventJobFinished {
+		r.finishJob(e)
+	}
+	r.draw()
+}
+
+// updates the state of the live view with the event
+func (r *Renderer) apply(e anypipe.Event) {
+	switch e.Type {
+	case anypipe.EventJobStarted:
+		r.jobs = append(r.jobs, &jobState{name: e.Job, started: e.Time})
+
+	case anypipe.EventStepStarted:
+		if j := r.job(e.Job); j != nil {
+			j.steps = append(j.steps, &stepState{name: e.Step, started: e.Time})
+		}
+
+	case anypipe.EventExec:
+		if s := r.step(e.Job, e.Step); s != nil {
+			s.command = e.Command
+		}
+
+	case anypipe.EventOutput:
+		if s := r.step(e.Job, e.Step); s != nil {
+			s.output = lastLines(s.output, e.Stdout+e.Stderr, r.tail)
+		}
+
+	case anypipe.EventStepFinished:
+		j := r.job(e.Job)
+		if j == nil {
+			return
+		}
+
+		s := r.step(e.Job, e.Step)
+		if s == nil {
+			// skipped steps never start
+			s = &stepState{name: e.Step}
+			j.steps = append(j.steps, s)
+		}
+		s.result = e.Result
+		s.duration = e.Duration
+	}
+}
+
+// prints the collapsed lines of a finished job permanently and removes it from the live view
+func (r *Renderer) finishJob(e anypipe.Event) {
+	for i, j := range r.jobs {
+		if j.name != e.Job {
+			continue
+		}
+
+		fmt.Fprintln(r.out, r.truncate(fmt.Sprintf("%s %s (%s)", e.Result, j.name, round(r.now().Sub(j.started)))))
+		for _, s := range j.steps {
+			fmt.Fprintln(r.out, r.truncate(fmt.Sprintf("  %s %s (%s)", s.result, s.name, round(s.duration))))
+		}
+
+		r.jobs = append(r.jobs[:i], r.jobs[i+1:]...)
+		return
+	}
+}
+
+func (r *Renderer) job(name string) *jobState {
+	for _, j := range r.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+
+	return nil
+}
+
+func (r *Renderer) step(job, name string) *stepState {
+	j := r.job(job)
+	if j == nil {
+		return nil
+	}
+
+	for _, s := range j.steps {
+		if s.name == name {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// returns the lines of the live view
+func (r *Renderer) lines() []string {
+	now := r.now()
+	spin := spinner[r.frame%len(spinner)]
+
+	lines := []string{}
+	for _, j := range r.jobs {
+		lines = append(lines, fmt.Sprintf("%s %s (%s)", spin, j.name, round(now.Sub(j.started))))
+
+		for _, s := range j.steps {
+			if len(s.result) > 0 {
+				lines = append(lines, fmt.Sprintf("  %s %s (%s)", s.result, s.name, round(s.duration)))
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("  %s %s (%s)", spin, s.name, round(now.Sub(s.started))))
+			if len(s.command) > 0 {
+				lines = append(lines, fmt.Sprintf("    $ %s", firstLine(s.command)))
+			}
+			for _, o := range s.output {
+				lines = append(lines, fmt.Sprintf("    | %s", o))
+			}
+		}
+	}
+
+	for i := range lines {
+		lines[i] = r.truncate(lines[i])
+	}
+
+	return lines
+}
+
+// erases the live view from the screen
+func (r *Renderer) clear() {
+	if r.drawn > 0 {
+		// move to the start of the first drawn line, then clear to the end of the screen
+		fmt.Fprintf(r.out, "\x1b[%dF\x1b[J", r.drawn)
+	}
+	r.drawn = 0
+}
+
+func (r *Renderer) draw() {
+	lines := r.lines()
+	for _, l := range lines {
+		fmt.Fprintln(r.out, l)
+	}
+	r.drawn = len(lines)
+}
+
+// starts redrawing the live view periodically, so spinners and timers keep moving
+func (r *Renderer) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ticker != nil {
+		return
+	}
+
+	r.ticker = time.NewTicker(100 * time.Millisecond)
+	r.done = make(chan struct{})
+
+	go func(ticker *time.Ticker, done chan struct{}) {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				r.frame++
+				r.clear()
+				r.draw()
+				r.mu.Unlock()
+			}
+		}
+	}(r.ticker, r.done)
+}
+
+// stops redrawing and erases the live view. Safe to call multiple times
+func (r *Renderer) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ticker != nil {
+		r.ticker.Stop()
+		close(r.done)
+		r.ticker = nil
+	}
+
+	r.clear()
+}
+
+func (r *Renderer) truncate(s string) string {
+	runes := []rune(s)
+	if len(runes) <= r.width {
+		return s
+	}
+
+	return string(runes[:r.width-1]) + "…"
+}
+
+// appends the non-empty lines of output to lines, keeping only the last n
+func lastLines(lines []string, output string, n int) []string {
+	for _, l := range strings.Split(output, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if len(strings.TrimSpace(l)) > 0 {
+			lines = append(lines, l)
+		}
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return lines
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(100 * time.Millisecond)
+}
@@ -0,0 +1,111 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRenderer(out *bytes.Buffer, start time.Time) *Renderer {
+	r := New(out)
+	r.tty = true
+	r.width = 40
+	r.now = func() time.Time { return start.Add(5 * time.Second) }
+
+	return r
+}
+
+func TestPlainFallback(t *testing.T) {
+	out := bytes.NewBuffer([]byte{})
+	r := New(out)
+	assert.False(t, r.tty)
+
+	r.Handle(anypipe.Event{Type: anypipe.EventJobStarted, Job: "job"})
+	_, err := r.Write([]byte("log line\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "log line\n", out.String())
+}
+
+func TestLiveView(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	r := testRenderer(bytes.NewBuffer([]byte{}), start)
+
+	events := []anypipe.Event{
+		{Type: anypipe.EventJobStarted, Job: "build", Time: start},
+		{Type: anypipe.EventStepStarted, Job: "build", Step: "lint", Time: start},
+		{Type: anypipe.EventStepFinished, Job: "build", Step: "lint", Result: "PASS", Duration: 1200 * time.Millisecond},
+		{Type: anypipe.EventStepStarted, Job: "build", Step: "test", Time: start.Add(2 * time.Second)},
+		{Type: anypipe.EventExec, Job: "build", Step: "test", Command: "go test ./...\necho done"},
+		{Type: anypipe.EventOutput, Job: "build", Step: "test", Stdout: "ok a\nok b\n", Stderr: "ok c\nok d\n"},
+	}
+	for _, e := range events {
+		r.apply(e)
+	}
+
+	assert.Equal(t, []string{
+		"⠋ build (5s)",
+		"  PASS lint (1.2s)",
+		"  ⠋ test (3s)",
+		"    $ go test ./...",
+		"    | ok b",
+		"    | ok c",
+		"    | ok d",
+	}, r.lines())
+}
+
+func TestFinishedJobCollapses(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	out := bytes.NewBuffer([]byte{})
+	r := testRenderer(out, start)
+
+	r.Handle(anypipe.Event{Type: anypipe.EventJobStarted, Job: "build", Time: start})
+	r.Handle(anypipe.Event{Type: anypipe.EventStepStarted, Job: "build", Step: "lint", Time: start})
+	assert.Equal(t, 2, r.drawn)
+
+	r.Handle(anypipe.Event{Type: anypipe.EventStepFinished, Job: "build", Step: "lint", Result: "FAIL", Duration: time.Second})
+	r.Handle(anypipe.Event{Type: anypipe.EventStepFinished, Job: "build", Step: "test", Result: "SKIP"})
+	out.Reset()
+
+	r.Handle(anypipe.Event{Type: anypipe.EventJobFinished, Job: "build", Result: "FAIL"})
+
+	assert.Equal(t, "\x1b[3F\x1b[JFAIL build (5s)\n  FAIL lint (1s)\n  SKIP test (0s)\n", out.String())
+	assert.Empty(t, r.jobs)
+	assert.Equal(t, 0, r.drawn)
+}
+
+func TestLogLinesAboveLiveView(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	out := bytes.NewBuffer([]byte{})
+	r := testRenderer(out, start)
+
+	r.Handle(anypipe.Event{Type: anypipe.EventJobStarted, Job: "build", Time: start})
+	out.Reset()
+
+	_, err := r.Write([]byte("some log line\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "\x1b[1F\x1b[Jsome log line\n⠋ build (5s)\n", out.String())
+}
+
+func TestStartStop(t *testing.T) {
+	r := testRenderer(bytes.NewBuffer([]byte{}), time.Now())
+
+	r.Handle(anypipe.Event{Type: anypipe.EventPipelineStarted})
+	assert.NotNil(t, r.ticker)
+
+	r.Handle(anypipe.Event{Type: anypipe.EventPipelineFinished})
+	assert.Nil(t, r.ticker)
+	r.Stop()
+}
+
+func TestTruncate(t *testing.T) {
+	r := New(bytes.NewBuffer([]byte{}))
+	r.width = 10
+
+	assert.Equal(t, "short", r.truncate("short"))
+	assert.Equal(t, strings.Repeat("a", 9)+"…", r.truncate(strings.Repeat("a", 20)))
+}
@@ -0,0 +1,303 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// how long a poll waits for an assignment before it is repeated
+const DefaultPollWait = 20 * time.Second
+
+// how often the events of a running assignment are sent, which also tells the coordinator the agent is alive
+const DefaultFlushInterval = time.Second
+
+// Handler runs an assignment and returns its result. events are sent to the coordinator as they are emitted.
+// ctx is cancelled if the coordinator cancels the assignment
+type Handler func(ctx context.Context, a Assignment, emit func(e json.RawMessage)) Result
+
+// Agent runs the assignments of a coordinator, Slots at a time
+type Agent struct {
+	// URL of the coordinator, e.g. http://coordinator:8080
+	URL   string
+	Token string
+	Name  string
+	Slots int
+	// how long each poll waits for an assignment, and how often running assignments stream their events
+	PollWait      time.Duration
+	FlushInterval time.Duration
+	Client        *http.Client
+	Log           *slog.Logger
+
+	mu sync.Mutex
+	id string
+}
+
+func NewAgent(coordinatorURL, token, name string, slots int) *Agent {
+	return &Agent{
+		URL:           coordinatorURL,
+		Token:         token,
+		Name:          name,
+		Slots:         slots,
+		PollWait:      DefaultPollWait,
+		FlushInterval: DefaultFlushInterval,
+		Client:        http.DefaultClient,
+		Log:           slog.Default(),
+	}
+}
+
+// registers with the coordinator and runs its assignments with h until ctx is done
+func (a *Agent) Run(ctx context.Context, h Handler) error {
+	if a.Slots < 1 {
+		a.Slots = 1
+	}
+	if _, err := a.register(ctx); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, a.Slots)
+	for i := 0; i < a.Slots; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = a.work(ctx, h)
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
+
+// registers the agent, unless it registered again since it got the ID stale
+func (a *Agent) register(ctx context.Context, stale ...string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(stale) > 0 && a.id != stale[0] {
+		return a.id, nil
+	}
+
+	var reg registered
+	err := a.do(ctx, http.MethodPost, "/agents", Registration{Name: a.Name, Slots: a.Slots}, &reg)
+	if err != nil {
+		return "", fmt.Errorf("failed to register with coordinator %s: %w", a.URL, err)
+	}
+	a.id = reg.ID
+	a.Log.Info(fmt.Sprintf("agent %s registered with coordinator %s", a.Name, a.URL))
+
+	return a.id, nil
+}
+
+// polls for assignments and runs them, one at a time, until ctx is done
+func (a *Agent) work(ctx context.Context, h Handler) error {
+	for ctx.Err() == nil {
+		a.mu.Lock()
+		id := a.id
+		a.mu.Unlock()
+
+		var as Assignment
+		err := a.do(ctx, http.MethodGet, fmt.Sprintf("/agents/%s/assignment?wait=%s", id, a.pollWait()), nil, &as)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, errNotFound):
+			if _, err := a.register(ctx, id); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			a.Log.Error(fmt.Sprintf("failed to poll coordinator %s: %s", a.URL, err.Error()))
+			if !sleep(ctx, time.Second) {
+				return nil
+			}
+			continue
+		case len(as.ID) == 0:
+			continue
+		}
+
+		a.run(ctx, id, as, h)
+	}
+
+	return nil
+}
+
+func (a *Agent) pollWait() time.Duration {
+	if a.PollWait > 0 {
+		return a.PollWait
+	}
+
+	return DefaultPollWait
+}
+
+// runs the assignment, streaming its events, and reports the result
+func (a *Agent) run(ctx context.Context, agentID string, as Assignment, h Handler) {
+	a.Log.Info(fmt.Sprintf("running job %s of pipeline %s", as.Job, as.Pipeline))
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	s := &eventStream{agent: a, path: fmt.Sprintf("/assignments/%s/events?agent=%s", as.ID, url.QueryEscape(agentID)), cancel: cancel}
+	stop := s.start(ctx)
+	result := h(ctx, as, s.emit)
+	stop()
+
+	// reported even if the agent is stopping, so the coordinator doesn't wait for it to time out
+	reportCtx, cancelReport := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancelReport()
+	err := a.do(reportCtx, http.MethodPost, fmt.Sprintf("/assignments/%s/result?agent=%s", as.ID, url.QueryEscape(agentID)), result, nil)
+	if err != nil {
+		a.Log.Error(fmt.Sprintf("failed to report the result of job %s: %s", as.Job, err.Error()))
+	}
+}
+
+// eventStream buffers the events of an assignment and sends them periodically
+type eventStream struct {
+	agent  *Agent
+	path   string
+	cancel context.CancelCauseFunc
+
+	mu      sync.Mutex
+	pending []json.RawMessage
+	// serializes sends, so events arrive in order
+	sendMu sync.Mutex
+}
+
+func (s *eventStream) emit(e json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(s.pending, e)
+}
+
+// sends the events every flush interval until the returned func is called, which sends what's left
+func (s *eventStream) start(ctx context.Context) func() {
+	interval := s.agent.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush(ctx)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		s.flush(flushCtx)
+	}
+}
+
+// sends the pending events, an empty batch lets the coordinator know the agent is alive
+func (s *eventStream) flush(ctx context.Context) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	s.mu.Lock()
+	events := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if events == nil {
+		events = []json.RawMessage{}
+	}
+
+	var resp eventsResponse
+	err := s.agent.do(ctx, http.MethodPost, s.path, events, &resp)
+	if errors.Is(err, errNotFound) {
+		// the coordinator gave up on the assignment, e.g. it considered the agent lost
+		s.cancel(errors.New("the assignment is no longer known to the coordinator"))
+		return
+	}
+	if err != nil {
+		s.agent.Log.Error(fmt.Sprintf("failed to send events: %s", err.Error()))
+		// sent with the next batch
+		s.mu.Lock()
+		s.pending = append(events, s.pending...)
+		s.mu.Unlock()
+		return
+	}
+	if resp.Cancelled {
+		s.cancel(errors.New("cancelled by the coordinator"))
+	}
+}
+
+// sends a JSON request to the coordinator and decodes the response into out, if any
+func (a *Agent) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.URL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(a.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	case resp.StatusCode == http.StatusNoContent || out == nil:
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// waits for d, returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,312 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how long an agent can go without polling or streaming events before its assignments fail
+const DefaultAgentTimeout = 30 * time.Second
+
+// longest a poll waits for an assignment
+const maxPollWait = time.Minute
+
+// Coordinator queues assignments until an agent polls for one, and relays their events and results.
+// it is an http.Handler, serve it on an address the agents can reach
+type Coordinator struct {
+	// required from agents as a bearer token, if set
+	Token        string
+	AgentTimeout time.Duration
+
+	mu          sync.Mutex
+	agents      map[string]*AgentInfo
+	queue       []*assignment
+	assignments map[string]*assignment
+	// closed and replaced whenever an assignment is queued, to wake up polling agents
+	queued chan struct{}
+	mux    *http.ServeMux
+}
+
+// AgentInfo describes an agent registered with the coordinator
+type AgentInfo struct {
+	ID       string
+	Name     string
+	Slots    int
+	LastSeen time.Time
+	// assignments the agent is running
+	Running int
+}
+
+type assignment struct {
+	Assignment
+	events func(e json.RawMessage)
+	// the agent running the assignment, empty while it is queued
+	agent     string
+	cancelled bool
+	result    chan Result
+}
+
+func NewCoordinator(token string) *Coordinator {
+	c := &Coordinator{
+		Token:        token,
+		AgentTimeout: DefaultAgentTimeout,
+		agents:       map[string]*AgentInfo{},
+		assignments:  map[string]*assignment{},
+		queued:       make(chan struct{}),
+		mux:          http.NewServeMux(),
+	}
+
+	c.mux.HandleFunc("POST /agents", c.register)
+	c.mux.HandleFunc("GET /agents/{id}/assignment", c.poll)
+	c.mux.HandleFunc("POST /assignments/{id}/events", c.receiveEvents)
+	c.mux.HandleFunc("POST /assignments/{id}/result", c.receiveResult)
+
+	return c
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, c.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	c.mux.ServeHTTP(w, r)
+}
+
+// returns the agents registered with the coordinator, by name
+func (c *Coordinator) Agents() []AgentInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agents := []AgentInfo{}
+	for _, a := range c.agents {
+		agents = append(agents, *a)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+
+	return agents
+}
+
+// queues a and waits for an agent to run it. events streamed by the agent are passed to events, one at a time.
+// once ctx is done the agent is told to stop, and the result is still waited for, unless no agent took a yet.
+// an error is returned if a could not be run: ctx was done before an agent took it, or the agent was lost
+func (c *Coordinator) Dispatch(ctx context.Context, a Assignment, events func(e json.RawMessage)) (Result, error) {
+	if len(a.ID) == 0 {
+		a.ID = newID()
+	}
+	as := &assignment{Assignment: a, events: events, result: make(chan Result, 1)}
+
+	c.mu.Lock()
+	c.assignments[a.ID] = as
+	c.queue = append(c.queue, as)
+	close(c.queued)
+	c.queued = make(chan struct{})
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.assignments, a.ID)
+		c.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(c.agentTimeout() / 4)
+	defer ticker.Stop()
+	done := ctx.Done()
+	for {
+		select {
+		case r := <-as.result:
+			return r, nil
+
+		case <-done:
+			done = nil
+			c.mu.Lock()
+			if len(as.agent) == 0 {
+				c.dequeue(as)
+				c.mu.Unlock()
+				return Result{}, fmt.Errorf("assignment %s was not taken by any agent: %w", a.ID, context.Cause(ctx))
+			}
+			as.cancelled = true
+			c.mu.Unlock()
+
+		case <-ticker.C:
+			c.mu.Lock()
+			agent, lost := c.lost(as)
+			c.mu.Unlock()
+			if lost {
+				return Result{}, fmt.Errorf("lost agent %s while it ran job %s", agent, a.Job)
+			}
+		}
+	}
+}
+
+func (c *Coordinator) agentTimeout() time.Duration {
+	if c.AgentTimeout > 0 {
+		return c.AgentTimeout
+	}
+
+	return DefaultAgentTimeout
+}
+
+// whether the agent running the assignment is gone, it is forgotten if so. must be called with c.mu held
+func (c *Coordinator) lost(as *assignment) (string, bool) {
+	if len(as.agent) == 0 {
+		return "", false
+	}
+
+	a, ok := c.agents[as.agent]
+	if !ok {
+		return as.agent, true
+	}
+	if time.Since(a.LastSeen) > c.agentTimeout() {
+		delete(c.agents, as.agent)
+		return a.Name, true
+	}
+
+	return "", false
+}
+
+// must be called with c.mu held
+func (c *Coordinator) dequeue(as *assignment) {
+	for i, q := range c.queue {
+		if q == as {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// records that the agent is alive, returns false if it is unknown. must be called with c.mu held
+func (c *Coordinator) seen(id string) bool {
+	a, ok := c.agents[id]
+	if ok {
+		a.LastSeen = time.Now()
+	}
+
+	return ok
+}
+
+func (c *Coordinator) register(w http.ResponseWriter, r *http.Request) {
+	var reg Registration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reg.Slots < 1 {
+		reg.Slots = 1
+	}
+
+	id := newID()
+	c.mu.Lock()
+	c.agents[id] = &AgentInfo{ID: id, Name: reg.Name, Slots: reg.Slots, LastSeen: time.Now()}
+	c.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, registered{ID: id})
+}
+
+// hands the next queued assignment to the agent, waiting up to ?wait= for one. 204 if there is none
+func (c *Coordinator) poll(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+	if err != nil || wait > maxPollWait {
+		wait = maxPollWait
+	}
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		c.mu.Lock()
+		if !c.seen(id) {
+			c.mu.Unlock()
+			http.Error(w, "unknown agent", http.StatusNotFound)
+			return
+		}
+		if len(c.queue) > 0 {
+			as := c.queue[0]
+			c.queue = c.queue[1:]
+			as.agent = id
+			c.agents[id].Running++
+			c.mu.Unlock()
+
+			writeJSON(w, http.StatusOK, as.Assignment)
+			return
+		}
+		queued := c.queued
+		c.mu.Unlock()
+
+		select {
+		case <-queued:
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// returns the assignment, if the agent is the one running it. must be called with c.mu held
+func (c *Coordinator) assigned(w http.ResponseWriter, r *http.Request) (*assignment, bool) {
+	as, ok := c.assignments[r.PathValue("id")]
+	if !ok || as.agent != r.URL.Query().Get("agent") {
+		http.Error(w, "unknown assignment", http.StatusNotFound)
+		return nil, false
+	}
+	c.seen(as.agent)
+
+	return as, true
+}
+
+func (c *Coordinator) receiveEvents(w http.ResponseWriter, r *http.Request) {
+	var events []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	as, ok := c.assigned(w, r)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	// an agent streams the events of an assignment one batch at a time, so they are passed on in order
+	if as.events != nil {
+		for _, e := range events {
+			as.events(e)
+		}
+	}
+
+	c.mu.Lock()
+	cancelled := as.cancelled
+	c.mu.Unlock()
+	writeJSON(w, http.StatusOK, eventsResponse{Cancelled: cancelled})
+}
+
+func (c *Coordinator) receiveResult(w http.ResponseWriter, r *http.Request) {
+	var result Result
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	as, ok := c.assigned(w, r)
+	if ok {
+		delete(c.assignments, as.ID)
+		if a, ok := c.agents[as.agent]; ok {
+			a.Running--
+		}
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	as.result <- result
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+// Package remote distributes jobs over agents: a Coordinator queues assignments, and Agents, usually on other
+// machines, long-poll it over HTTP for assignments, stream events back while they run them and report the result.
+// the package only moves assignments around, what they contain and how they run is up to its users
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// returned for assignments or agents the coordinator doesn't know, e.g. because it restarted or considered
+// the agent lost. agents register again
+var errNotFound = errors.New("not known to the coordinator")
+
+// Registration is sent by an agent when it starts
+type Registration struct {
+	Name string `json:"name"`
+	// assignments the agent runs at once
+	Slots int `json:"slots"`
+}
+
+type registered struct {
+	ID string `json:"id"`
+}
+
+// Variables are the variables of an assignment. secrets are sent apart, so the receiving side knows to mask them
+type Variables struct {
+	// JSON encoded values
+	Plain   map[string]json.RawMessage `json:"plain,omitempty"`
+	Secrets map[string]string          `json:"secrets,omitempty"`
+}
+
+// Assignment is a job handed to an agent
+type Assignment struct {
+	ID string `json:"id"`
+	// ID of the pipeline run the job belongs to
+	RunID    string    `json:"runId,omitempty"`
+	Pipeline string    `json:"pipeline"`
+	Job      string    `json:"job"`
+	Vars     Variables `json:"vars"`
+	// env variables of the job's container
+	Env map[string]string `json:"env,omitempty"`
+	// keys of Env recorded with the step metrics, mapped to whether their value is recorded too
+	DeclaredEnv map[string]bool `json:"declaredEnv,omitempty"`
+	// values to mask in logs and events, besides the secret variables
+	Masked []string `json:"masked,omitempty"`
+	// tar archives of the artifacts the job takes as input, keyed by name
+	Artifacts map[string][]byte `json:"artifacts,omitempty"`
+}
+
+// Result is the outcome of an assignment, as reported by the agent
+type Result struct {
+	// empty if the job passed
+	Error string `json:"error,omitempty"`
+	// the job stopped because the assignment was cancelled
+	Cancelled bool `json:"cancelled,omitempty"`
+	// variables the job set or changed
+	Outputs Variables `json:"outputs"`
+	// JSON encoded metrics of the job's steps
+	Metrics json.RawMessage `json:"metrics,omitempty"`
+	// tar archives of the artifacts the job produced, keyed by name
+	Artifacts map[string][]byte `json:"artifacts,omitempty"`
+}
+
+// sent back to agents streaming events, so they stop assignments that were cancelled
+type eventsResponse struct {
+	Cancelled bool `json:"cancelled"`
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// whether the request carries the token as a bearer token. any request is authorized without a token
+func authorized(r *http.Request, token string) bool {
+	if len(token) == 0 {
+		return true
+	}
+
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,251 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// starts a coordinator and an agent running h, the agent stops at the end of the test
+func startAgent(t *testing.T, c *Coordinator, token string, h Handler) (*httptest.Server, *Agent) {
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+
+	a := NewAgent(srv.URL, token, "agent-1", 2)
+	a.PollWait = 100 * time.Millisecond
+	a.FlushInterval = 10 * time.Millisecond
+	a.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = a.Run(ctx, h)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return srv, a
+}
+
+func TestDispatch(t *testing.T) {
+	c := NewCoordinator("s3cret")
+	startAgent(t, c, "s3cret", func(ctx context.Context, a Assignment, emit func(e json.RawMessage)) Result {
+		for i := 0; i < 3; i++ {
+			emit(json.RawMessage(`{"step":"` + a.Job + `"}`))
+		}
+
+		return Result{
+			Error:     "exit code 1",
+			Outputs:   Variables{Plain: map[string]json.RawMessage{"version": json.RawMessage(`"1.0"`)}, Secrets: a.Vars.Secrets},
+			Artifacts: map[string][]byte{"bin": a.Artifacts["src"]},
+		}
+	})
+
+	var mu sync.Mutex
+	events := []string{}
+	r, err := c.Dispatch(context.Background(), Assignment{
+		Pipeline:  "ci",
+		Job:       "build",
+		Vars:      Variables{Secrets: map[string]string{"token": "hunter2"}},
+		Artifacts: map[string][]byte{"src": []byte("tar")},
+	}, func(e json.RawMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, string(e))
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "exit code 1", r.Error)
+	assert.JSONEq(t, `"1.0"`, string(r.Outputs.Plain["version"]))
+	assert.Equal(t, map[string]string{"token": "hunter2"}, r.Outputs.Secrets)
+	assert.Equal(t, []byte("tar"), r.Artifacts["bin"])
+	assert.Equal(t, []string{`{"step":"build"}`, `{"step":"build"}`, `{"step":"build"}`}, events)
+
+	agents := c.Agents()
+	assert.Len(t, agents, 1)
+	assert.Equal(t, "agent-1", agents[0].Name)
+	assert.Equal(t, 2, agents[0].Slots)
+	assert.Equal(t, 0, agents[0].Running)
+}
+
+func TestDispatchConcurrently(t *testing.T) {
+	c := NewCoordinator("")
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	startAgent(t, c, "", func(ctx context.Context, a Assignment, emit func(e json.RawMessage)) Result {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return Result{}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Dispatch(context.Background(), Assignment{Job: "job"}, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// as many at once as the agent has slots
+	assert.Equal(t, 2, maxRunning)
+}
+
+func TestDispatchCancelled(t *testing.T) {
+	c := NewCoordinator("")
+	started := make(chan struct{})
+	startAgent(t, c, "", func(ctx context.Context, a Assignment, emit func(e json.RawMessage)) Result {
+		close(started)
+		<-ctx.Done()
+		return Result{Error: context.Cause(ctx).Error(), Cancelled: true}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	r, err := c.Dispatch(ctx, Assignment{Job: "deploy"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, r.Cancelled)
+	assert.Equal(t, "cancelled by the coordinator", r.Error)
+}
+
+func TestDispatchNotTaken(t *testing.T) {
+	c := NewCoordinator("")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := c.Dispatch(ctx, Assignment{ID: "a1", Job: "deploy"}, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "assignment a1 was not taken by any agent")
+	assert.Empty(t, c.queue)
+}
+
+func TestLostAgent(t *testing.T) {
+	c := NewCoordinator("")
+	c.AgentTimeout = 40 * time.Millisecond
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	// an agent that takes an assignment and is never heard of again
+	a := NewAgent(srv.URL, "", "flaky", 1)
+	_, err := a.register(context.Background())
+	assert.NoError(t, err)
+	go func() {
+		var as Assignment
+		_ = a.do(context.Background(), http.MethodGet, "/agents/"+a.id+"/assignment?wait=1s", nil, &as)
+	}()
+
+	_, err = c.Dispatch(context.Background(), Assignment{Job: "deploy"}, nil)
+	assert.EqualError(t, err, "lost agent flaky while it ran job deploy")
+	assert.Empty(t, c.Agents())
+
+	// the agent is told so when it polls, and registers again
+	err = a.do(context.Background(), http.MethodGet, "/agents/"+a.id+"/assignment?wait=1ms", nil, &Assignment{})
+	assert.ErrorIs(t, err, errNotFound)
+}
+
+func TestUnauthorized(t *testing.T) {
+	c := NewCoordinator("s3cret")
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	type testcase struct {
+		name   string
+		token  string
+		status int
+	}
+
+	testcases := []testcase{
+		{name: "no token", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "guess", status: http.StatusUnauthorized},
+		{name: "token", token: "s3cret", status: http.StatusCreated},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/agents", strings.NewReader(`{"name":"a"}`))
+			assert.NoError(t, err)
+			if len(tc.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+
+	a := NewAgent(srv.URL, "guess", "agent", 1)
+	err := a.Run(context.Background(), nil)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestAgentRegistersAgain(t *testing.T) {
+	c := NewCoordinator("")
+	_, a := startAgent(t, c, "", func(ctx context.Context, as Assignment, emit func(e json.RawMessage)) Result {
+		return Result{}
+	})
+
+	// the coordinator forgets about the agent, e.g. because it restarted
+	assert.Eventually(t, func() bool { return len(c.Agents()) == 1 }, time.Second, time.Millisecond)
+	a.mu.Lock()
+	first := a.id
+	a.mu.Unlock()
+	c.mu.Lock()
+	c.agents = map[string]*AgentInfo{}
+	c.mu.Unlock()
+
+	_, err := c.Dispatch(context.Background(), Assignment{Job: "build"}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, c.Agents(), 1)
+	assert.NotEqual(t, first, c.Agents()[0].ID)
+}
+
+func TestAgentStopsForgottenAssignments(t *testing.T) {
+	stopped := make(chan error, 1)
+	c := NewCoordinator("")
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	a := NewAgent(srv.URL, "", "agent", 1)
+	a.FlushInterval = 5 * time.Millisecond
+	a.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := a.register(context.Background())
+	assert.NoError(t, err)
+
+	// the coordinator knows nothing about the assignment
+	a.run(context.Background(), a.id, Assignment{ID: "unknown", Job: "build"}, func(ctx context.Context, as Assignment, emit func(e json.RawMessage)) Result {
+		<-ctx.Done()
+		stopped <- context.Cause(ctx)
+		return Result{}
+	})
+
+	assert.EqualError(t, <-stopped, "the assignment is no longer known to the coordinator")
+}
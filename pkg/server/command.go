@@ -0,0 +1,60 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const commandUsage = `usage: %s serve [-addr :8080] [-concurrency n] [-keep n]
+
+serves the registered pipelines over HTTP. clients must send $ANYPIPE_TOKEN as bearer token, if it is set
+
+pipelines:
+%s`
+
+// runs the serve command of a pipeline program, args being the program's arguments, and returns the exit code:
+//
+//	func main() {
+//		s := server.New(ctx, logger, 2).WithPipeline("ci", buildPipeline)
+//		os.Exit(s.Command(os.Args[1:], os.Stdout, os.Stderr))
+//	}
+func (s *Server) Command(args []string, stdout, stderr io.Writer) int {
+	usage := func(w io.Writer) {
+		pipelines := ""
+		for _, name := range s.Pipelines() {
+			pipelines += fmt.Sprintf("  %s\n", name)
+		}
+		fmt.Fprintf(w, commandUsage, os.Args[0], pipelines)
+	}
+
+	switch {
+	case len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help"):
+		usage(stdout)
+		return 0
+	case len(args) == 0 || args[0] != "serve":
+		usage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { usage(stderr) }
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.IntVar(&s.Concurrency, "concurrency", s.concurrency(), "runs at once, further runs are queued")
+	fs.IntVar(&s.KeepRuns, "keep", s.KeepRuns, "finished runs kept")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if len(s.Token) == 0 {
+		s.Token = os.Getenv("ANYPIPE_TOKEN")
+	}
+
+	if err := s.ListenAndServe(*addr); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
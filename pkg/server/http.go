@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+)
+
+// TriggerRequest is the body of POST /pipelines/{name}/runs. values of Secrets are passed to the pipeline as
+// anypipe.Secret. variables are decoded from JSON, numbers are float64
+type TriggerRequest struct {
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	Secrets   map[string]string      `json:"secrets,omitempty"`
+}
+
+type pipelineInfo struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.Token) == 0 {
+		return true
+	}
+
+	expected := []byte("Bearer " + s.Token)
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+}
+
+func (s *Server) listPipelines(w http.ResponseWriter, r *http.Request) {
+	pipelines := []pipelineInfo{}
+	for _, name := range s.Pipelines() {
+		pipelines = append(pipelines, pipelineInfo{Name: name})
+	}
+
+	writeJSON(w, http.StatusOK, pipelines)
+}
+
+func (s *Server) trigger(w http.ResponseWriter, r *http.Request) {
+	var req TriggerRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	variables := map[string]interface{}{}
+	for k, v := range req.Variables {
+		variables[k] = v
+	}
+	for k, v := range req.Secrets {
+		variables[k] = anypipe.NewSecret(v)
+	}
+
+	run, err := s.Trigger(r.PathValue("name"), variables)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	runs := []Run{}
+	for _, run := range s.Runs() {
+		if p := r.URL.Query().Get("pipeline"); len(p) > 0 && run.Pipeline != p {
+			continue
+		}
+		if st := r.URL.Query().Get("status"); len(st) > 0 && string(run.Status) != st {
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request) {
+	if err := s.Cancel(r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	run, _ := s.Get(r.PathValue("id"))
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// streams the entries of the run as server-sent events, named after the event type ("log" for log records) and
+// numbered so a client reconnecting with Last-Event-ID resumes where it left off. an "end" event carrying the
+// finished run closes the stream
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	run, ok := s.runs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, fmt.Errorf("%w %s", ErrUnknownRun, r.PathValue("id")))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	next := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		next = id + 1
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		entries, end, updated := run.stream.since(next)
+		for _, e := range entries {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", next, e.kind, e.data)
+			next++
+		}
+		if end != nil {
+			data, _ := json.Marshal(end)
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnknownPipeline), errors.Is(err, ErrUnknownRun):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrFinished):
+		status = http.StatusConflict
+	}
+
+	http.Error(w, strings.TrimSpace(err.Error()), status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
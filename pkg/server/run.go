@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+)
+
+// run is a Run with what it needs to execute. the fields of Run are guarded by the server's mutex
+type run struct {
+	Run
+	factory   Factory
+	variables map[string]interface{}
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	stream    *stream
+}
+
+func newRun(ctx context.Context, pipeline string, f Factory, variables map[string]interface{}) *run {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	r := &run{
+		Run:       Run{ID: hex.EncodeToString(b), Pipeline: pipeline, Status: StatusQueued, QueuedAt: time.Now()},
+		factory:   f,
+		variables: variables,
+		stream:    newStream(secrets(variables)),
+	}
+	r.ctx, r.cancel = context.WithCancelCause(ctx)
+
+	return r
+}
+
+// builds and runs the pipeline, its events and logs are added to the stream. a panic fails the run
+func (r *run) execute() (status Status, msg string, results []anypipe.JobResult, outputs map[string]map[string]interface{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			status, msg = StatusFailed, fmt.Sprintf("panic: %v\n%s", v, debug.Stack())
+		}
+	}()
+
+	log := slog.New(slog.NewJSONHandler(logWriter{r.stream}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	p := r.factory(r.ctx, log)
+	p.WithEventHandler(r.stream.event)
+	err := p.Run(r.variables)
+
+	if impl, ok := p.(*anypipe.AnypipeImpl); ok {
+		results, outputs = impl.Results, impl.JobOutputs
+	}
+
+	switch {
+	case r.ctx.Err() != nil && err == nil:
+		// cancelled before any job started
+		return StatusCancelled, context.Cause(r.ctx).Error(), results, outputs
+	case r.ctx.Err() != nil:
+		return StatusCancelled, err.Error(), results, outputs
+	case err == nil:
+		return StatusPassed, "", results, outputs
+	default:
+		return StatusFailed, err.Error(), results, outputs
+	}
+}
+
+// entry is an event or a log record of a run, sent as a server-sent event
+type entry struct {
+	// one of the anypipe.EventType values, "log" for log records
+	kind string
+	data []byte
+}
+
+// stream keeps the entries of a run for clients to replay and follow
+type stream struct {
+	// masked in every entry, the pipeline only masks what it logs itself
+	secrets []string
+	mu      sync.Mutex
+	entries []entry
+	// the run as it finished, nil while it is still going on
+	end *Run
+	// closed and replaced whenever an entry is added or the stream ends
+	updated chan struct{}
+}
+
+func newStream(secrets []string) *stream {
+	return &stream{secrets: secrets, updated: make(chan struct{})}
+}
+
+// the values of the secret variables
+func secrets(variables map[string]interface{}) []string {
+	values := []string{}
+	for _, v := range variables {
+		if s, ok := v.(anypipe.Secret); ok && len(s.Value()) > 0 {
+			values = append(values, s.Value())
+		}
+	}
+
+	return values
+}
+
+func (s *stream) add(e entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.end != nil {
+		return
+	}
+	for _, secret := range s.secrets {
+		e.data = bytes.ReplaceAll(e.data, []byte(secret), []byte("***"))
+	}
+	s.entries = append(s.entries, e)
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func (s *stream) event(e anypipe.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	s.add(entry{kind: string(e.Type), data: data})
+}
+
+func (s *stream) close(r Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.end != nil {
+		return
+	}
+	s.end = &r
+	close(s.updated)
+}
+
+// returns the entries after the first from, the run if the stream ended, and a channel closed on the next update
+func (s *stream) since(from int) ([]entry, *Run, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from > len(s.entries) {
+		from = len(s.entries)
+	}
+
+	return append([]entry{}, s.entries[from:]...), s.end, s.updated
+}
+
+// logWriter adds each JSON log record written by a slog.JSONHandler to the stream
+type logWriter struct {
+	s *stream
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.s.add(entry{kind: "log", data: bytes.TrimSpace(bytes.Clone(p))})
+
+	return len(p), nil
+}
@@ -0,0 +1,291 @@
+// Package server runs registered pipelines as a service. runs are triggered over a REST API and queued, at most
+// Concurrency of them run at once, and their events and logs are streamed live as server-sent events:
+//
+//	GET  /pipelines              lists the registered pipelines
+//	POST /pipelines/{name}/runs  queues a run, with {"variables": {...}, "secrets": {...}} as body
+//	GET  /runs                   lists the runs, most recent first
+//	GET  /runs/{id}              returns the status and the results of a run
+//	GET  /runs/{id}/events       streams the events and logs of a run from its start, until it finished
+//	POST /runs/{id}/cancel       cancels a queued or running run
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+)
+
+// finished runs kept by default, older ones are forgotten
+const DefaultKeepRuns = 100
+
+var (
+	ErrUnknownPipeline = errors.New("unknown pipeline")
+	ErrUnknownRun      = errors.New("unknown run")
+	ErrFinished        = errors.New("run already finished")
+)
+
+// Factory builds a new instance of a pipeline for each run. the run is cancelled through ctx, and the pipeline
+// should log through log for its logs to be streamed
+type Factory func(ctx context.Context, log *slog.Logger) anypipe.Anypipe
+
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusPassed    Status = "passed"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Run describes a run triggered through the server
+type Run struct {
+	ID         string     `json:"id"`
+	Pipeline   string     `json:"pipeline"`
+	Status     Status     `json:"status"`
+	QueuedAt   time.Time  `json:"queuedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// outcome of each job, once the run finished
+	Results []anypipe.JobResult `json:"results,omitempty"`
+	// variables set by each job, once the run finished. secrets are masked
+	Outputs map[string]map[string]interface{} `json:"outputs,omitempty"`
+}
+
+func (r Run) finished() bool {
+	return r.FinishedAt != nil
+}
+
+// Server queues and runs the registered pipelines. it is an http.Handler serving the API
+type Server struct {
+	// runs at once, further runs wait in the queue
+	Concurrency int
+	// required from clients as a bearer token, if set
+	Token    string
+	KeepRuns int
+
+	ctx       context.Context
+	log       *slog.Logger
+	mu        sync.Mutex
+	pipelines map[string]Factory
+	runs      map[string]*run
+	// all runs, in the order they were triggered
+	order   []*run
+	queue   []*run
+	running int
+	mux     *http.ServeMux
+}
+
+// creates a server running concurrency runs at once. runs still going on are cancelled once ctx is done
+func New(ctx context.Context, log *slog.Logger, concurrency int) *Server {
+	s := &Server{
+		Concurrency: concurrency,
+		KeepRuns:    DefaultKeepRuns,
+		ctx:         ctx,
+		log:         log,
+		pipelines:   map[string]Factory{},
+		runs:        map[string]*run{},
+		mux:         http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /pipelines", s.listPipelines)
+	s.mux.HandleFunc("POST /pipelines/{name}/runs", s.trigger)
+	s.mux.HandleFunc("GET /runs", s.listRuns)
+	s.mux.HandleFunc("GET /runs/{id}", s.getRun)
+	s.mux.HandleFunc("GET /runs/{id}/events", s.streamEvents)
+	s.mux.HandleFunc("POST /runs/{id}/cancel", s.cancel)
+
+	return s
+}
+
+// registers a pipeline under the given name
+func (s *Server) WithPipeline(name string, f Factory) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pipelines[name] = f
+
+	return s
+}
+
+// returns the names of the registered pipelines, sorted
+func (s *Server) Pipelines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for name := range s.pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// queues a run of the pipeline with the variables, it starts as soon as fewer than Concurrency runs are running
+func (s *Server) Trigger(pipeline string, variables map[string]interface{}) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.pipelines[pipeline]
+	if !ok {
+		return Run{}, fmt.Errorf("%w %s", ErrUnknownPipeline, pipeline)
+	}
+
+	r := newRun(s.ctx, pipeline, f, variables)
+	s.runs[r.ID] = r
+	s.order = append(s.order, r)
+	s.queue = append(s.queue, r)
+	s.log.Info(fmt.Sprintf("queued run %s of pipeline %s", r.ID, pipeline))
+	s.schedule()
+	s.prune()
+
+	return r.Run, nil
+}
+
+// returns the run with the given id
+func (s *Server) Get(id string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	if !ok {
+		return Run{}, fmt.Errorf("%w %s", ErrUnknownRun, id)
+	}
+
+	return r.Run, nil
+}
+
+// returns the runs, most recent first
+func (s *Server) Runs() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []Run{}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		runs = append(runs, s.order[i].Run)
+	}
+
+	return runs
+}
+
+// cancels the run. a queued run is removed from the queue, the jobs of a running run are cancelled
+func (s *Server) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.runs[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("%w %s", ErrUnknownRun, id)
+	case r.finished():
+		return fmt.Errorf("%w %s", ErrFinished, id)
+	}
+
+	s.log.Info(fmt.Sprintf("cancelling run %s of pipeline %s", r.ID, r.Pipeline))
+	r.cancel(errors.New("cancelled through the API"))
+	if r.Status == StatusQueued {
+		for i, q := range s.queue {
+			if q == r {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+		s.finish(r, StatusCancelled, "cancelled before it started")
+	}
+
+	return nil
+}
+
+func (s *Server) concurrency() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+
+	return 1
+}
+
+// starts queued runs while there is room for them. must be called with s.mu held
+func (s *Server) schedule() {
+	for s.running < s.concurrency() && len(s.queue) > 0 {
+		r := s.queue[0]
+		s.queue = s.queue[1:]
+		s.running++
+
+		now := time.Now()
+		r.Status, r.StartedAt = StatusRunning, &now
+		go s.execute(r)
+	}
+}
+
+// forgets the oldest finished runs beyond KeepRuns. must be called with s.mu held
+func (s *Server) prune() {
+	finished := 0
+	for _, r := range s.order {
+		if r.finished() {
+			finished++
+		}
+	}
+
+	kept := []*run{}
+	for _, r := range s.order {
+		if r.finished() && finished > s.KeepRuns && s.KeepRuns >= 0 {
+			finished--
+			delete(s.runs, r.ID)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	s.order = kept
+}
+
+func (s *Server) execute(r *run) {
+	s.log.Info(fmt.Sprintf("starting run %s of pipeline %s", r.ID, r.Pipeline))
+	status, msg, results, outputs := r.execute()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	r.Results, r.Outputs = results, outputs
+	s.finish(r, status, msg)
+	s.schedule()
+	s.prune()
+}
+
+// records the outcome of the run and ends its event stream. must be called with s.mu held
+func (s *Server) finish(r *run, status Status, msg string) {
+	now := time.Now()
+	r.Status, r.Error, r.FinishedAt = status, msg, &now
+	r.cancel(nil)
+	r.stream.close(r.Run)
+
+	s.log.Info(fmt.Sprintf("run %s of pipeline %s %s", r.ID, r.Pipeline, status))
+}
+
+// listens on addr and serves the API until the server's context is done
+func (s *Server) ListenAndServe(addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-s.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	s.log.Info(fmt.Sprintf("serving %d pipelines on %s, %d runs at once", len(s.Pipelines()), addr, s.concurrency()))
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+	"github.com/notmiguelalves/anypipe/pkg/approval"
+	"github.com/notmiguelalves/anypipe/pkg/ci"
+	"github.com/stretchr/testify/assert"
+)
+
+// a pipeline made of an approval gate decided by approver, it runs without docker
+func gatePipeline(approver approval.ApproverFunc) Factory {
+	return func(ctx context.Context, log *slog.Logger) anypipe.Anypipe {
+		return anypipe.NewPipelineImpl(ctx, log, "deploy").
+			WithCIProvider(ci.NewGeneric(map[string]string{}, io.Discard)).
+			WithSequentialJobs(anypipe.NewApprovalGate("approve", "deploy?", approver, 0))
+	}
+}
+
+func approve(ctx context.Context, req approval.Request) (approval.Decision, error) {
+	return approval.Decision{Approved: true, Approver: "alice"}, nil
+}
+
+// blocks until released or cancelled
+type blockingApprover struct {
+	release chan struct{}
+	// receives a value whenever a run starts waiting
+	waiting chan struct{}
+}
+
+func newBlockingApprover() *blockingApprover {
+	return &blockingApprover{release: make(chan struct{}), waiting: make(chan struct{}, 10)}
+}
+
+func (b *blockingApprover) await(ctx context.Context, req approval.Request) (approval.Decision, error) {
+	b.waiting <- struct{}{}
+	select {
+	case <-b.release:
+		return approval.Decision{Approved: true, Approver: "alice"}, nil
+	case <-ctx.Done():
+		return approval.Decision{}, context.Cause(ctx)
+	}
+}
+
+func newTestServer(t *testing.T, concurrency int) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return New(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), concurrency)
+}
+
+type sse struct {
+	id    string
+	event string
+	data  string
+}
+
+// reads the server-sent events of the response until the stream ends
+func readEvents(t *testing.T, body io.Reader) []sse {
+	events := []sse{}
+	current := sse{}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			events = append(events, current)
+			current = sse{}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			current.id = value
+		case "event":
+			current.event = value
+		case "data":
+			current.data = value
+		}
+	}
+	assert.NoError(t, scanner.Err())
+
+	return events
+}
+
+func do(t *testing.T, method, url string, body string, headers ...string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestRunOverHTTP(t *testing.T) {
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(approve))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp := do(t, http.MethodGet, srv.URL+"/pipelines", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `[{"name":"deploy"}]`, string(b))
+
+	resp = do(t, http.MethodPost, srv.URL+"/pipelines/deploy/runs", `{"variables":{"env":"prod"},"secrets":{"token":"hunter2"}}`)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var run Run
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&run))
+	assert.Equal(t, "deploy", run.Pipeline)
+	assert.Equal(t, "/runs/"+run.ID, resp.Header.Get("Location"))
+
+	resp = do(t, http.MethodGet, srv.URL+"/runs/"+run.ID+"/events", "")
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := readEvents(t, resp.Body)
+
+	kinds := []string{}
+	logs := []string{}
+	for _, e := range events {
+		kinds = append(kinds, e.event)
+		if e.event == "log" {
+			var record struct{ Msg string }
+			assert.NoError(t, json.Unmarshal([]byte(e.data), &record))
+			logs = append(logs, record.Msg)
+		}
+	}
+	assert.Subset(t, kinds, []string{"pipeline_started", "job_started", "job_finished", "pipeline_finished", "log"})
+	assert.Equal(t, "end", kinds[len(kinds)-1])
+	assert.Contains(t, logs, "starting pipeline deploy")
+
+	var end Run
+	assert.NoError(t, json.Unmarshal([]byte(events[len(events)-1].data), &end))
+	assert.Equal(t, StatusPassed, end.Status)
+
+	resp = do(t, http.MethodGet, srv.URL+"/runs/"+run.ID, "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&run))
+	assert.Equal(t, StatusPassed, run.Status)
+	assert.NotNil(t, run.StartedAt)
+	assert.NotNil(t, run.FinishedAt)
+	assert.Len(t, run.Results, 1)
+	assert.Equal(t, anypipe.ResultPass, run.Results[0].Result)
+	assert.Equal(t, "approved by alice", run.Results[0].Reason)
+
+	// reconnecting resumes after the last event received
+	last := events[len(events)-2]
+	resp = do(t, http.MethodGet, srv.URL+"/runs/"+run.ID+"/events", "", "Last-Event-ID", events[len(events)-3].id)
+	resumed := readEvents(t, resp.Body)
+	assert.Equal(t, []sse{last, events[len(events)-1]}, resumed)
+
+	resp = do(t, http.MethodGet, srv.URL+"/runs?status=passed", "")
+	runs := []Run{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&runs))
+	assert.Len(t, runs, 1)
+}
+
+func TestSecretsAreMasked(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]interface{}{}
+	s := newTestServer(t, 1).WithPipeline("deploy", func(ctx context.Context, log *slog.Logger) anypipe.Anypipe {
+		return gatePipeline(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+			log.Info("the token is hunter2")
+			return approval.Decision{Approved: true, Approver: "alice"}, nil
+		})(ctx, log).WithEventHandler(func(e anypipe.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[string(e.Type)] = e
+		})
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp := do(t, http.MethodPost, srv.URL+"/pipelines/deploy/runs", `{"secrets":{"token":"hunter2"}}`)
+	var run Run
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&run))
+
+	resp = do(t, http.MethodGet, srv.URL+"/runs/"+run.ID+"/events", "")
+	b, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(b), "hunter2")
+	assert.Contains(t, string(b), "the token is ***")
+
+	// the pipeline's own handlers still receive the events
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, seen, "pipeline_finished")
+}
+
+func TestQueue(t *testing.T) {
+	b := newBlockingApprover()
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(b.await))
+
+	first, err := s.Trigger("deploy", map[string]interface{}{})
+	assert.NoError(t, err)
+	second, err := s.Trigger("deploy", map[string]interface{}{})
+	assert.NoError(t, err)
+	third, err := s.Trigger("deploy", map[string]interface{}{})
+	assert.NoError(t, err)
+
+	status := func(id string) Status {
+		r, err := s.Get(id)
+		assert.NoError(t, err)
+		return r.Status
+	}
+	assert.Equal(t, StatusRunning, status(first.ID))
+	assert.Equal(t, StatusQueued, status(second.ID))
+	assert.Equal(t, StatusQueued, status(third.ID))
+
+	// a queued run never starts once cancelled
+	assert.NoError(t, s.Cancel(second.ID))
+	r, _ := s.Get(second.ID)
+	assert.Equal(t, StatusCancelled, r.Status)
+	assert.Equal(t, "cancelled before it started", r.Error)
+	assert.Nil(t, r.StartedAt)
+
+	close(b.release)
+	assert.Eventually(t, func() bool { return status(third.ID) == StatusPassed }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, StatusPassed, status(first.ID))
+
+	runs := []string{}
+	for _, r := range s.Runs() {
+		runs = append(runs, r.ID)
+	}
+	assert.Equal(t, []string{third.ID, second.ID, first.ID}, runs)
+}
+
+func TestConcurrency(t *testing.T) {
+	b := newBlockingApprover()
+	s := newTestServer(t, 2).WithPipeline("deploy", gatePipeline(b.await))
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Trigger("deploy", map[string]interface{}{})
+		assert.NoError(t, err)
+	}
+
+	statuses := []Status{}
+	for _, r := range s.Runs() {
+		statuses = append(statuses, r.Status)
+	}
+	assert.Equal(t, []Status{StatusQueued, StatusRunning, StatusRunning}, statuses)
+	close(b.release)
+}
+
+func TestCancelRunningRun(t *testing.T) {
+	b := newBlockingApprover()
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(b.await))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	run, err := s.Trigger("deploy", map[string]interface{}{})
+	assert.NoError(t, err)
+	<-b.waiting
+
+	resp := do(t, http.MethodPost, srv.URL+"/runs/"+run.ID+"/cancel", "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/runs/"+run.ID+"/events", "")
+	events := readEvents(t, resp.Body)
+	var end Run
+	assert.NoError(t, json.Unmarshal([]byte(events[len(events)-1].data), &end))
+	assert.Equal(t, StatusCancelled, end.Status)
+	assert.Equal(t, []anypipe.JobResult{{Job: "approve", Result: anypipe.ResultCancelled, Reason: "cancelled: cancelled through the API"}}, end.Results)
+
+	resp = do(t, http.MethodPost, srv.URL+"/runs/"+run.ID+"/cancel", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestFailedRuns(t *testing.T) {
+	type testcase struct {
+		name     string
+		factory  Factory
+		expected string
+	}
+
+	testcases := []testcase{
+		{
+			name: "rejected",
+			factory: gatePipeline(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				return approval.Decision{Approver: "bob"}, nil
+			}),
+			expected: "rejected by bob",
+		},
+		{
+			name: "approver error",
+			factory: gatePipeline(func(ctx context.Context, req approval.Request) (approval.Decision, error) {
+				return approval.Decision{}, errors.New("no approvers available")
+			}),
+			expected: "no approvers available",
+		},
+		{
+			name: "panic",
+			factory: func(ctx context.Context, log *slog.Logger) anypipe.Anypipe {
+				panic("bad factory")
+			},
+			expected: "panic: bad factory",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestServer(t, 1).WithPipeline("deploy", tc.factory)
+			run, err := s.Trigger("deploy", map[string]interface{}{})
+			assert.NoError(t, err)
+
+			assert.Eventually(t, func() bool {
+				run, _ = s.Get(run.ID)
+				return run.FinishedAt != nil
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, StatusFailed, run.Status)
+			assert.Contains(t, run.Error+describeResults(run.Results), tc.expected)
+		})
+	}
+}
+
+func describeResults(results []anypipe.JobResult) string {
+	reasons := []string{}
+	for _, r := range results {
+		reasons = append(reasons, r.Reason)
+	}
+
+	return strings.Join(reasons, "\n")
+}
+
+func TestKeepRuns(t *testing.T) {
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(approve))
+	s.KeepRuns = 2
+
+	ids := []string{}
+	for i := 0; i < 4; i++ {
+		run, err := s.Trigger("deploy", map[string]interface{}{})
+		assert.NoError(t, err)
+		ids = append(ids, run.ID)
+		assert.Eventually(t, func() bool {
+			run, _ := s.Get(run.ID)
+			return run.FinishedAt != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	runs := []string{}
+	for _, r := range s.Runs() {
+		runs = append(runs, r.ID)
+	}
+	assert.Equal(t, []string{ids[3], ids[2]}, runs)
+
+	_, err := s.Get(ids[0])
+	assert.ErrorIs(t, err, ErrUnknownRun)
+}
+
+func TestErrors(t *testing.T) {
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(approve))
+	s.Token = "s3cret"
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	type testcase struct {
+		name   string
+		method string
+		path   string
+		body   string
+		token  string
+		status int
+	}
+
+	testcases := []testcase{
+		{name: "no token", method: http.MethodGet, path: "/pipelines", status: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/pipelines", token: "guess", status: http.StatusUnauthorized},
+		{name: "unknown pipeline", method: http.MethodPost, path: "/pipelines/build/runs", token: "s3cret", status: http.StatusNotFound},
+		{name: "invalid body", method: http.MethodPost, path: "/pipelines/deploy/runs", body: "{", token: "s3cret", status: http.StatusBadRequest},
+		{name: "unknown run", method: http.MethodGet, path: "/runs/abc", token: "s3cret", status: http.StatusNotFound},
+		{name: "events of unknown run", method: http.MethodGet, path: "/runs/abc/events", token: "s3cret", status: http.StatusNotFound},
+		{name: "cancel unknown run", method: http.MethodPost, path: "/runs/abc/cancel", token: "s3cret", status: http.StatusNotFound},
+		{name: "no body", method: http.MethodPost, path: "/pipelines/deploy/runs", token: "s3cret", status: http.StatusAccepted},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := []string{}
+			if len(tc.token) > 0 {
+				headers = append(headers, "Authorization", "Bearer "+tc.token)
+			}
+
+			resp := do(t, tc.method, srv.URL+tc.path, tc.body, headers...)
+			assert.Equal(t, tc.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	s := newTestServer(t, 1).WithPipeline("deploy", gatePipeline(approve)).WithPipeline("build", gatePipeline(approve))
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	assert.Equal(t, 0, s.Command([]string{"help"}, stdout, stderr))
+	assert.Contains(t, stdout.String(), "pipelines:\n  build\n  deploy\n")
+
+	assert.Equal(t, 2, s.Command([]string{"run"}, stdout, stderr))
+	assert.Equal(t, 2, s.Command([]string{"serve", "-concurrency", "x"}, stdout, stderr))
+}
@@ -0,0 +1,280 @@
+package steps
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+const (
+	TestPass = "pass"
+	TestFail = "fail"
+	TestSkip = "skip"
+)
+
+type GoTestOptions struct {
+	// directory of the module, defaults to the exec working directory
+	Dir string
+	// packages to test, defaults to ./...
+	Packages []string
+	Race     bool
+	// if set, a coverage profile is written to this path, relative to Dir
+	CoverProfile string
+	// extra flags passed to go test, e.g. -run or -count=1
+	Flags []string
+	Env   map[string]string
+	// if set, the *TestReport is stored in this variable
+	ReportVariable string
+}
+
+// a single event of `go test -json`, as documented by `go doc test2json`
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type TestResult struct {
+	Package string
+	Test    string
+	// one of TestPass, TestFail or TestSkip
+	Result  string
+	Elapsed time.Duration
+	// output of the test, only kept for failed and skipped tests
+	Output string
+}
+
+type PackageResult struct {
+	Package string
+	Result  string
+	Elapsed time.Duration
+	// percentage of statements covered, -1 if coverage wasn't measured
+	Coverage float64
+}
+
+// TestReport holds the outcome of each test and package run by GoTest
+type TestReport struct {
+	Tests    []TestResult
+	Packages []PackageResult
+}
+
+func (r *TestReport) count(result string) int {
+	n := 0
+	for _, t := range r.Tests {
+		if t.Result == result {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (r *TestReport) Passed() int {
+	return r.count(TestPass)
+}
+
+func (r *TestReport) Failed() int {
+	return r.count(TestFail)
+}
+
+func (r *TestReport) Skipped() int {
+	return r.count(TestSkip)
+}
+
+// names of the failed tests, as <package>.<test>
+func (r *TestReport) FailedTests() []string {
+	failed := []string{}
+	for _, t := range r.Tests {
+		if t.Result == TestFail {
+			failed = append(failed, fmt.Sprintf("%s.%s", t.Package, t.Test))
+		}
+	}
+
+	return failed
+}
+
+var coverageRegex = regexp.MustCompile(`coverage: ([0-9.]+)% of statements`)
+
+// parses the output of `go test -json`, ignoring lines that aren't test events, e.g. build errors
+func ParseTestOutput(output string) (*TestReport, error) {
+	report := &TestReport{}
+	outputs := map[string]*strings.Builder{}
+	coverage := map[string]float64{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+
+		e := testEvent{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+
+		key := e.Package + "." + e.Test
+		switch e.Action {
+		case "output":
+			if len(e.Test) == 0 {
+				if m := coverageRegex.FindStringSubmatch(e.Output); m != nil {
+					coverage[e.Package], _ = strconv.ParseFloat(m[1], 64)
+				}
+				continue
+			}
+			if outputs[key] == nil {
+				outputs[key] = &strings.Builder{}
+			}
+			outputs[key].WriteString(e.Output)
+		case TestPass, TestFail, TestSkip:
+			elapsed := time.Duration(e.Elapsed * float64(time.Second))
+			if len(e.Test) == 0 {
+				report.Packages = append(report.Packages, PackageResult{Package: e.Package, Result: e.Action, Elapsed: elapsed, Coverage: -1})
+				continue
+			}
+
+			t := TestResult{Package: e.Package, Test: e.Test, Result: e.Action, Elapsed: elapsed}
+			if e.Action != TestPass && outputs[key] != nil {
+				t.Output = outputs[key].String()
+			}
+			delete(outputs, key)
+			report.Tests = append(report.Tests, t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, p := range report.Packages {
+		if c, ok := coverage[p.Package]; ok {
+			report.Packages[i].Coverage = c
+		}
+	}
+
+	return report, nil
+}
+
+// runs `go test -json`, failing the step if any test or package fails
+func GoTest(opts GoTestOptions) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		args := []string{"go", "test", "-json"}
+		if opts.Race {
+			args = append(args, "-race")
+		}
+		if len(opts.CoverProfile) > 0 {
+			args = append(args, "-coverprofile="+anypipe.ShellQuote(opts.CoverProfile))
+		}
+		for _, f := range opts.Flags {
+			args = append(args, anypipe.ShellQuote(f))
+		}
+		packages := opts.Packages
+		if len(packages) == 0 {
+			packages = []string{"./..."}
+		}
+		for _, p := range packages {
+			args = append(args, anypipe.ShellQuote(p))
+		}
+
+		env, err := interpolateEnv(opts.Env, variables)
+		if err != nil {
+			return err
+		}
+
+		stdout, stderr, exitcode, err := du.Exec(c, command(opts.Dir, env, strings.Join(args, " ")))
+		if err != nil {
+			return err
+		}
+
+		report, err := ParseTestOutput(stdout)
+		if err != nil {
+			return fmt.Errorf("failed to parse go test output: %w", err)
+		}
+		if len(opts.ReportVariable) > 0 {
+			variables[opts.ReportVariable] = report
+		}
+
+		if failed := report.FailedTests(); len(failed) > 0 {
+			return fmt.Errorf("%d of %d tests failed: %s", len(failed), len(report.Tests), strings.Join(failed, ", "))
+		}
+		if exitcode != 0 {
+			return fmt.Errorf("go test exited with code %d:\n%s", exitcode, tail(stderr, errorTailLines))
+		}
+
+		return nil
+	}
+}
+
+type GoBuildOptions struct {
+	// directory of the module, defaults to the exec working directory
+	Dir string
+	// package to build, defaults to .
+	Package string
+	// path of the binary, relative to Dir
+	Output string
+	GOOS   string
+	GOARCH string
+	// builds a statically linked binary, with CGO_ENABLED=0
+	Static   bool
+	LDFlags  string
+	Tags     []string
+	Trimpath bool
+	Env      map[string]string
+}
+
+// runs go build, e.g. to cross-compile a binary collected afterwards with CollectArtifacts
+func GoBuild(opts GoBuildOptions) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		env, err := interpolateEnv(opts.Env, variables)
+		if err != nil {
+			return err
+		}
+		// e.g. -X main.version=${{ vars.version }}
+		ldflags, err := anypipe.Interpolate(opts.LDFlags, variables)
+		if err != nil {
+			return err
+		}
+
+		if len(opts.GOOS) > 0 {
+			env["GOOS"] = opts.GOOS
+		}
+		if len(opts.GOARCH) > 0 {
+			env["GOARCH"] = opts.GOARCH
+		}
+		if opts.Static {
+			env["CGO_ENABLED"] = "0"
+		}
+
+		args := []string{"go", "build"}
+		if len(opts.Output) > 0 {
+			args = append(args, "-o", anypipe.ShellQuote(opts.Output))
+		}
+		if opts.Trimpath {
+			args = append(args, "-trimpath")
+		}
+		if len(ldflags) > 0 {
+			args = append(args, "-ldflags", anypipe.ShellQuote(ldflags))
+		}
+		if len(opts.Tags) > 0 {
+			args = append(args, "-tags", anypipe.ShellQuote(strings.Join(opts.Tags, ",")))
+		}
+		pkg := opts.Package
+		if len(pkg) == 0 {
+			pkg = "."
+		}
+		args = append(args, anypipe.ShellQuote(pkg))
+
+		_, err = run(du, c, command(opts.Dir, env, strings.Join(args, " ")))
+		return err
+	}
+}
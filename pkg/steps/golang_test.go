@@ -0,0 +1,139 @@
+package steps
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+var testOutput = strings.Join([]string{
+	`{"Action":"start","Package":"example.com/a"}`,
+	`{"Action":"run","Package":"example.com/a","Test":"TestOk"}`,
+	`{"Action":"output","Package":"example.com/a","Test":"TestOk","Output":"=== RUN   TestOk\n"}`,
+	`{"Action":"pass","Package":"example.com/a","Test":"TestOk","Elapsed":0.5}`,
+	`{"Action":"run","Package":"example.com/a","Test":"TestBroken"}`,
+	`{"Action":"output","Package":"example.com/a","Test":"TestBroken","Output":"    a_test.go:12: expected 1, got 2\n"}`,
+	`{"Action":"fail","Package":"example.com/a","Test":"TestBroken","Elapsed":0.25}`,
+	`{"Action":"run","Package":"example.com/a","Test":"TestLater"}`,
+	`{"Action":"output","Package":"example.com/a","Test":"TestLater","Output":"    a_test.go:20: later\n"}`,
+	`{"Action":"skip","Package":"example.com/a","Test":"TestLater","Elapsed":0}`,
+	`{"Action":"output","Package":"example.com/a","Output":"coverage: 81.5% of statements\n"}`,
+	`{"Action":"fail","Package":"example.com/a","Elapsed":1.5}`,
+	`# example.com/b`,
+	`{"Action":"pass","Package":"example.com/c","Elapsed":0.1}`,
+}, "\n")
+
+func TestParseTestOutput(t *testing.T) {
+	report, err := ParseTestOutput(testOutput)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []TestResult{
+		{Package: "example.com/a", Test: "TestOk", Result: TestPass, Elapsed: 500 * time.Millisecond},
+		{Package: "example.com/a", Test: "TestBroken", Result: TestFail, Elapsed: 250 * time.Millisecond, Output: "    a_test.go:12: expected 1, got 2\n"},
+		{Package: "example.com/a", Test: "TestLater", Result: TestSkip, Output: "    a_test.go:20: later\n"},
+	}, report.Tests)
+	assert.Equal(t, []PackageResult{
+		{Package: "example.com/a", Result: TestFail, Elapsed: 1500 * time.Millisecond, Coverage: 81.5},
+		{Package: "example.com/c", Result: TestPass, Elapsed: 100 * time.Millisecond, Coverage: -1},
+	}, report.Packages)
+	assert.Equal(t, 1, report.Passed())
+	assert.Equal(t, 1, report.Failed())
+	assert.Equal(t, 1, report.Skipped())
+	assert.Equal(t, []string{"example.com/a.TestBroken"}, report.FailedTests())
+}
+
+func TestGoTest(t *testing.T) {
+	type testcase struct {
+		name        string
+		opts        GoTestOptions
+		expectedCmd string
+		stdout      string
+		exitcode    int
+		expectedErr string
+	}
+
+	testcases := []testcase{
+		{
+			name:        "tests all packages",
+			expectedCmd: "go test -json './...'",
+			stdout:      `{"Action":"pass","Package":"example.com/c","Elapsed":0.1}`,
+		},
+		{
+			name:        "passes the options",
+			opts:        GoTestOptions{Dir: "/src", Packages: []string{"./pkg/..."}, Race: true, CoverProfile: "cover.out", Flags: []string{"-count=1"}},
+			expectedCmd: "cd '/src' && go test -json -race -coverprofile='cover.out' '-count=1' './pkg/...'",
+			stdout:      `{"Action":"pass","Package":"example.com/c","Elapsed":0.1}`,
+		},
+		{
+			name:        "fails with the failed tests",
+			expectedCmd: "go test -json './...'",
+			stdout:      testOutput,
+			exitcode:    1,
+			expectedErr: "1 of 3 tests failed: example.com/a.TestBroken",
+		},
+		{
+			name:        "fails when the build fails",
+			expectedCmd: "go test -json './...'",
+			exitcode:    1,
+			expectedErr: "go test exited with code 1:\nbuild failed",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().Exec(c, tc.expectedCmd).Return(tc.stdout, "build failed", tc.exitcode, nil)
+
+			tc.opts.ReportVariable = "report"
+			variables := map[string]interface{}{}
+			err := GoTest(tc.opts)(du, c, variables)
+			if len(tc.expectedErr) > 0 {
+				assert.EqualError(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.IsType(t, &TestReport{}, variables["report"])
+		})
+	}
+}
+
+func TestGoBuild(t *testing.T) {
+	type testcase struct {
+		name        string
+		opts        GoBuildOptions
+		expectedCmd string
+	}
+
+	testcases := []testcase{
+		{
+			name:        "builds the current package",
+			expectedCmd: "go build '.'",
+		},
+		{
+			name:        "cross-compiles a static binary",
+			opts:        GoBuildOptions{Dir: "/src", Package: "./cmd/app", Output: "dist/app", GOOS: "linux", GOARCH: "arm64", Static: true, LDFlags: "-s -w", Tags: []string{"netgo", "osusergo"}, Trimpath: true},
+			expectedCmd: `cd '/src' && CGO_ENABLED='0' GOARCH='arm64' GOOS='linux' sh -c 'go build -o '\''dist/app'\'' -trimpath -ldflags '\''-s -w'\'' -tags '\''netgo,osusergo'\'' '\''./cmd/app'\'''`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().Exec(c, tc.expectedCmd).Return("", "", 0, nil)
+
+			assert.NoError(t, GoBuild(tc.opts)(du, c, map[string]interface{}{}))
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Package steps provides configurable building blocks for anypipe jobs, implemented on top of DockerUtils
+package steps
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/anypipe"
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+)
+
+// number of lines of output included in the error of a failed command
+const errorTailLines = 20
+
+type ShellOptions struct {
+	// directory the command runs in
+	Dir string
+	// environment variables set for the command
+	Env map[string]string
+	// if set, the command's stdout is stored in this variable, without its trailing newline
+	OutputVariable string
+}
+
+// runs cmd with `sh -c`, failing the step on a non-zero exit code. references to variables in cmd
+// (see anypipe.Interpolate) are replaced with their shell-quoted value
+func Shell(cmd string) anypipe.StepFunc {
+	return ShellWithOptions(cmd, ShellOptions{})
+}
+
+func ShellWithOptions(cmd string, opts ShellOptions) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		expanded, err := anypipe.InterpolateShell(cmd, variables)
+		if err != nil {
+			return err
+		}
+		dir, err := anypipe.Interpolate(opts.Dir, variables)
+		if err != nil {
+			return err
+		}
+		env, err := interpolateEnv(opts.Env, variables)
+		if err != nil {
+			return err
+		}
+
+		stdout, err := run(du, c, command(dir, env, expanded))
+		if err != nil {
+			return err
+		}
+
+		if len(opts.OutputVariable) > 0 {
+			variables[opts.OutputVariable] = strings.TrimRight(stdout, "\n")
+		}
+
+		return nil
+	}
+}
+
+// copies the src directory on the host into dst in the container, creating dst if needed
+func CopyWorkspace(src, dst string) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		dst, err := anypipe.Interpolate(dst, variables)
+		if err != nil {
+			return err
+		}
+		if _, err := run(du, c, fmt.Sprintf("mkdir -p %s", anypipe.ShellQuote(dst))); err != nil {
+			return err
+		}
+
+		if err := du.CopyTo(c, src, dst); err != nil {
+			return fmt.Errorf("failed to copy workspace %s to %s: %w", src, dst, err)
+		}
+
+		return nil
+	}
+}
+
+// writes content to a file in the container, creating its directory if needed
+func WriteFile(dst string, content []byte, mode os.FileMode) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		dst, err := anypipe.Interpolate(dst, variables)
+		if err != nil {
+			return err
+		}
+
+		tmp, err := os.MkdirTemp("", "anypipe-file")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+
+		// the directory is copied, so the file keeps its name and mode
+		if err := os.WriteFile(filepath.Join(tmp, path.Base(dst)), content, mode); err != nil {
+			return err
+		}
+
+		dir := path.Dir(dst)
+		if _, err := run(du, c, fmt.Sprintf("mkdir -p %s", anypipe.ShellQuote(dir))); err != nil {
+			return err
+		}
+
+		if err := du.CopyTo(c, tmp, dir); err != nil {
+			return fmt.Errorf("failed to write %s: %w", dst, err)
+		}
+
+		return nil
+	}
+}
+
+// copies the files and directories matching the globs out of the container into dst on the host. the step
+// fails if a glob matches nothing
+func CollectArtifacts(dst string, globs ...string) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			return err
+		}
+
+		for _, glob := range globs {
+			glob, err := anypipe.InterpolateShell(glob, variables)
+			if err != nil {
+				return err
+			}
+
+			// the glob is expanded by the shell, a pattern that matches nothing is kept as is. matches are printed
+			// one per line, as they may contain spaces
+			stdout, err := run(du, c, fmt.Sprintf(`for p in %s; do [ -e "$p" ] && printf '%%s\n' "$p"; done; true`, glob))
+			if err != nil {
+				return err
+			}
+
+			paths := []string{}
+			for _, p := range strings.Split(stdout, "\n") {
+				if len(p) > 0 {
+					paths = append(paths, p)
+				}
+			}
+			if len(paths) == 0 {
+				return fmt.Errorf("no files match %s", glob)
+			}
+
+			for _, p := range paths {
+				// relative to the working directory of Exec, while copies are resolved from /
+				if !path.IsAbs(p) {
+					p = path.Join(dockerutils.WorkingDir, p)
+				}
+				if err := du.CopyFrom(c, p, dst); err != nil {
+					return fmt.Errorf("failed to collect %s: %w", p, err)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// waits until a TCP connection to host:port succeeds from inside the container, e.g. for a service started
+// in the background by a previous step. the container needs nc or bash
+func WaitForPort(host string, port int, timeout time.Duration) anypipe.StepFunc {
+	return func(du dockerutils.DockerUtils, c *dockerutils.Container, variables map[string]interface{}) error {
+		addr := fmt.Sprintf("%s:%d", host, port)
+		probe := fmt.Sprintf("nc -z -w 1 %[1]s %[2]d 2>/dev/null || bash -c 'exec 3<>/dev/tcp/%[1]s/%[2]d' 2>/dev/null", anypipe.ShellQuote(host), port)
+		interval := min(time.Second, timeout/10)
+
+		deadline := time.Now().Add(timeout)
+		for {
+			_, _, exitcode, err := du.Exec(c, probe)
+			if err != nil {
+				return err
+			}
+			if exitcode == 0 {
+				return nil
+			}
+
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out after %s waiting for %s", timeout, addr)
+			}
+			time.Sleep(interval)
+		}
+	}
+}
+
+// executes cmd, returning an error with the end of its output if it exits with a non-zero code
+func run(du dockerutils.DockerUtils, c *dockerutils.Container, cmd string) (string, error) {
+	stdout, stderr, exitcode, err := du.Exec(c, cmd)
+	if err != nil {
+		return stdout, err
+	}
+
+	if exitcode != 0 {
+		return stdout, fmt.Errorf("%s exited with code %d:\n%s", cmd, exitcode, tail(stdout+stderr, errorTailLines))
+	}
+
+	return stdout, nil
+}
+
+// returns env with the references in its values interpolated
+func interpolateEnv(env map[string]string, variables map[string]interface{}) (map[string]string, error) {
+	expanded := map[string]string{}
+	for k, v := range env {
+		e, err := anypipe.Interpolate(v, variables)
+		if err != nil {
+			return nil, err
+		}
+		expanded[k] = e
+	}
+
+	return expanded, nil
+}
+
+// prefixes cmd with a change of directory and the environment variables, sorted by name
+func command(dir string, env map[string]string, cmd string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	prefix := ""
+	if len(dir) > 0 {
+		prefix = fmt.Sprintf("cd %s && ", anypipe.ShellQuote(dir))
+	}
+	for _, k := range keys {
+		prefix += fmt.Sprintf("%s=%s ", k, anypipe.ShellQuote(env[k]))
+	}
+
+	// the environment variables only apply to the first command of a list, so it is run in a sub-shell
+	if len(keys) > 0 {
+		return fmt.Sprintf("%ssh -c %s", prefix, anypipe.ShellQuote(cmd))
+	}
+
+	return prefix + cmd
+}
+
+func tail(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
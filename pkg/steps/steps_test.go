@@ -0,0 +1,212 @@
+package steps
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/notmiguelalves/anypipe/pkg/dockerutils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestShell(t *testing.T) {
+	type testcase struct {
+		name             string
+		opts             ShellOptions
+		expectedCmd      string
+		exitcode         int
+		execErr          error
+		expectedErr      string
+		expectedVariable interface{}
+	}
+
+	testcases := []testcase{
+		{
+			name:        "runs the command",
+			expectedCmd: "make test",
+		},
+		{
+			name:        "runs the command in a directory with env variables",
+			opts:        ShellOptions{Dir: "/src", Env: map[string]string{"B": "2", "A": "it's"}},
+			expectedCmd: `cd '/src' && A='it'\''s' B='2' sh -c 'make test'`,
+		},
+		{
+			name:             "stores stdout in a variable",
+			opts:             ShellOptions{OutputVariable: "out"},
+			expectedCmd:      "make test",
+			expectedVariable: "some output",
+		},
+		{
+			name:        "fails on a non-zero exit code",
+			expectedCmd: "make test",
+			exitcode:    2,
+			expectedErr: "make test exited with code 2:\nsome output\nsome error",
+		},
+		{
+			name:        "fails when the command can't be executed",
+			expectedCmd: "make test",
+			execErr:     errors.New("no such container"),
+			expectedErr: "no such container",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().Exec(c, tc.expectedCmd).Return("some output\n", "some error", tc.exitcode, tc.execErr)
+
+			variables := map[string]interface{}{}
+			err := ShellWithOptions("make test", tc.opts)(du, c, variables)
+			if len(tc.expectedErr) > 0 {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedVariable, variables["out"])
+		})
+	}
+}
+
+func TestCopyWorkspace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := &dockerutils.Container{}
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	gomock.InOrder(
+		du.EXPECT().Exec(c, "mkdir -p '/src'").Return("", "", 0, nil),
+		du.EXPECT().CopyTo(c, ".", "/src").Return(nil),
+	)
+
+	assert.NoError(t, CopyWorkspace(".", "/src")(du, c, map[string]interface{}{}))
+}
+
+func TestWriteFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := &dockerutils.Container{}
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().Exec(c, "mkdir -p '/etc/app'").Return("", "", 0, nil)
+	du.EXPECT().CopyTo(c, gomock.Any(), "/etc/app").DoAndReturn(func(c *dockerutils.Container, src, dst string) error {
+		content, err := os.ReadFile(filepath.Join(src, "config.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, "debug: true\n", string(content))
+
+		fi, err := os.Stat(filepath.Join(src, "config.yaml"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+		return nil
+	})
+
+	assert.NoError(t, WriteFile("/etc/app/config.yaml", []byte("debug: true\n"), 0o600)(du, c, map[string]interface{}{}))
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	type testcase struct {
+		name        string
+		matches     string
+		expectedErr string
+	}
+
+	testcases := []testcase{
+		{
+			name:    "copies every match",
+			matches: "dist/app linux\n/home/dist/app-darwin\n",
+		},
+		{
+			name:        "fails when nothing matches",
+			expectedErr: "no files match dist/*",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			dst := t.TempDir()
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			du.EXPECT().Exec(c, `for p in dist/*; do [ -e "$p" ] && printf '%s\n' "$p"; done; true`).Return(tc.matches, "", 0, nil)
+			if len(tc.matches) > 0 {
+				du.EXPECT().CopyFrom(c, "/home/dist/app linux", dst).Return(nil)
+				du.EXPECT().CopyFrom(c, "/home/dist/app-darwin", dst).Return(nil)
+			}
+
+			err := CollectArtifacts(dst, "dist/*")(du, c, map[string]interface{}{})
+			if len(tc.expectedErr) > 0 {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWaitForPort(t *testing.T) {
+	type testcase struct {
+		name        string
+		exitcodes   []int
+		expectedErr bool
+	}
+
+	testcases := []testcase{
+		{
+			name:      "returns once the port accepts connections",
+			exitcodes: []int{1, 1, 0},
+		},
+		{
+			name:        "times out",
+			exitcodes:   []int{1},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			c := &dockerutils.Container{}
+			du := dockerutils.NewMockDockerUtils(ctrl)
+			calls := 0
+			du.EXPECT().Exec(c, gomock.Any()).AnyTimes().DoAndReturn(func(c *dockerutils.Container, cmd string) (string, string, int, error) {
+				exitcode := tc.exitcodes[min(calls, len(tc.exitcodes)-1)]
+				calls++
+				return "", "", exitcode, nil
+			})
+
+			err := WaitForPort("db", 5432, 50*time.Millisecond)(du, c, map[string]interface{}{})
+			if tc.expectedErr {
+				assert.EqualError(t, err, "timed out after 50ms waiting for db:5432")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, len(tc.exitcodes), calls)
+		})
+	}
+}
+
+func TestShellInterpolation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := &dockerutils.Container{}
+	du := dockerutils.NewMockDockerUtils(ctrl)
+	du.EXPECT().Exec(c, `cd '/src/app' && TARGET='linux' sh -c 'cat '\''my file; rm -rf /'\'''`).Return("", "", 0, nil)
+
+	variables := map[string]interface{}{"file": "my file; rm -rf /", "app": "app", "target": "linux"}
+	step := ShellWithOptions("cat ${{ vars.file }}", ShellOptions{Dir: "/src/${{ vars.app }}", Env: map[string]string{"TARGET": "${{ vars.target }}"}})
+	assert.NoError(t, step(du, c, variables))
+
+	assert.ErrorContains(t, Shell("cat ${{ vars.missing }}")(du, c, variables), "variable missing is not set")
+}
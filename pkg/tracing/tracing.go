@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func newProvider(exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "anypipe"))),
+	)
+}
+
+// creates a tracer provider exporting spans over OTLP/HTTP to endpoint (host:port).
+// when endpoint is empty the standard OTEL_EXPORTER_OTLP_* env variables are used.
+// make sure to defer a call to Shutdown() the provider, so pending spans are flushed
+func NewOTLPProvider(ctx context.Context, endpoint string, insecure bool) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracehttp.Option{}
+	if len(endpoint) > 0 {
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+	}
+	if insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newProvider(exporter), nil
+}
+
+// fileExporter writes spans as JSON to a file, closing it on shutdown
+type fileExporter struct {
+	*stdouttrace.Exporter
+	f *os.File
+}
+
+func (fe *fileExporter) Shutdown(ctx context.Context) error {
+	err := fe.Exporter.Shutdown(ctx)
+	if closeErr := fe.f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// creates a tracer provider writing spans as JSON objects to the file at path.
+// make sure to defer a call to Shutdown() the provider, so pending spans are flushed
+func NewFileProvider(path string) (*sdktrace.TracerProvider, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return newProvider(&fileExporter{Exporter: exporter, f: f}), nil
+}
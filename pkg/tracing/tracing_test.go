@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	tp, err := NewFileProvider(path)
+	assert.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "some span")
+	span.End()
+
+	assert.NoError(t, tp.Shutdown(context.Background()))
+
+	res, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(res), `"Name":"some span"`)
+	assert.Contains(t, string(res), `"Value":"anypipe"`)
+}
+
+func TestFileProviderBadPath(t *testing.T) {
+	_, err := NewFileProvider(filepath.Join(t.TempDir(), "missing", "traces.json"))
+	assert.Error(t, err)
+}
+
+func TestOTLPProvider(t *testing.T) {
+	tp, err := NewOTLPProvider(context.Background(), "localhost:4318", true)
+	assert.NoError(t, err)
+	assert.NotNil(t, tp)
+}
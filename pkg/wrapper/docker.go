@@ -14,7 +14,8 @@ import (
 type DockerClient interface {
 	ContainerRemove(containerID string, options container.RemoveOptions) error
 	ImagePull(refStr string, options image.PullOptions) (io.ReadCloser, error)
-	ContainerCreate(config *container.Config) (container.CreateResponse, error)
+	ImageInspect(imageID string) (types.ImageInspect, error)
+	ContainerCreate(config *container.Config, hostConfig *container.HostConfig) (container.CreateResponse, error)
 	ContainerStart(containerID string, options container.StartOptions) error
 	ContainerExecCreate(container string, options container.ExecOptions) (types.IDResponse, error)
 	ContainerExecAttach(execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
@@ -50,8 +51,13 @@ func (wc *WrapperClient) ImagePull(refStr string, options image.PullOptions) (io
 	return wc.dockerClient.ImagePull(wc.ctx, refStr, options)
 }
 
-func (wc *WrapperClient) ContainerCreate(config *container.Config) (container.CreateResponse, error) {
-	return wc.dockerClient.ContainerCreate(wc.ctx, config, nil, nil, nil, "")
+func (wc *WrapperClient) ImageInspect(imageID string) (types.ImageInspect, error) {
+	inspect, _, err := wc.dockerClient.ImageInspectWithRaw(wc.ctx, imageID)
+	return inspect, err
+}
+
+func (wc *WrapperClient) ContainerCreate(config *container.Config, hostConfig *container.HostConfig) (container.CreateResponse, error) {
+	return wc.dockerClient.ContainerCreate(wc.ctx, config, hostConfig, nil, nil, "")
 }
 
 func (wc *WrapperClient) ContainerStart(containerID string, options container.StartOptions) error {
@@ -57,18 +57,18 @@ func (mr *MockDockerClientMockRecorder) Close() *gomock.Call {
 }
 
 // ContainerCreate mocks base method.
-func (m *MockDockerClient) ContainerCreate(config *container.Config) (container.CreateResponse, error) {
+func (m *MockDockerClient) ContainerCreate(config *container.Config, hostConfig *container.HostConfig) (container.CreateResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ContainerCreate", config)
+	ret := m.ctrl.Call(m, "ContainerCreate", config, hostConfig)
 	ret0, _ := ret[0].(container.CreateResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ContainerCreate indicates an expected call of ContainerCreate.
-func (mr *MockDockerClientMockRecorder) ContainerCreate(config any) *gomock.Call {
+func (mr *MockDockerClientMockRecorder) ContainerCreate(config, hostConfig any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerCreate", reflect.TypeOf((*MockDockerClient)(nil).ContainerCreate), config)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerCreate", reflect.TypeOf((*MockDockerClient)(nil).ContainerCreate), config, hostConfig)
 }
 
 // ContainerExecAttach mocks base method.
@@ -188,6 +188,21 @@ func (mr *MockDockerClientMockRecorder) CopyToContainer(containerID, dstPath, co
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyToContainer", reflect.TypeOf((*MockDockerClient)(nil).CopyToContainer), containerID, dstPath, content, options)
 }
 
+// ImageInspect mocks base method.
+func (m *MockDockerClient) ImageInspect(imageID string) (types.ImageInspect, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageInspect", imageID)
+	ret0, _ := ret[0].(types.ImageInspect)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageInspect indicates an expected call of ImageInspect.
+func (mr *MockDockerClientMockRecorder) ImageInspect(imageID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageInspect", reflect.TypeOf((*MockDockerClient)(nil).ImageInspect), imageID)
+}
+
 // ImagePull mocks base method.
 func (m *MockDockerClient) ImagePull(refStr string, options image.PullOptions) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()